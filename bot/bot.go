@@ -14,6 +14,7 @@ import (
 	"github.com/velour/catbase/bot/msglog"
 	"github.com/velour/catbase/bot/user"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
 )
 
 // bot type provides storage for bot-wide information, configs, and database connections
@@ -93,14 +94,14 @@ func New(config *config.Config, connector Connector) Bot {
 
 	connector.RegisterMessageReceived(bot.MsgReceived)
 	connector.RegisterEventReceived(bot.EventReceived)
-	connector.RegisterReplyMessageReceived(bot.ReplyMsgReceived)
 
 	return bot
 }
 
-// Config gets the configuration that the bot is using
+// Config gets the configuration that the bot is using, with the settings
+// changed since it started
 func (b *bot) Config() *config.Config {
-	return b.config
+	return b.config.Current()
 }
 
 func (b *bot) DBVersion() int64 {
@@ -111,11 +112,15 @@ func (b *bot) DB() *sqlx.DB {
 	return b.db
 }
 
+func (b *bot) KV(namespace string) *KV {
+	return newKV(b.db, namespace)
+}
+
 // Create any tables if necessary based on version of DB
 // Plugins should create their own tables, these are only for official bot stuff
 // Note: This does not return an error. Database issues are all fatal at this stage.
 func (b *bot) migrateDB() {
-	_, err := b.db.Exec(`create table if not exists version (version integer);`)
+	err := database.CreateTable(b.db, `create table if not exists version (version integer);`)
 	if err != nil {
 		log.Fatal("Initial DB migration create version table: ", err)
 	}
@@ -135,13 +140,14 @@ func (b *bot) migrateDB() {
 		}
 	}
 
-	if _, err := b.db.Exec(`create table if not exists variables (
+	if err := database.CreateTable(b.db, `create table if not exists variables (
 			id integer primary key,
 			name string,
 			value string
 		);`); err != nil {
 		log.Fatal("Initial DB migration create variables table: ", err)
 	}
+	createKVTable(b.db)
 }
 
 // Adds a constructed handler to the bots handlers list
@@ -277,3 +283,14 @@ func (b *bot) checkAdmin(nick string) bool {
 func (b *bot) RegisterFilter(name string, f func(string) string) {
 	b.filters[name] = f
 }
+
+// Close closes the Closers among the handlers, in the order they were added.
+func (b *bot) Close() {
+	for _, name := range b.pluginOrdering {
+		if c, ok := b.plugins[name].(Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("Error closing %s: %s", name, err)
+			}
+		}
+	}
+}
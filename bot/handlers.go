@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
 )
 
 // Handles incomming PRIVMSG requests
@@ -28,9 +29,18 @@ func (b *bot) MsgReceived(msg msg.Message) {
 		goto RET
 	}
 
+	if msg.InThread() {
+		for _, name := range b.pluginOrdering {
+			if b.plugins[name].ReplyMessage(msg, msg.ThreadID) {
+				b.handled(name, msg)
+				goto RET
+			}
+		}
+	}
+
 	for _, name := range b.pluginOrdering {
-		p := b.plugins[name]
-		if p.Message(msg) {
+		if b.plugins[name].Message(forHandler(b.plugins[name], msg)) {
+			b.handled(name, msg)
 			break
 		}
 	}
@@ -46,38 +56,70 @@ func (b *bot) EventReceived(msg msg.Message) {
 	//msg := b.buildMessage(conn, inMsg)
 	for _, name := range b.pluginOrdering {
 		p := b.plugins[name]
-		if p.Event(msg.Body, msg) { // TODO: could get rid of msg.Body
+		if p.Event(msg.Kind, msg) {
 			break
 		}
 	}
 }
 
-// Handle incoming replys
-func (b *bot) ReplyMsgReceived(msg msg.Message, identifier string) {
-	log.Println("Received message: ", msg)
-
-	for _, name := range b.pluginOrdering {
-		p := b.plugins[name]
-		if p.ReplyMessage(msg, identifier) {
-			break
+// handled tells the Watchers which handler took a message.
+func (b *bot) handled(name string, message msg.Message) {
+	for _, n := range b.pluginOrdering {
+		if w, ok := b.plugins[n].(Watcher); ok {
+			w.Handled(name, message)
 		}
 	}
 }
 
-func (b *bot) SendMessage(channel, message string) string {
+// forHandler is message as h should see it. Handlers that answer threaded
+// messages in the channel get them without their thread, so that answers
+// sent with them as replyTo go to the channel.
+func forHandler(h Handler, message msg.Message) msg.Message {
+	if r, ok := h.(ThreadReplier); ok && !r.ReplyInThread() {
+		message.ThreadID = ""
+	}
+	return message
+}
+
+// replyThread is the thread an answer to replyTo, if there is one, is posted
+// in.
+func replyThread(replyTo []msg.Message) string {
+	if len(replyTo) == 0 {
+		return ""
+	}
+	return replyTo[0].ThreadID
+}
+
+func (b *bot) SendMessage(channel, message string, replyTo ...msg.Message) string {
+	if thread := replyThread(replyTo); thread != "" {
+		if id, ok := b.conn.ReplyToMessageIdentifier(channel, message, thread); ok {
+			return id
+		}
+	}
 	return b.conn.SendMessage(channel, message)
 }
 
-func (b *bot) SendAction(channel, message string) string {
+func (b *bot) SendAction(channel, message string, replyTo ...msg.Message) string {
+	if thread := replyThread(replyTo); thread != "" {
+		if id, ok := b.conn.ReplyToMessageIdentifier(channel, msg.Italic(message), thread); ok {
+			return id
+		}
+	}
 	return b.conn.SendAction(channel, message)
 }
 
+// SendRich sends a structured message. Connectors that can't show the
+// structure send its fallback text instead.
+func (b *bot) SendRich(channel string, message msg.Rich) string {
+	return b.conn.SendRich(channel, message)
+}
+
 func (b *bot) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
 	return b.conn.ReplyToMessageIdentifier(channel, message, identifier)
 }
 
 func (b *bot) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
-		return b.conn.ReplyToMessage(channel, message, replyTo)
+	return b.conn.ReplyToMessage(channel, message, replyTo)
 }
 
 func (b *bot) React(channel, reaction string, message msg.Message) bool {
@@ -88,6 +130,17 @@ func (b *bot) Edit(channel, newMessage, identifier string) bool {
 	return b.conn.Edit(channel, newMessage, identifier)
 }
 
+// OpenDM returns a channel for talking privately with a user.
+func (b *bot) OpenDM(u user.User) (string, error) {
+	return b.conn.OpenDM(u)
+}
+
+// Mention returns marked up text that mentions a user in a way that notifies
+// them, if the connector can.
+func (b *bot) Mention(u user.User) string {
+	return msg.Mention(u.Name, u.ID)
+}
+
 func (b *bot) GetEmojiList() map[string]string {
 	return b.conn.GetEmojiList()
 }
@@ -0,0 +1,66 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+// replier answers every message it's given.
+type replier struct {
+	b        Bot
+	inThread bool
+}
+
+func (r *replier) Message(message msg.Message) bool {
+	r.b.SendMessage(message.Channel, "hi "+message.Body, message)
+	return true
+}
+func (r *replier) Event(string, msg.Message) bool        { return false }
+func (r *replier) ReplyMessage(msg.Message, string) bool { return false }
+func (r *replier) BotMessage(msg.Message) bool           { return false }
+func (r *replier) Help(string, []string)                 {}
+func (r *replier) RegisterWeb() *string                  { return nil }
+func (r *replier) ReplyInThread() bool                   { return r.inThread }
+
+// closer counts how often it's closed.
+type closer struct {
+	replier
+	closed int
+}
+
+func (c *closer) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestCloseClosesClosers(t *testing.T) {
+	b := &bot{plugins: map[string]Handler{}}
+	c := &closer{}
+	b.plugins["closer"] = c
+	b.plugins["replier"] = &replier{b: b}
+	b.pluginOrdering = []string{"replier", "closer"}
+	b.Close()
+	assert.Equal(t, 1, c.closed)
+}
+
+func TestAnswersFollowThreads(t *testing.T) {
+	conn := &fakeConnector{}
+	b := &bot{conn: conn, plugins: map[string]Handler{}, logIn: make(chan msg.Message, 10)}
+	r := &replier{b: b, inThread: true}
+	b.plugins["replier"] = r
+	b.pluginOrdering = []string{"replier"}
+
+	u := &user.User{Name: "alice"}
+	b.MsgReceived(msg.Message{Channel: "#c", Body: "there", User: u})
+	b.MsgReceived(msg.Message{Channel: "#c", Body: "thread", User: u, ThreadID: "1.1"})
+	r.inThread = false
+	b.MsgReceived(msg.Message{Channel: "#c", Body: "channel", User: u, ThreadID: "1.1"})
+	b.SendMessage("#c", "later")
+
+	assert.Equal(t, []string{"#c hi there", "#c/1.1 hi thread", "#c hi channel", "#c later"}, conn.sent)
+}
@@ -13,31 +13,47 @@ type Bot interface {
 	Config() *config.Config
 	DBVersion() int64
 	DB() *sqlx.DB
+	// KV returns the key/value store for a namespace, usually a plugin's
+	// name.
+	KV(namespace string) *KV
 	Who(string) []user.User
+	OpenDM(user.User) (string, error)
+	Mention(user.User) string
 	AddHandler(string, Handler)
-	SendMessage(string, string) string
-	SendAction(string, string) string
+	// SendMessage and SendAction send to a channel. Given the message
+	// being answered as replyTo, they post in its thread if it has one.
+	SendMessage(channel, message string, replyTo ...msg.Message) string
+	SendAction(channel, message string, replyTo ...msg.Message) string
+	// SendRich posts in the message's ThreadID, if it has one.
+	SendRich(string, msg.Rich) string
 	ReplyToMessageIdentifier(string, string, string) (string, bool)
 	ReplyToMessage(string, string, msg.Message) (string, bool)
 	React(string, string, msg.Message) bool
 	Edit(string, string, string) bool
 	MsgReceived(msg.Message)
-	ReplyMsgReceived(msg.Message, string)
 	EventReceived(msg.Message)
 	Filter(msg.Message, string) string
 	LastMessage(string) (msg.Message, error)
 	CheckAdmin(string) bool
 	GetEmojiList() map[string]string
 	RegisterFilter(string, func(string) string)
+	// Close closes the handlers that are Closers, before the bot exits.
+	Close()
 }
 
+// NoIdentifier is the ID connectors return for messages they send on services
+// that don't identify messages, like IRC.
+const NoIdentifier = "NO_IRC_IDENTIFIERS"
+
 type Connector interface {
 	RegisterEventReceived(func(message msg.Message))
 	RegisterMessageReceived(func(message msg.Message))
-	RegisterReplyMessageReceived(func(msg.Message, string))
 
 	SendMessage(channel, message string) string
 	SendAction(channel, message string) string
+	// SendRich sends a structured message, laying it out as best the
+	// service allows.
+	SendRich(channel string, message msg.Rich) string
 	ReplyToMessageIdentifier(string, string, string) (string, bool)
 	ReplyToMessage(string, string, msg.Message) (string, bool)
 	React(string, string, msg.Message) bool
@@ -46,14 +62,41 @@ type Connector interface {
 	Serve() error
 
 	Who(string) []string
+	// OpenDM returns the channel to use to talk to a user privately.
+	OpenDM(user.User) (string, error)
 }
 
 // Interface used for compatibility with the Plugin interface
 type Handler interface {
 	Message(message msg.Message) bool
 	Event(kind string, message msg.Message) bool
+	// ReplyMessage is offered messages posted in a thread, along with the
+	// thread's ID, before they go through the usual Message dispatch.
 	ReplyMessage(msg.Message, string) bool
 	BotMessage(message msg.Message) bool
 	Help(channel string, parts []string)
 	RegisterWeb() *string
 }
+
+// ThreadReplier may be implemented by a Handler to choose where its answers to
+// threaded messages go. Handlers that don't implement it answer in the thread.
+type ThreadReplier interface {
+	// ReplyInThread reports whether answers to threaded messages should be
+	// posted to the thread rather than the channel. Handlers that answer in
+	// the channel are given threaded messages without their ThreadID.
+	ReplyInThread() bool
+}
+
+// Watcher may be implemented by a Handler to hear which handler took each
+// message, e.g. to count what the bot gets used for.
+type Watcher interface {
+	// Handled is called with the name the handler was added under and the
+	// message it took.
+	Handled(name string, message msg.Message)
+}
+
+// Closer may be implemented by a Handler that has something to finish
+// before the bot exits, like writes it's holding on to.
+type Closer interface {
+	Close() error
+}
@@ -0,0 +1,96 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/velour/catbase/database"
+)
+
+// KV is a key/value store in the bot's database, namespaced so that each
+// plugin has its own. Values are stored as JSON, so anything encoding/json
+// can marshal may be stored.
+type KV struct {
+	db        *sqlx.DB
+	namespace string
+}
+
+// createKVTable creates the table behind every KV.
+func createKVTable(db *sqlx.DB) {
+	if err := database.CreateTable(db, `create table if not exists kv (
+			namespace string,
+			key string,
+			value string,
+			expires integer,
+			primary key (namespace, key)
+		);`); err != nil {
+		log.Fatal("Initial DB migration create kv table: ", err)
+	}
+}
+
+func newKV(db *sqlx.DB, namespace string) *KV {
+	return &KV{db: db, namespace: namespace}
+}
+
+// Get decodes the value of key into value and reports whether there was one.
+// Expired values aren't there.
+func (kv *KV) Get(key string, value interface{}) (bool, error) {
+	var raw string
+	err := kv.db.QueryRow(`select value from kv
+		where namespace=? and key=? and (expires=0 or expires>?)`,
+		kv.namespace, key, time.Now().Unix()).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(raw), value)
+}
+
+// Set stores value under key, replacing what was there.
+func (kv *KV) Set(key string, value interface{}) error {
+	return kv.set(key, value, 0)
+}
+
+// SetTTL stores value under key until ttl has passed.
+func (kv *KV) SetTTL(key string, value interface{}, ttl time.Duration) error {
+	return kv.set(key, value, time.Now().Add(ttl).Unix())
+}
+
+func (kv *KV) set(key string, value interface{}, expires int64) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	q := database.Upsert(kv.db, "kv", []string{"namespace", "key"}, "value", "expires")
+	_, err = kv.db.Exec(q, kv.namespace, key, string(raw), expires)
+	return err
+}
+
+// Delete removes key, if it is there.
+func (kv *KV) Delete(key string) error {
+	_, err := kv.db.Exec(`delete from kv where namespace=? and key=?`, kv.namespace, key)
+	return err
+}
+
+// Keys lists the keys starting with prefix, in order. Expired keys are
+// removed rather than listed.
+func (kv *KV) Keys(prefix string) ([]string, error) {
+	now := time.Now().Unix()
+	if _, err := kv.db.Exec(`delete from kv where namespace=? and expires>0 and expires<=?`,
+		kv.namespace, now); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	err := kv.db.Select(&keys, `select key from kv
+		where namespace=? and substr(key, 1, ?)=?
+		order by key`, kv.namespace, utf8.RuneCountInString(prefix), prefix)
+	return keys, err
+}
@@ -0,0 +1,59 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKV(t *testing.T) {
+	mb := NewMockBot()
+	kv := mb.KV("test")
+	other := mb.KV("other")
+
+	type game struct {
+		Name  string
+		Score int
+	}
+	assert.Nil(t, kv.Set("game:1", game{"sisyphus", 3}))
+	assert.Nil(t, kv.Set("game:2", game{"zork", 7}))
+	assert.Nil(t, kv.Set("name", "catbase"))
+	assert.Nil(t, other.Set("game:3", game{"elsewhere", 0}))
+
+	var g game
+	ok, err := kv.Get("game:2", &g)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, game{"zork", 7}, g)
+
+	ok, err = kv.Get("game:3", &g)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	keys, err := kv.Keys("game:")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"game:1", "game:2"}, keys)
+
+	assert.Nil(t, kv.Delete("game:1"))
+	keys, _ = kv.Keys("")
+	assert.Equal(t, []string{"game:2", "name"}, keys)
+}
+
+func TestKVTTL(t *testing.T) {
+	kv := NewMockBot().KV("test")
+	assert.Nil(t, kv.SetTTL("fresh", 1, time.Hour))
+	assert.Nil(t, kv.SetTTL("stale", 2, -time.Second))
+
+	var n int
+	ok, _ := kv.Get("fresh", &n)
+	assert.True(t, ok)
+	assert.Equal(t, 1, n)
+	ok, _ = kv.Get("stale", &n)
+	assert.False(t, ok)
+
+	keys, _ := kv.Keys("")
+	assert.Equal(t, []string{"fresh"}, keys)
+}
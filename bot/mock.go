@@ -5,14 +5,17 @@ package bot
 import (
 	"fmt"
 	"log"
+	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/mock"
 	"github.com/velour/catbase/bot/msg"
 	"github.com/velour/catbase/bot/user"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
 )
 
 type MockBot struct {
@@ -23,22 +26,33 @@ type MockBot struct {
 
 	Messages []string
 	Actions  []string
+	Rich     []msg.Rich
+	// Reactions holds the reaction and message ID of each React
+	Reactions []string
 }
 
-func (mb *MockBot) Config() *config.Config            { return &mb.Cfg }
-func (mb *MockBot) DBVersion() int64                  { return 1 }
-func (mb *MockBot) DB() *sqlx.DB                      { return mb.db }
-func (mb *MockBot) Conn() Connector                   { return nil }
-func (mb *MockBot) Who(string) []user.User            { return []user.User{} }
-func (mb *MockBot) AddHandler(name string, f Handler) {}
-func (mb *MockBot) SendMessage(ch string, msg string) string {
+func (mb *MockBot) Config() *config.Config             { return mb.Cfg.Current() }
+func (mb *MockBot) DBVersion() int64                   { return 1 }
+func (mb *MockBot) DB() *sqlx.DB                       { return mb.db }
+func (mb *MockBot) KV(namespace string) *KV            { return newKV(mb.db, namespace) }
+func (mb *MockBot) Conn() Connector                    { return nil }
+func (mb *MockBot) Who(string) []user.User             { return []user.User{} }
+func (mb *MockBot) OpenDM(u user.User) (string, error) { return u.Name, nil }
+func (mb *MockBot) Mention(u user.User) string         { return u.Name }
+func (mb *MockBot) AddHandler(name string, f Handler)  {}
+func (mb *MockBot) SendMessage(ch string, msg string, replyTo ...msg.Message) string {
 	mb.Messages = append(mb.Messages, msg)
-	return fmt.Sprintf("m-%d", len(mb.Actions)-1)
+	return fmt.Sprintf("m-%d", len(mb.Messages)-1)
 }
-func (mb *MockBot) SendAction(ch string, msg string) string {
+func (mb *MockBot) SendAction(ch string, msg string, replyTo ...msg.Message) string {
 	mb.Actions = append(mb.Actions, msg)
 	return fmt.Sprintf("a-%d", len(mb.Actions)-1)
 }
+func (mb *MockBot) SendRich(ch string, message msg.Rich) string {
+	mb.Rich = append(mb.Rich, message)
+	mb.Messages = append(mb.Messages, message.Fallback())
+	return fmt.Sprintf("m-%d", len(mb.Messages)-1)
+}
 func (mb *MockBot) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
 	return "", false
 }
@@ -51,7 +65,10 @@ func (mb *MockBot) Filter(msg msg.Message, s string) string    { return "" }
 func (mb *MockBot) LastMessage(ch string) (msg.Message, error) { return msg.Message{}, nil }
 func (mb *MockBot) CheckAdmin(nick string) bool                { return false }
 
-func (mb *MockBot) React(channel, reaction string, message msg.Message) bool { return false }
+func (mb *MockBot) React(channel, reaction string, message msg.Message) bool {
+	mb.Reactions = append(mb.Reactions, reaction+" "+message.ID)
+	return true
+}
 
 func (mb *MockBot) Edit(channel, newMessage, identifier string) bool {
 	isMessage := identifier[0] == 'm'
@@ -82,22 +99,43 @@ func (mb *MockBot) Edit(channel, newMessage, identifier string) bool {
 	return true
 }
 
-func (mb *MockBot) ReplyMsgReceived(msg.Message, string) {
-
-}
-
 func (mb *MockBot) GetEmojiList() map[string]string                { return make(map[string]string) }
 func (mb *MockBot) RegisterFilter(s string, f func(string) string) {}
+func (mb *MockBot) Close()                                         {}
+
+// TestPostgresEnv names an environment variable holding a PostgreSQL
+// connection string. When it is set, mock bots use a new schema in that
+// database instead of SQLite, so that the tests run against PostgreSQL.
+const TestPostgresEnv = "CATBASE_TEST_POSTGRES"
+
+func openMockDB() (*sqlx.DB, error) {
+	source := os.Getenv(TestPostgresEnv)
+	if source == "" {
+		return database.Open(database.SQLite, ":memory:")
+	}
+	db, err := database.Open(database.Postgres, source)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	schema := fmt.Sprintf("catbase_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec("create schema " + schema); err != nil {
+		return nil, err
+	}
+	return database.Open(database.Postgres, source+" search_path="+schema)
+}
 
 func NewMockBot() *MockBot {
-	db, err := sqlx.Open("sqlite3_custom", ":memory:")
+	db, err := openMockDB()
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
+	createKVTable(db)
 	b := MockBot{
 		db:       db,
 		Messages: make([]string, 0),
 		Actions:  make([]string, 0),
 	}
+	b.Cfg.DBConn = db
 	return &b
 }
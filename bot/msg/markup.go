@@ -0,0 +1,199 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package msg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Marked up text is an ordinary string with formatted spans embedded in it
+// using control characters that no chat service sends us. That way plugins can
+// build formatted text with Sprintf and pass it through SendMessage, Edit,
+// filters and the factoid database unchanged, and each connector renders the
+// spans in its own native syntax right before the text goes out.
+//
+// A span is encoded as:
+//
+//	spanStart style [target] spanText text spanEnd
+//
+// and its text may have spans of its own, like italics around an emoji.
+const (
+	spanStart = '\x0e'
+	spanText  = '\x1c'
+	spanEnd   = '\x1a'
+)
+
+// Style is the kind of formatting applied to a Span.
+type Style byte
+
+const (
+	StylePlain     Style = 0
+	StyleBold      Style = 'b'
+	StyleItalic    Style = 'i'
+	StyleCode      Style = 'c'
+	StyleCodeBlock Style = 'p'
+	StyleEmoji     Style = 'e'
+	StyleLink      Style = 'l'
+	StyleMention   Style = 'm'
+)
+
+// Span is a run of text sharing a single style.
+type Span struct {
+	Style Style
+	// Text is the visible text, which may be marked up itself. For emoji it
+	// is the shortcode without colons.
+	Text string
+	// Target is the URL of a link or the connector's identifier for a
+	// mentioned user. It may be empty for mentions of users we only know by
+	// nick.
+	Target string
+}
+
+func span(style Style, target, text string) string {
+	return fmt.Sprintf("%c%c%s%c%s%c", spanStart, style, target, spanText, text, spanEnd)
+}
+
+// Bold marks text to be shown in bold.
+func Bold(text string) string { return span(StyleBold, "", text) }
+
+// Italic marks text to be shown in italics.
+func Italic(text string) string { return span(StyleItalic, "", text) }
+
+// Code marks text to be shown inline in a fixed-width font.
+func Code(text string) string { return span(StyleCode, "", text) }
+
+// CodeBlock marks possibly multi-line text to be shown preformatted.
+func CodeBlock(text string) string { return span(StyleCodeBlock, "", text) }
+
+// Emoji refers to an emoji by its shortcode, with or without the colons.
+func Emoji(name string) string { return span(StyleEmoji, "", strings.Trim(name, ":")) }
+
+// Link shows text linking to url.
+func Link(text, url string) string { return span(StyleLink, url, text) }
+
+// Mention refers to a user so that services which support it notify them. id
+// is the connector's identifier for the user and may be empty.
+func Mention(name, id string) string { return span(StyleMention, id, name) }
+
+// emojiCharacters maps the shortcodes our plugins use to their unicode
+// characters, for services that don't understand shortcodes.
+var emojiCharacters = map[string]string{
+	"+1":                 "👍",
+	"-1":                 "👎",
+	"full_moon":          "🌕",
+	"joy":                "😂",
+	"lion_face":          "🦁",
+	"new_moon":           "🌑",
+	"tea":                "🍵",
+	"white_large_square": "⬜",
+	"vomit":              "🤮",
+	"tableflip":          "(╯°□°）╯︵ ┻━┻",
+}
+
+// EmojiCharacter returns the unicode for an emoji shortcode, if we know it.
+func EmojiCharacter(name string) (string, bool) {
+	e, ok := emojiCharacters[strings.Trim(name, ":")]
+	return e, ok
+}
+
+// Spans splits marked up text into its outermost spans, leaving the spans
+// inside them in their text. Malformed markup is kept as plain text.
+func Spans(text string) []Span {
+	var spans []Span
+	plain := func(s string) {
+		if s == "" {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].Style == StylePlain {
+			spans[n-1].Text += s
+			return
+		}
+		spans = append(spans, Span{Style: StylePlain, Text: s})
+	}
+
+	for len(text) > 0 {
+		start := strings.IndexByte(text, spanStart)
+		if start < 0 {
+			plain(text)
+			break
+		}
+		plain(text[:start])
+		rest := text[start:]
+
+		sep := strings.IndexByte(rest, spanText)
+		end := -1
+		if sep >= 2 {
+			end = spanEndAt(rest, sep+1)
+		}
+		if len(rest) < 2 || sep < 2 || end < sep || strings.IndexByte(rest[2:sep], spanStart) >= 0 {
+			// Not a span after all, keep the marker and move on.
+			plain(rest[:1])
+			text = rest[1:]
+			continue
+		}
+
+		spans = append(spans, Span{
+			Style:  Style(rest[1]),
+			Target: rest[2:sep],
+			Text:   rest[sep+1 : end],
+		})
+		text = rest[end+1:]
+	}
+	return spans
+}
+
+// spanEndAt returns the index of the spanEnd that closes the span whose text
+// starts at i in text, skipping the ends of spans inside it, or -1.
+func spanEndAt(text string, i int) int {
+	depth := 1
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case spanStart:
+			depth++
+		case spanEnd:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Render converts marked up text using f to produce each span. The text of
+// spans other than emoji and mentions is rendered before f gets it, so that
+// spans inside spans come out right: f should only escape plain text, emoji
+// and names.
+func Render(text string, f func(Span) string) string {
+	if strings.IndexByte(text, spanStart) < 0 {
+		return f(Span{Style: StylePlain, Text: text})
+	}
+	var out []string
+	for _, s := range Spans(text) {
+		if s.Style != StylePlain && s.Style != StyleEmoji && s.Style != StyleMention {
+			s.Text = Render(s.Text, f)
+		}
+		out = append(out, f(s))
+	}
+	return strings.Join(out, "")
+}
+
+// Strip renders marked up text without any formatting. It is what connectors
+// without rich text support, logs and tests should use.
+func Strip(text string) string {
+	return Render(text, StripSpan)
+}
+
+// StripSpan renders a single span without any formatting.
+func StripSpan(s Span) string {
+	switch s.Style {
+	case StyleEmoji:
+		return ":" + s.Text + ":"
+	case StyleLink:
+		if s.Text == "" || s.Text == s.Target {
+			return s.Target
+		}
+		return fmt.Sprintf("%s (%s)", s.Text, s.Target)
+	}
+	return s.Text
+}
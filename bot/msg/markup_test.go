@@ -0,0 +1,75 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package msg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpansPlain(t *testing.T) {
+	spans := Spans("just some text")
+	assert.Equal(t, []Span{{Style: StylePlain, Text: "just some text"}}, spans)
+}
+
+func TestSpansMixed(t *testing.T) {
+	text := "hi " + Bold("there") + ", see " + Link("this", "http://example.com") + Emoji(":tea:")
+	spans := Spans(text)
+	assert.Equal(t, []Span{
+		{Style: StylePlain, Text: "hi "},
+		{Style: StyleBold, Text: "there"},
+		{Style: StylePlain, Text: ", see "},
+		{Style: StyleLink, Text: "this", Target: "http://example.com"},
+		{Style: StyleEmoji, Text: "tea"},
+	}, spans)
+}
+
+func TestSpansMalformed(t *testing.T) {
+	text := "broken \x0eb no end"
+	assert.Equal(t, text, Strip(text))
+}
+
+func TestStrip(t *testing.T) {
+	text := Mention("seabass", "U123") + " said " + Italic("hello") + " " +
+		Link("http://example.com", "http://example.com") + " " + CodeBlock("a\nb")
+	assert.Equal(t, "seabass said hello http://example.com a\nb", Strip(text))
+}
+
+func TestRender(t *testing.T) {
+	text := "x " + Bold("y")
+	out := Render(text, func(s Span) string {
+		if s.Style == StyleBold {
+			return "*" + s.Text + "*"
+		}
+		return s.Text
+	})
+	assert.Equal(t, "x *y*", out)
+}
+
+func TestSpansNested(t *testing.T) {
+	text := Italic("hugs "+Emoji("tea")+" ok") + " " + Bold(Link("x", "http://x"))
+	assert.Equal(t, []Span{
+		{Style: StyleItalic, Text: "hugs " + Emoji("tea") + " ok"},
+		{Style: StylePlain, Text: " "},
+		{Style: StyleBold, Text: Link("x", "http://x")},
+	}, Spans(text))
+	assert.Equal(t, "hugs :tea: ok x (http://x)", Strip(text))
+
+	out := Render(text, func(s Span) string {
+		switch s.Style {
+		case StyleItalic:
+			return "_" + s.Text + "_"
+		case StyleBold:
+			return "*" + s.Text + "*"
+		}
+		return StripSpan(s)
+	})
+	assert.Equal(t, "_hugs :tea: ok_ *x (http://x)*", out)
+	assert.NotContains(t, out, "\x1a")
+}
+
+func TestSpansUnclosedInside(t *testing.T) {
+	text := "\x0eb" + Bold("x")
+	assert.Equal(t, "\x0ebx", Strip(text))
+}
@@ -11,6 +11,21 @@ import (
 type Log Messages
 type Messages []Message
 
+// Event kinds that connectors other than IRC may deliver. IRC events are
+// delivered with the IRC command as their kind, e.g. "JOIN".
+const (
+	// ReactionAdded and ReactionRemoved have the reaction in Reaction and
+	// the ID of the message reacted to in Target.
+	ReactionAdded   = "REACTION_ADDED"
+	ReactionRemoved = "REACTION_REMOVED"
+	// MessageEdited carries the message's new contents, with the ID of the
+	// edited message in Target and its old contents in Previous, if known.
+	MessageEdited = "MESSAGE_EDITED"
+	// MessageDeleted has the ID of the deleted message in Target and its
+	// contents in Previous, if known.
+	MessageDeleted = "MESSAGE_DELETED"
+)
+
 type Message struct {
 	User          *user.User
 	Channel, Body string
@@ -19,5 +34,23 @@ type Message struct {
 	Action        bool
 	Time          time.Time
 	Host          string
+	// ID identifies this message to the connector, e.g. a Slack timestamp
+	ID string
+	// ThreadID is the ID of the message that started the thread this
+	// message was posted in, or empty if it wasn't posted in a thread
+	ThreadID string
+	// Kind is the kind of event an event message describes
+	Kind string
+	// Target is the ID of the message an event is about
+	Target string
+	// Reaction is the name of the emoji for reaction events
+	Reaction string
+	// Previous is the message as it was before an edit or delete
+	Previous       *Message
 	AdditionalData map[string]string
 }
+
+// InThread reports whether the message was posted in a thread.
+func (m Message) InThread() bool {
+	return m.ThreadID != ""
+}
@@ -0,0 +1,150 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package msg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rich is a message with structure that some connectors can show natively.
+// Connectors that can't fall back to sending Fallback as marked up text.
+type Rich struct {
+	// Text is shown above the blocks and used for notifications.
+	Text string
+	// Blocks lay out the body of the message.
+	Blocks []Block
+	// Attachments are sent alongside the message.
+	Attachments []Attachment
+	// ThreadID is the thread to post in, if any, usually the ThreadID of
+	// the message being answered.
+	ThreadID string
+}
+
+// BlockKind is the kind of a Block.
+type BlockKind int
+
+const (
+	// BlockSection is marked up Text with optional Fields beside it.
+	BlockSection BlockKind = iota
+	// BlockDivider is a horizontal rule.
+	BlockDivider
+	// BlockImage is the image at URL, described by Text.
+	BlockImage
+	// BlockButtons is a row of Buttons.
+	BlockButtons
+)
+
+// Block is one piece of a Rich message's layout.
+type Block struct {
+	Kind    BlockKind
+	Text    string
+	Fields  []Field
+	URL     string
+	Buttons []Button
+}
+
+// Field is a labelled value shown in a section.
+type Field struct {
+	Title, Value string
+}
+
+// Button is a button that opens URL.
+type Button struct {
+	Text, URL string
+}
+
+// AttachmentKind is the kind of an Attachment.
+type AttachmentKind int
+
+const (
+	// AttachFile is an arbitrary file.
+	AttachFile AttachmentKind = iota
+	// AttachImage is an image file.
+	AttachImage
+	// AttachSnippet is text that should be shown in a fixed-width font,
+	// such as code or ASCII art.
+	AttachSnippet
+)
+
+// Attachment is a file sent with a message. Either Data holds its contents or
+// URL says where it already lives.
+type Attachment struct {
+	Kind AttachmentKind
+	// Name is the file name, e.g. "transcript.txt"
+	Name  string
+	Title string
+	// Filetype is a hint at the contents of a snippet, e.g. "go" or "text"
+	Filetype string
+	Data     []byte
+	URL      string
+}
+
+// Section returns a Rich message with a single section of marked up text.
+func Section(text string) Rich {
+	return Rich{Blocks: []Block{{Kind: BlockSection, Text: text}}}
+}
+
+// Snippet returns a Rich message with a single text snippet attached.
+func Snippet(name, text string) Rich {
+	return Rich{Attachments: []Attachment{{
+		Kind: AttachSnippet,
+		Name: name,
+		Data: []byte(text),
+	}}}
+}
+
+// Fallback lays the message out as marked up text for connectors that can't
+// show it any other way.
+func (r Rich) Fallback() string {
+	var lines []string
+	if r.Text != "" {
+		lines = append(lines, r.Text)
+	}
+	for _, b := range r.Blocks {
+		if s := b.fallback(); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	for _, a := range r.Attachments {
+		lines = append(lines, a.fallback())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b Block) fallback() string {
+	switch b.Kind {
+	case BlockDivider:
+		return "----"
+	case BlockImage:
+		return Link(b.Text, b.URL)
+	case BlockButtons:
+		var buttons []string
+		for _, btn := range b.Buttons {
+			buttons = append(buttons, Link(btn.Text, btn.URL))
+		}
+		return strings.Join(buttons, " | ")
+	}
+	lines := []string{}
+	if b.Text != "" {
+		lines = append(lines, b.Text)
+	}
+	for _, f := range b.Fields {
+		lines = append(lines, Bold(f.Title)+": "+f.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (a Attachment) fallback() string {
+	name := a.Title
+	if name == "" {
+		name = a.Name
+	}
+	switch {
+	case a.Kind == AttachSnippet && len(a.Data) > 0:
+		return CodeBlock(strings.TrimRight(string(a.Data), "\n"))
+	case a.URL != "":
+		return Link(name, a.URL)
+	}
+	return fmt.Sprintf("[%s, %d bytes]", name, len(a.Data))
+}
@@ -0,0 +1,37 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package msg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRichFallback(t *testing.T) {
+	r := Rich{
+		Text: "Scores",
+		Blocks: []Block{
+			{Kind: BlockSection, Fields: []Field{{"alice", "3"}}},
+			{Kind: BlockDivider},
+			{Kind: BlockButtons, Buttons: []Button{{"More", "http://example.com"}}},
+		},
+		Attachments: []Attachment{
+			{Kind: AttachSnippet, Name: "art.txt", Data: []byte("/\\\n")},
+			{Kind: AttachImage, Name: "cat.png", Data: []byte{1, 2, 3}},
+		},
+	}
+	expected := "Scores\n" +
+		Bold("alice") + ": 3\n" +
+		"----\n" +
+		Link("More", "http://example.com") + "\n" +
+		CodeBlock("/\\") + "\n" +
+		"[cat.png, 3 bytes]"
+	assert.Equal(t, expected, r.Fallback())
+}
+
+func TestSnippet(t *testing.T) {
+	r := Snippet("goatse.txt", "art")
+	assert.Equal(t, CodeBlock("art"), r.Fallback())
+	assert.Equal(t, AttachSnippet, r.Attachments[0].Kind)
+}
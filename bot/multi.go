@@ -0,0 +1,225 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+// Multi is a Connector that runs several named connectors at once. Channels
+// are namespaced by connector, so "freenode:#catbase" is #catbase on the
+// connector named freenode, and messages are sent through the connector their
+// channel names.
+type Multi struct {
+	names []string
+	conns map[string]Connector
+
+	eventReceived func(msg.Message)
+	msgReceived   func(msg.Message)
+
+	// seen maps a user's name to the connector we last heard them on, so
+	// that they can be found again to DM
+	seenMu sync.Mutex
+	seen   map[string]string
+}
+
+// NewMulti creates a Multi without any connectors. Add them with Add before
+// handing it to New.
+func NewMulti() *Multi {
+	return &Multi{
+		conns: map[string]Connector{},
+		seen:  map[string]string{},
+	}
+}
+
+// Add adds a connector under the given name, which must not contain a colon.
+func (m *Multi) Add(name string, c Connector) {
+	if strings.Contains(name, ":") {
+		log.Fatalf("Connector name %q may not contain a colon", name)
+	}
+	if _, ok := m.conns[name]; ok {
+		log.Fatalf("Connector %q added twice", name)
+	}
+	m.names = append(m.names, name)
+	m.conns[name] = c
+	c.RegisterMessageReceived(func(message msg.Message) {
+		if m.msgReceived != nil {
+			m.msgReceived(m.namespace(name, message))
+		}
+	})
+	c.RegisterEventReceived(func(message msg.Message) {
+		if m.eventReceived != nil {
+			m.eventReceived(m.namespace(name, message))
+		}
+	})
+}
+
+// Channel returns the namespaced name of a connector's channel.
+func Channel(connector, channel string) string {
+	return connector + ":" + channel
+}
+
+// namespace prefixes the channels of a message from the named connector.
+func (m *Multi) namespace(name string, message msg.Message) msg.Message {
+	if message.Channel != "" {
+		message.Channel = Channel(name, message.Channel)
+	}
+	if message.Previous != nil {
+		prev := *message.Previous
+		if prev.Channel != "" {
+			prev.Channel = Channel(name, prev.Channel)
+		}
+		message.Previous = &prev
+	}
+	if message.User != nil && message.User.Name != "" {
+		m.seenMu.Lock()
+		m.seen[message.User.Name] = name
+		m.seenMu.Unlock()
+	}
+	return message
+}
+
+// route finds the connector for a namespaced channel and returns it with the
+// channel's name on that connector.
+func (m *Multi) route(channel string) (Connector, string, bool) {
+	parts := strings.SplitN(channel, ":", 2)
+	if len(parts) == 2 {
+		if c, ok := m.conns[parts[0]]; ok {
+			return c, parts[1], true
+		}
+	}
+	log.Printf("No connector for channel %q", channel)
+	return nil, "", false
+}
+
+func (m *Multi) RegisterEventReceived(f func(msg.Message)) {
+	m.eventReceived = f
+}
+
+func (m *Multi) RegisterMessageReceived(f func(msg.Message)) {
+	m.msgReceived = f
+}
+
+func (m *Multi) SendMessage(channel, message string) string {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return ""
+	}
+	return c.SendMessage(ch, message)
+}
+
+func (m *Multi) SendAction(channel, message string) string {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return ""
+	}
+	return c.SendAction(ch, message)
+}
+
+func (m *Multi) SendRich(channel string, message msg.Rich) string {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return ""
+	}
+	return c.SendRich(ch, message)
+}
+
+func (m *Multi) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return "", false
+	}
+	return c.ReplyToMessageIdentifier(ch, message, identifier)
+}
+
+func (m *Multi) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return "", false
+	}
+	replyTo.Channel = ch
+	return c.ReplyToMessage(ch, message, replyTo)
+}
+
+func (m *Multi) React(channel, reaction string, message msg.Message) bool {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return false
+	}
+	message.Channel = ch
+	return c.React(ch, reaction, message)
+}
+
+func (m *Multi) Edit(channel, newMessage, identifier string) bool {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return false
+	}
+	return c.Edit(ch, newMessage, identifier)
+}
+
+// GetEmojiList merges the emoji of all connectors. Where names clash the
+// first connector added wins.
+func (m *Multi) GetEmojiList() map[string]string {
+	emoji := map[string]string{}
+	for i := len(m.names) - 1; i >= 0; i-- {
+		for k, v := range m.conns[m.names[i]].GetEmojiList() {
+			emoji[k] = v
+		}
+	}
+	return emoji
+}
+
+func (m *Multi) Who(channel string) []string {
+	c, ch, ok := m.route(channel)
+	if !ok {
+		return []string{}
+	}
+	return c.Who(ch)
+}
+
+// OpenDM opens a DM on the connector the user was last seen on. A user named
+// like a channel, "connector:name", is looked up on that connector instead.
+func (m *Multi) OpenDM(u user.User) (string, error) {
+	name := ""
+	if parts := strings.SplitN(u.Name, ":", 2); len(parts) == 2 {
+		if _, ok := m.conns[parts[0]]; ok {
+			name, u.Name = parts[0], parts[1]
+		}
+	}
+	if name == "" {
+		m.seenMu.Lock()
+		name = m.seen[u.Name]
+		m.seenMu.Unlock()
+	}
+	if name == "" {
+		return "", fmt.Errorf("don't know where to find %s", u.Name)
+	}
+	ch, err := m.conns[name].OpenDM(u)
+	if err != nil {
+		return "", err
+	}
+	return Channel(name, ch), nil
+}
+
+// Serve runs every connector with ServeForever. A connector that stops for
+// good is left stopped while the others carry on, and once they all have
+// Serve returns a PermanentError.
+func (m *Multi) Serve() error {
+	var wg sync.WaitGroup
+	for _, name := range m.names {
+		wg.Add(1)
+		go func(name string, c Connector) {
+			defer wg.Done()
+			log.Printf("%s stopped for good: %s", name, ServeForever(name, c))
+		}(name, m.conns[name])
+	}
+	wg.Wait()
+	return Permanent(fmt.Errorf("every connector has stopped"))
+}
@@ -0,0 +1,106 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+// fakeConnector records what is sent through it.
+type fakeConnector struct {
+	msgReceived func(msg.Message)
+	sent        []string
+	emoji       map[string]string
+}
+
+func (f *fakeConnector) RegisterEventReceived(func(msg.Message))     {}
+func (f *fakeConnector) RegisterMessageReceived(r func(msg.Message)) { f.msgReceived = r }
+func (f *fakeConnector) SendMessage(channel, message string) string {
+	f.sent = append(f.sent, channel+" "+message)
+	return "id"
+}
+func (f *fakeConnector) SendAction(channel, message string) string        { return "" }
+func (f *fakeConnector) SendRich(channel string, message msg.Rich) string { return "" }
+func (f *fakeConnector) ReplyToMessageIdentifier(channel, message, thread string) (string, bool) {
+	f.sent = append(f.sent, channel+"/"+thread+" "+message)
+	return "reply", true
+}
+func (f *fakeConnector) ReplyToMessage(string, string, msg.Message) (string, bool) {
+	return "", false
+}
+func (f *fakeConnector) React(string, string, msg.Message) bool { return false }
+func (f *fakeConnector) Edit(string, string, string) bool       { return false }
+func (f *fakeConnector) GetEmojiList() map[string]string        { return f.emoji }
+func (f *fakeConnector) Serve() error                           { return nil }
+func (f *fakeConnector) Who(string) []string                    { return nil }
+func (f *fakeConnector) OpenDM(u user.User) (string, error)     { return "D-" + u.Name, nil }
+
+func TestMultiRoutes(t *testing.T) {
+	irc := &fakeConnector{emoji: map[string]string{"cat": "irc"}}
+	slack := &fakeConnector{emoji: map[string]string{"cat": "slack", "dog": "slack"}}
+	m := NewMulti()
+	m.Add("freenode", irc)
+	m.Add("work", slack)
+
+	var got []msg.Message
+	m.RegisterMessageReceived(func(message msg.Message) { got = append(got, message) })
+	slack.msgReceived(msg.Message{Channel: "#general", User: &user.User{Name: "alice"}})
+	assert.Equal(t, "work:#general", got[0].Channel)
+
+	assert.Equal(t, "id", m.SendMessage("work:#general", "hi"))
+	assert.Equal(t, "id", m.SendMessage("freenode:#room:with:colons", "hi"))
+	assert.Equal(t, "", m.SendMessage("#general", "lost"))
+	assert.Equal(t, []string{"#general hi"}, slack.sent)
+	assert.Equal(t, []string{"#room:with:colons hi"}, irc.sent)
+
+	dm, err := m.OpenDM(user.User{Name: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, "work:D-alice", dm)
+	dm, err = m.OpenDM(user.User{Name: "freenode:alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, "freenode:D-alice", dm)
+	_, err = m.OpenDM(user.User{Name: "bob"})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, map[string]string{"cat": "irc", "dog": "slack"}, m.GetEmojiList())
+}
+
+// stoppingConnector fails to serve with each of errs in turn.
+type stoppingConnector struct {
+	fakeConnector
+	errs []error
+}
+
+func (s *stoppingConnector) Serve() error {
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func TestServeForeverBacksOff(t *testing.T) {
+	var slept []time.Duration
+	restartSleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { restartSleep = time.Sleep }()
+
+	c := &stoppingConnector{errs: []error{
+		errors.New("down"), errors.New("down"), errors.New("down"),
+		Permanent(errors.New("bad token")),
+	}}
+	err := ServeForever("test", c)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, "bad token", err.Error())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, slept)
+}
+
+func TestMultiServeStopsWhenEveryConnectorHas(t *testing.T) {
+	m := NewMulti()
+	m.Add("a", &stoppingConnector{errs: []error{Permanent(errors.New("bad token"))}})
+	m.Add("b", &stoppingConnector{errs: []error{Permanent(errors.New("banned"))}})
+	assert.True(t, IsPermanent(m.Serve()))
+}
@@ -0,0 +1,66 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bot
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// initialRestart is how long ServeForever waits to restart a connector
+	// that stopped soon after it started. Each quick stop in a row doubles
+	// it up to maxRestart.
+	initialRestart = time.Second
+	maxRestart     = time.Minute
+	// stableServe is how long a connector has to have been up for its next
+	// stop not to count as a quick one.
+	stableServe = time.Minute
+)
+
+// restartSleep is time.Sleep, replaced in tests.
+var restartSleep = time.Sleep
+
+// ServeForever runs c and restarts it whenever it stops, until it stops with
+// a PermanentError, which is returned. Connectors that keep stopping soon
+// after starting are restarted less and less often.
+func ServeForever(name string, c Connector) error {
+	wait := initialRestart
+	for {
+		start := time.Now()
+		err := c.Serve()
+		if IsPermanent(err) {
+			return err
+		}
+		if time.Since(start) >= stableServe {
+			wait = initialRestart
+		}
+		log.Printf("%s stopped, restarting in %s: %s", name, wait, err)
+		restartSleep(wait)
+		if wait *= 2; wait > maxRestart {
+			wait = maxRestart
+		}
+	}
+}
+
+// PermanentError is a connector error that trying again won't fix, like a
+// token the service rejects. Connectors return it from Serve so they aren't
+// restarted.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks err as a PermanentError.
+func Permanent(err error) error {
+	return &PermanentError{err}
+}
+
+// IsPermanent reports whether err is a PermanentError.
+func IsPermanent(err error) bool {
+	_, ok := err.(*PermanentError)
+	return ok
+}
@@ -0,0 +1,60 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
+)
+
+const commandUsage = `commands:
+  backup <file>                   copy the SQLite database to file
+  export <file> [plugin...]       save plugins' data as JSON
+  import <file>                   load an export, replacing what's in its tables`
+
+// runCommand runs one of the maintenance commands instead of the bot.
+func runCommand(c *config.Config, args []string) error {
+	db := c.DBConn
+	switch {
+	case args[0] == "backup" && len(args) == 2:
+		if err := database.Backup(db, args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Backed up to %s.\n", args[1])
+		return nil
+
+	case args[0] == "export" && len(args) >= 2:
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if err := database.ExportJSON(db, f, args[2:]...); err != nil {
+			f.Close()
+			os.Remove(args[1])
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Exported to %s.\n", args[1])
+		return nil
+
+	case args[0] == "import" && len(args) == 2:
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := database.ImportJSON(db, f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d rows from %s.\n", n, args[1])
+		return nil
+	}
+	return errors.New(commandUsage)
+}
@@ -0,0 +1,92 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/yuin/gluamapper"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// channelSettings are the settings the config file gives for one channel in
+// ChannelSettings, such as
+//
+//	ChannelSettings = {
+//	  ["#quiet"] = { Emojify = { Chance = 0 }, Factoid = { QuoteChance = 0 } }
+//	}
+type channelSettings struct {
+	values Config
+	// paths are the settings given, like Emojify.Chance
+	paths []string
+}
+
+// takeChannelSettings removes the ChannelSettings table from a config table,
+// returning what it sets for each channel.
+func takeChannelSettings(config *lua.LTable, errs *[]error) map[string]*channelSettings {
+	lv := config.RawGetString("ChannelSettings")
+	config.RawSetString("ChannelSettings", lua.LNil)
+	if lv == lua.LNil {
+		return nil
+	}
+	tbl, ok := lv.(*lua.LTable)
+	if !ok {
+		*errs = append(*errs, fmt.Errorf("config.ChannelSettings: expected a table, got a %s", lv.Type()))
+		return nil
+	}
+
+	settings := map[string]*channelSettings{}
+	tbl.ForEach(func(k, v lua.LValue) {
+		channel := k.String()
+		prefix := fmt.Sprintf("config.ChannelSettings[%s]", channel)
+		n := len(*errs)
+		checkKeys(prefix, v, reflect.TypeOf(Config{}), errs)
+		if len(*errs) > n {
+			return
+		}
+		s := &channelSettings{}
+		if err := gluamapper.Map(v.(*lua.LTable), &s.values); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %s", prefix, err))
+			return
+		}
+		collectPaths(prefix, "", v.(*lua.LTable), reflect.TypeOf(Config{}), &s.paths, errs)
+		sort.Strings(s.paths)
+		settings[channel] = s
+	})
+	return settings
+}
+
+// collectPaths lists the settings a table of them sets, noting those that
+// can't be set per channel.
+func collectPaths(prefix, path string, tbl *lua.LTable, t reflect.Type, paths *[]string, errs *[]error) {
+	tbl.ForEach(func(k, v lua.LValue) {
+		f, _ := fieldByKey(t, k.String())
+		p := f.Name
+		if path != "" {
+			p = path + "." + f.Name
+		}
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			collectPaths(prefix, p, v.(*lua.LTable), f.Type, paths, errs)
+		case settable(f.Type) && !isSecret(p):
+			*paths = append(*paths, p)
+		default:
+			*errs = append(*errs, fmt.Errorf("%s.%s: can't be set per channel", prefix, p))
+		}
+	})
+}
+
+// applyChannel sets what the config file gives for channel on c.
+func (c *Config) applyChannel(channel string) {
+	s, ok := c.channels[channel]
+	if !ok {
+		return
+	}
+	for _, path := range s.paths {
+		from, _, _ := lookup(reflect.ValueOf(&s.values).Elem(), path)
+		to, _, _ := lookup(reflect.ValueOf(c).Elem(), path)
+		to.Set(from)
+	}
+}
@@ -0,0 +1,92 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const channelConfig = `config = {
+	Nick = "cat",
+	DB = { File = "cat.db" },
+	Irc = { Server = "irc" },
+	RatePerSec = 1,
+	CommandChar = { "!" },
+	Emojify = { Chance = 0.02 },
+	Factoid = { QuoteChance = 0.5, QuoteTime = 1 },
+	ChannelSettings = {
+		["#quiet"] = {
+			Emojify = { Chance = 0 },
+			Factoid = { QuoteChance = 0 },
+			CommandChar = { "?", "¿" },
+		},
+	},
+}`
+
+func TestChannelSettings(t *testing.T) {
+	c, errs := load(t, channelConfig)
+	assert.Empty(t, errs)
+	quiet := c.ForChannel("#quiet")
+	assert.Equal(t, 0.0, quiet.Emojify.Chance)
+	assert.Equal(t, 0.0, quiet.Factoid.QuoteChance)
+	assert.Equal(t, 1, quiet.Factoid.QuoteTime)
+	assert.Equal(t, []string{"?", "¿"}, quiet.CommandChar)
+
+	assert.Equal(t, 0.02, c.Emojify.Chance)
+	assert.Equal(t, []string{"!"}, c.CommandChar)
+	assert.True(t, c.ForChannel("#loud") == c)
+}
+
+func TestChannelSettingsErrors(t *testing.T) {
+	_, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Irc = { Server = "irc" },
+		RatePerSec = 1,
+		ChannelSettings = {
+			["#a"] = { Emojify = { Odds = 1 } },
+			["#c"] = { Slack = { Token = "x" } },
+		},
+	}`)
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs, "config.ChannelSettings[#a].Emojify.Odds: unknown setting")
+	assert.Contains(t, errs, "config.ChannelSettings[#c].Slack.Token: can't be set per channel")
+
+	_, errs = load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Irc = { Server = "irc" },
+		RatePerSec = 1,
+		ChannelSettings = { ["#b"] = { Emojify = { Chance = 3 } } },
+	}`)
+	assert.Equal(t, []string{"ChannelSettings[#b]: Emojify.Chance: 3 is not a probability between 0 and 1"}, errs)
+}
+
+func TestChannelSettingsUnderOverrides(t *testing.T) {
+	c, errs := load(t, channelConfig)
+	assert.Empty(t, errs)
+	c.DBConn = makeConfig(t).DBConn
+
+	assert.Nil(t, c.Set("", "Emojify.Chance", "0.1"))
+	assert.Equal(t, 0.0, c.ForChannel("#quiet").Emojify.Chance)
+	assert.Nil(t, c.Set("#quiet", "Emojify.Chance", "0.2"))
+	assert.Equal(t, 0.2, c.ForChannel("#quiet").Emojify.Chance)
+	assert.Equal(t, 0.1, c.ForChannel("#loud").Emojify.Chance)
+}
+
+func TestChannelSettingsForConnector(t *testing.T) {
+	c, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		RatePerSec = 1,
+		Channels = { "work:#general" },
+		Connectors = { { Name = "work", Type = "irc", Irc = { Server = "irc" } } },
+		ChannelSettings = { ["work:#general"] = { CommandChar = { "?" } } },
+	}`)
+	assert.Empty(t, errs)
+	work := c.ForConnector(c.Connectors[0])
+	assert.Equal(t, []string{"?"}, work.ForChannel("#general").CommandChar)
+	assert.Equal(t, []string{"?"}, c.ForChannel("work:#general").CommandChar)
+}
@@ -3,37 +3,55 @@
 package config
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
-	"regexp"
+	"path/filepath"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
-	sqlite3 "github.com/mattn/go-sqlite3"
-	"github.com/yuin/gluamapper"
-	lua "github.com/yuin/gopher-lua"
+	"github.com/velour/catbase/database"
 )
 
 // Config stores any system-wide startup information that cannot be easily configured via
 // the database
 type Config struct {
 	DBConn *sqlx.DB
+	// shared holds the settings changed at runtime, see Set and Current.
+	shared *shared
+	// channels are the config file's ChannelSettings, see ForChannel.
+	channels map[string]*channelSettings
+	// channelPrefix names the connector a copy is for, and connector is
+	// the connector itself, see ForConnector.
+	channelPrefix string
+	connector     *Connector
 
 	DB struct {
-		File   string
-		Name   string
-		Server string
+		// Type is sqlite, the default, or postgres.
+		Type string
+		// File is the SQLite database.
+		File string
+		// Name, Server (host:port), User, Password and Options, such as
+		// "sslmode=disable", say which PostgreSQL database to use.
+		Name     string
+		Server   string
+		User     string
+		Password string
+		Options  string
+		// BackupDir is where the backup and export commands write to.
+		// It defaults to the directory File is in.
+		BackupDir string
 	}
 	Channels    []string
 	MainChannel string
 	Plugins     []string
 	Type        string
-	Irc         struct {
-		Server, Pass string
-	}
-	Slack struct {
-		Token string
-	}
+	Irc         Irc
+	Slack       Slack
+	Discord     Discord
+	Matrix      Matrix
+	// Connectors runs several connections at once, see Connector. When it is
+	// empty the bot makes the one connection given by Type.
+	Connectors  []Connector
 	Nick        string
 	FullName    string
 	Version     string
@@ -42,7 +60,13 @@ type Config struct {
 	LogLength   int
 	Admins      []string
 	HttpAddr    string
-	Untappd     struct {
+	// ConfigToken must be given to change settings from the /config page,
+	// which is read-only without one.
+	ConfigToken string
+	// SecretsFiles are Lua files setting a secrets table, which is merged
+	// into config so that secrets can be kept out of the main config file.
+	SecretsFiles []string
+	Untappd      struct {
 		Token    string
 		Freq     int
 		Channels []string
@@ -88,6 +112,11 @@ type Config struct {
 	Stats struct {
 		DBPath    string
 		Sightings []string
+		// FlushSeconds is how often stats are written to DBPath, 10 by default.
+		FlushSeconds int
+		// Stopwords are left out of the word counts, as well as the usual
+		// ones like "the".
+		Stopwords []string
 	}
 	Emojify struct {
 		Chance float64
@@ -103,6 +132,25 @@ type Config struct {
 	Inventory struct {
 		Max int
 	}
+	// Webhooks are URLs on the HTTP server that post into channels
+	Webhooks []Webhook
+	// External plugins are programs the bot runs and talks to over their
+	// stdin and stdout
+	External []External
+	Scripts  struct {
+		// Dir holds the Lua scripts run as plugins, by default scripts
+		Dir string
+	}
+	// OutgoingWebhooks post matching messages to other systems
+	OutgoingWebhooks []OutgoingWebhook
+	Bridge           struct {
+		// Channels lists groups of channels whose messages are mirrored
+		// into each other
+		Channels [][]string
+		// Edits and Reactions relay those too, where supported
+		Edits     bool
+		Reactions bool
+	}
 	Sisyphus struct {
 		MinDecrement int
 		MaxDecrement int
@@ -112,16 +160,156 @@ type Config struct {
 	BotList map[string]bool
 }
 
-func init() {
-	regex := func(re, s string) (bool, error) {
-		return regexp.MatchString(re, s)
+// Irc configures the IRC connector.
+type Irc struct {
+	Server, Pass string
+}
+
+// Slack configures the Slack connector.
+type Slack struct {
+	// Token is the bot token (xoxb-) used for the Web API
+	Token string
+	// AppToken is the app-level token (xapp-) used to open Socket Mode
+	// connections
+	AppToken string
+	// Mode is either "socket" (the default) or "events" to receive
+	// events from the Events API on the bot's HTTP server
+	Mode string
+	// SigningSecret verifies Events API requests
+	SigningSecret string
+	// EventsPath is where the Events API posts, defaults to /slack/events,
+	// or /slack/events/<name> for a connector
+	EventsPath string
+	// APIURL overrides the Web API base, mostly for testing
+	APIURL string
+}
+
+// Discord configures the Discord connector.
+type Discord struct {
+	// Token is the bot token from the Discord developer portal, where the
+	// bot also needs the message content intent turned on
+	Token string
+	// GuildID is the server whose members and emoji the bot uses
+	GuildID string
+	// APIURL overrides the REST API base, mostly for testing
+	APIURL string
+}
+
+// Matrix configures the Matrix connector.
+type Matrix struct {
+	// Homeserver is the base URL of the bot's homeserver
+	Homeserver string
+	// UserID is the bot's full user ID, e.g. @catbase:example.org
+	UserID string
+	// AccessToken authenticates the bot to the client-server API
+	AccessToken string
+}
+
+// Connector is one of several connections the bot makes at once. Its channels
+// are the entries of Config.Channels prefixed with its name and a colon, for
+// example "freenode:#catbase", and plugins see channels named that way.
+type Connector struct {
+	// Name identifies the connection in channel names and so must not
+	// contain a colon
+	Name string
+	// Type is the kind of connection: irc, slack, discord or matrix
+	Type string
+	// Irc, Slack, Discord and Matrix override the top level settings of
+	// the same name, so that two connections of one type can differ
+	Irc     Irc
+	Slack   Slack
+	Discord Discord
+	Matrix  Matrix
+}
+
+// ForConnector returns the configuration one of the Connectors runs with: a
+// copy of c with its Type, its overrides and only its channels, stripped of
+// their prefix. Its Current is the same for the config as it is now.
+func (c *Config) ForConnector(conn Connector) *Config {
+	cc := *c
+	cc.Type = conn.Type
+	cc.channelPrefix = conn.Name + ":"
+	cc.connector = &conn
+	if conn.Irc != (Irc{}) {
+		cc.Irc = conn.Irc
+	}
+	if conn.Slack != (Slack{}) {
+		cc.Slack = conn.Slack
+	}
+	if conn.Discord != (Discord{}) {
+		cc.Discord = conn.Discord
+	}
+	if conn.Matrix != (Matrix{}) {
+		cc.Matrix = conn.Matrix
+	}
+	prefix := conn.Name + ":"
+	cc.Channels = nil
+	for _, ch := range c.Channels {
+		if strings.HasPrefix(ch, prefix) {
+			cc.Channels = append(cc.Channels, strings.TrimPrefix(ch, prefix))
+		}
+	}
+	cc.MainChannel = ""
+	if strings.HasPrefix(c.MainChannel, prefix) {
+		cc.MainChannel = strings.TrimPrefix(c.MainChannel, prefix)
+	}
+	return &cc
+}
+
+// SlackEventsPath is where the bot's HTTP server takes Events API posts for
+// this Slack connection.
+func (c *Config) SlackEventsPath() string {
+	if c.Slack.EventsPath != "" {
+		return c.Slack.EventsPath
+	}
+	if c.channelPrefix != "" {
+		return "/slack/events/" + strings.TrimSuffix(c.channelPrefix, ":")
 	}
-	sql.Register("sqlite3_custom",
-		&sqlite3.SQLiteDriver{
-			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
-				return conn.RegisterFunc("REGEXP", regex, true)
-			},
-		})
+	return "/slack/events"
+}
+
+// Webhook is an incoming webhook, served at /webhook/<Name>.
+type Webhook struct {
+	Name string
+	// Token must be given as the X-Catbase-Token header or the token
+	// query parameter
+	Token    string
+	Channels []string
+	// Format is how the payload is turned into a message: text (the
+	// default), alert or gitpush. It is ignored when Template is set.
+	Format string
+	// Template is a Go text/template executed with the decoded JSON
+	// payload
+	Template string
+}
+
+// OutgoingWebhook POSTs messages matching all of its rules to URL as JSON.
+type OutgoingWebhook struct {
+	Name string
+	URL  string
+	// Match is a regular expression the body must match, if set
+	Match string
+	// Channels and Users limit the hook to messages from those, if set
+	Channels []string
+	Users    []string
+	// Command only forwards messages addressed to the bot
+	Command bool
+	// Reply posts the response back into the channel, and stops the
+	// message from reaching later plugins
+	Reply bool
+	// Token is sent as the X-Catbase-Token header
+	Token string
+}
+
+// External configures a plugin run as a separate program.
+type External struct {
+	Name string
+	// Command is the program and its arguments
+	Command []string
+	// Dir is the directory to run it in, by default the bot's
+	Dir string
+	// Env is added to the bot's environment, as KEY=value
+	Env []string
 }
 
 type Replacement struct {
@@ -130,32 +318,46 @@ type Replacement struct {
 	Frequency float64
 }
 
+// DBSource is the driver and source to open the database with.
+func (c *Config) DBSource() (string, string) {
+	if c.DB.Type == "postgres" {
+		return database.Postgres, database.PostgresSource(c.DB.Server, c.DB.Name,
+			c.DB.User, c.DB.Password, c.DB.Options)
+	}
+	return database.SQLite, c.DB.File
+}
+
+// BackupDir is the directory for backups and exports.
+func (c *Config) BackupDir() string {
+	if c.DB.BackupDir != "" {
+		return c.DB.BackupDir
+	}
+	return filepath.Dir(c.DB.File)
+}
+
 // Readconfig loads the config data out of a JSON file located in cfile
 func Readconfig(version, cfile string) *Config {
 	fmt.Printf("Using %s as config file.\n", cfile)
-	L := lua.NewState()
-	if err := L.DoFile(cfile); err != nil {
-		panic(err)
-	}
-
-	var c Config
-	if err := gluamapper.Map(L.GetGlobal("config").(*lua.LTable), &c); err != nil {
-		panic(err)
+	c, errs := Load(cfile)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			log.Println(err)
+		}
+		log.Fatalf("%s has %d problems, see above.", cfile, len(errs))
 	}
 
 	c.Version = version
 
-	if c.Type == "" {
-		c.Type = "irc"
-	}
-
 	fmt.Printf("godeepintir version %s running.\n", c.Version)
 
-	sqlDB, err := sqlx.Open("sqlite3_custom", c.DB.File)
+	sqlDB, err := database.Open(c.DBSource())
 	if err != nil {
 		log.Fatal(err)
 	}
 	c.DBConn = sqlDB
+	if err := c.LoadOverrides(); err != nil {
+		log.Fatal("Couldn't load changed settings: ", err)
+	}
 
-	return &c
+	return c
 }
@@ -0,0 +1,174 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// envPrefix starts the name of every environment variable that overrides a
+// setting.
+const envPrefix = "CATBASE_"
+
+// lookupEnv is os.LookupEnv, replaced by tests.
+var lookupEnv = os.LookupEnv
+
+// loadSecrets runs each of the config's SecretsFiles, merging the secrets
+// table each sets into config. Relative names are relative to the directory
+// of the config file.
+func loadSecrets(L *lua.LState, cfile string, config *lua.LTable) error {
+	files, ok := config.RawGetString("SecretsFiles").(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	for i := 1; i <= files.MaxN(); i++ {
+		name := lua.LVAsString(files.RawGetInt(i))
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(cfile), name)
+		}
+		L.SetGlobal("secrets", lua.LNil)
+		if err := L.DoFile(name); err != nil {
+			return err
+		}
+		secrets, ok := L.GetGlobal("secrets").(*lua.LTable)
+		if !ok {
+			return fmt.Errorf("%s doesn't set secrets to a table", name)
+		}
+		merge(config, secrets)
+	}
+	return nil
+}
+
+// merge copies the settings in from into to. Tables of settings are merged
+// rather than replaced, but lists are replaced.
+func merge(to, from *lua.LTable) {
+	from.ForEach(func(k, v lua.LValue) {
+		fromTbl, ok := v.(*lua.LTable)
+		toTbl, ok2 := to.RawGet(k).(*lua.LTable)
+		if ok && ok2 && fromTbl.MaxN() == 0 && toTbl.MaxN() == 0 {
+			merge(toTbl, fromTbl)
+			return
+		}
+		to.RawSet(k, v)
+	})
+}
+
+// EnvName is the environment variable that overrides the setting at path,
+// such as CATBASE_SLACK_APP_TOKEN for Slack.AppToken. Adding _FILE to the
+// name reads the value from that file instead.
+func EnvName(path ...string) string {
+	words := []string{}
+	for _, p := range path {
+		words = append(words, snake(p))
+	}
+	return envPrefix + strings.Join(words, "_")
+}
+
+// snake converts a field name like TwitterConsumerKey or DBPath to
+// TWITTER_CONSUMER_KEY or DB_PATH.
+func snake(name string) string {
+	rs := []rune(name)
+	out := []rune{}
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) &&
+			(unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1]) ||
+				(i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToUpper(r))
+	}
+	return string(out)
+}
+
+// applyEnv overrides settings from the environment. Strings, numbers,
+// booleans and lists of strings (separated by commas) can be set this way.
+func applyEnv(v reflect.Value, path []string) []error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Name == "Version" {
+			continue
+		}
+		fieldPath := append(append([]string{}, path...), f.Name)
+		if f.Type.Kind() == reflect.Struct {
+			errs = append(errs, applyEnv(v.Field(i), fieldPath)...)
+			continue
+		}
+		if !settable(f.Type) {
+			continue
+		}
+
+		name := EnvName(fieldPath...)
+		value, ok := lookupEnv(name)
+		if file, fok := lookupEnv(name + "_FILE"); fok {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %s", name, err))
+				continue
+			}
+			value, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+		if err := setString(v.Field(i), value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+		}
+	}
+	return errs
+}
+
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setString sets v from the text of an environment variable.
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", s)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
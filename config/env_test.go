@@ -0,0 +1,93 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeEnv(env map[string]string) func() {
+	lookupEnv = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	return func() { lookupEnv = os.LookupEnv }
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "CATBASE_SLACK_APP_TOKEN", EnvName("Slack", "AppToken"))
+	assert.Equal(t, "CATBASE_TWITTER_CONSUMER_KEY", EnvName("TwitterConsumerKey"))
+	assert.Equal(t, "CATBASE_STATS_DB_PATH", EnvName("Stats", "DBPath"))
+	assert.Equal(t, "CATBASE_MATRIX_USER_ID", EnvName("Matrix", "UserID"))
+	assert.Equal(t, "CATBASE_HTTP_ADDR", EnvName("HttpAddr"))
+}
+
+func TestEnvOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catbase-env")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(secret, []byte("from-file\n"), 0600))
+
+	defer fakeEnv(map[string]string{
+		"CATBASE_NICK":               "envcat",
+		"CATBASE_RATE_PER_SEC":       "2.5",
+		"CATBASE_ADMINS":             "alice, bob",
+		"CATBASE_UNTAPPD_TOKEN_FILE": secret,
+	})()
+	c, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Irc = { Server = "irc" },
+		RatePerSec = 1,
+		Untappd = { Token = "in-config" },
+	}`)
+	assert.Empty(t, errs)
+	assert.Equal(t, "envcat", c.Nick)
+	assert.Equal(t, 2.5, c.RatePerSec)
+	assert.Equal(t, []string{"alice", "bob"}, c.Admins)
+	assert.Equal(t, "from-file", c.Untappd.Token)
+}
+
+func TestEnvBadValues(t *testing.T) {
+	defer fakeEnv(map[string]string{
+		"CATBASE_LOG_LENGTH":           "lots",
+		"CATBASE_SLACK_TOKEN_FILE":     "/nonexistent/token",
+		"CATBASE_BRIDGE_EDITS":         "sometimes",
+		"CATBASE_EMOJIFY_CHANCE":       "0.1",
+		"CATBASE_SOMETHING_IRRELEVANT": "x",
+	})()
+	_, errs := load(t, `config = { Nick = "cat", DB = { File = "cat.db" } }`)
+	assert.Len(t, errs, 3)
+}
+
+func TestSecretsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catbase-secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "secrets.lua"), []byte(`secrets = {
+		Slack = { Token = "xoxb", AppToken = "xapp" },
+		Admins = { "root" },
+	}`), 0600))
+	cfile := filepath.Join(dir, "config.lua")
+	assert.Nil(t, ioutil.WriteFile(cfile, []byte(`config = {
+		Nick = "cat",
+		Type = "slack",
+		DB = { File = "cat.db" },
+		Admins = { "alice", "bob" },
+		Slack = { Mode = "socket" },
+		SecretsFiles = { "secrets.lua" },
+	}`), 0600))
+
+	c, errs := Load(cfile)
+	assert.Empty(t, errs)
+	assert.Equal(t, "xoxb", c.Slack.Token)
+	assert.Equal(t, "xapp", c.Slack.AppToken)
+	assert.Equal(t, "socket", c.Slack.Mode)
+	assert.Equal(t, []string{"root"}, c.Admins)
+}
@@ -0,0 +1,401 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/velour/catbase/database"
+)
+
+// Settings can be changed while the bot runs. Each change is an override kept
+// in the database on top of the config file, either everywhere or in one
+// channel. A Config is never changed once it is in use, so it can be read
+// without locking: changes everywhere make a new one, which Current returns
+// from then on, and a channel's are seen through ForChannel.
+
+// overrideMu guards every Config's overrides, and making new Configs.
+var overrideMu sync.Mutex
+
+// shared is what a Config has in common with every copy made of it.
+type shared struct {
+	// current holds the latest *Config, with the changes everywhere.
+	current atomic.Value
+	// overrides is nil until they are loaded.
+	overrides *overrides
+}
+
+// share makes c the Config its copies share, if none is yet. The caller must
+// hold overrideMu.
+func (c *Config) share() *shared {
+	if c.shared == nil {
+		c.shared = &shared{}
+		c.shared.current.Store(c)
+	}
+	return c.shared
+}
+
+// Current returns the config as it is now, with the settings changed
+// everywhere since c was made, or since it was copied for a connector. Code
+// that runs for as long as the bot does should read settings through it, or
+// ForChannel, instead of keeping them.
+func (c *Config) Current() *Config {
+	if c.shared == nil {
+		return c
+	}
+	cur := c.shared.current.Load().(*Config)
+	if c.connector != nil {
+		return cur.ForConnector(*c.connector)
+	}
+	return cur
+}
+
+// channelKey is what channel is called across connectors: with c's
+// connector's prefix, unless it's everywhere.
+func (c *Config) channelKey(channel string) string {
+	if channel == "" {
+		return ""
+	}
+	return c.channelPrefix + channel
+}
+
+// overridden returns the overrides in channel, by its channelKey. The caller
+// must hold overrideMu.
+func (c *Config) overridden(channel string) map[string]string {
+	if c.shared == nil || c.shared.overrides == nil {
+		return nil
+	}
+	return c.shared.overrides.channels[channel]
+}
+
+// overrides are the changes to a Config and what it was before them.
+type overrides struct {
+	defaults Config
+	// channels maps a channel, or "" for everywhere, to the overridden
+	// paths and their values.
+	channels map[string]map[string]string
+}
+
+// Override is one setting changed at runtime. An empty Channel changes it
+// everywhere.
+type Override struct {
+	Channel string
+	Path    string
+	Value   string
+}
+
+// Setting is a setting's value and where it came from: "file", "everywhere"
+// or "channel".
+type Setting struct {
+	Path   string
+	Value  string
+	Source string
+	Secret bool
+}
+
+// LoadOverrides applies the overrides stored in the database. It needs
+// DBConn.
+func (c *Config) LoadOverrides() error {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	return c.initOverrides()
+}
+
+// initOverrides creates the overrides table and reads it, if that hasn't
+// been done yet. The caller must hold overrideMu.
+func (c *Config) initOverrides() error {
+	sh := c.share()
+	if sh.overrides != nil {
+		return nil
+	}
+	if c.DBConn == nil {
+		return fmt.Errorf("settings can't be changed without a database")
+	}
+	if err := database.CreateTable(c.DBConn, `create table if not exists config_overrides (
+			channel string,
+			path string,
+			value string,
+			primary key (channel, path)
+		);`); err != nil {
+		return err
+	}
+	var rows []Override
+	if err := c.DBConn.Select(&rows, `select channel, path, value from config_overrides`); err != nil {
+		return err
+	}
+
+	cur := sh.current.Load().(*Config)
+	next := *cur
+	o := &overrides{defaults: *cur, channels: map[string]map[string]string{}}
+	for _, r := range rows {
+		if r.Channel == "" {
+			if err := next.set(r.Path, r.Value); err != nil {
+				log.Printf("Ignoring the stored setting of %s: %s", r.Path, err)
+				continue
+			}
+		}
+		if o.channels[r.Channel] == nil {
+			o.channels[r.Channel] = map[string]string{}
+		}
+		o.channels[r.Channel][r.Path] = r.Value
+	}
+	sh.overrides = o
+	sh.current.Store(&next)
+	return nil
+}
+
+// ForChannel returns the configuration in effect in channel: c, with what
+// the config file's ChannelSettings and the overrides give for that channel
+// on top, as it is now. Plugins should read settings through it wherever
+// there is a channel.
+func (c *Config) ForChannel(channel string) *Config {
+	c = c.Current()
+	if channel == "" {
+		return c
+	}
+	channel = c.channelKey(channel)
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	changed := c.overridden(channel)
+	if _, ok := c.channels[channel]; !ok && len(changed) == 0 {
+		return c
+	}
+	cc := *c
+	cc.applyChannel(channel)
+	for path, value := range changed {
+		if err := cc.set(path, value); err != nil {
+			log.Printf("Ignoring the setting of %s in %s: %s", path, channel, err)
+		}
+	}
+	return &cc
+}
+
+// Set changes the setting at path to value in channel, or everywhere if
+// channel is empty, and stores the change. Lists are given separated by
+// commas. Changes that would make the config invalid are refused.
+func (c *Config) Set(channel, path, value string) error {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	if err := c.initOverrides(); err != nil {
+		return err
+	}
+	path, err := c.editable(path)
+	if err != nil {
+		return err
+	}
+	channel = c.channelKey(channel)
+
+	cur := c.shared.current.Load().(*Config)
+	before := map[string]bool{}
+	for _, err := range cur.Validate() {
+		before[err.Error()] = true
+	}
+	cc := *cur
+	cc.applyChannel(channel)
+	for p, v := range c.overridden(channel) {
+		cc.set(p, v)
+	}
+	if err := cc.set(path, value); err != nil {
+		return err
+	}
+	for _, err := range cc.Validate() {
+		if !before[err.Error()] {
+			return err
+		}
+	}
+
+	q := database.Upsert(c.DBConn, "config_overrides", []string{"channel", "path"}, "value")
+	if _, err := c.DBConn.Exec(q, channel, path, value); err != nil {
+		return err
+	}
+	o := c.shared.overrides
+	if o.channels[channel] == nil {
+		o.channels[channel] = map[string]string{}
+	}
+	o.channels[channel][path] = value
+	if channel == "" {
+		next := *cur
+		next.set(path, value)
+		c.shared.current.Store(&next)
+	}
+	return nil
+}
+
+// Unset removes the override of path in channel, or everywhere if channel is
+// empty.
+func (c *Config) Unset(channel, path string) error {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	if err := c.initOverrides(); err != nil {
+		return err
+	}
+	path, err := c.editable(path)
+	if err != nil {
+		return err
+	}
+	channel = c.channelKey(channel)
+	if _, ok := c.overridden(channel)[path]; !ok {
+		return fmt.Errorf("%s isn't changed there", path)
+	}
+	if _, err := c.DBConn.Exec(`delete from config_overrides where channel=? and path=?`,
+		channel, path); err != nil {
+		return err
+	}
+	delete(c.shared.overrides.channels[channel], path)
+	if channel == "" {
+		next := *c.shared.current.Load().(*Config)
+		from, _, _ := lookup(reflect.ValueOf(&c.shared.overrides.defaults).Elem(), path)
+		to, _, _ := lookup(reflect.ValueOf(&next).Elem(), path)
+		to.Set(from)
+		c.shared.current.Store(&next)
+	}
+	return nil
+}
+
+// Get describes the setting at path in channel. Secrets aren't shown.
+func (c *Config) Get(channel, path string) (Setting, error) {
+	cc := c.ForChannel(channel)
+	v, path, err := lookup(reflect.ValueOf(cc).Elem(), path)
+	if err != nil {
+		return Setting{}, err
+	}
+	return c.setting(channel, path, v), nil
+}
+
+// Settings describes every setting that can be changed, as it is in
+// channel.
+func (c *Config) Settings(channel string) []Setting {
+	cc := c.ForChannel(channel)
+	settings := []Setting{}
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Name == "Version" {
+				continue
+			}
+			p := strings.TrimPrefix(path+"."+f.Name, ".")
+			if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), p)
+			} else if settable(f.Type) {
+				settings = append(settings, c.setting(channel, p, v.Field(i)))
+			}
+		}
+	}
+	walk(reflect.ValueOf(cc).Elem(), "")
+	return settings
+}
+
+// Overrides lists the settings changed at runtime.
+func (c *Config) Overrides() []Override {
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	list := []Override{}
+	if c.shared == nil || c.shared.overrides == nil {
+		return list
+	}
+	for channel, paths := range c.shared.overrides.channels {
+		for path, value := range paths {
+			if isSecret(path) {
+				value = "(secret)"
+			}
+			list = append(list, Override{Channel: channel, Path: path, Value: value})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Channel != list[j].Channel {
+			return list[i].Channel < list[j].Channel
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+func (c *Config) setting(channel, path string, v reflect.Value) Setting {
+	s := Setting{Path: path, Source: "file", Secret: isSecret(path)}
+	if s.Secret {
+		s.Value = "(secret)"
+	} else {
+		s.Value = formatValue(v)
+	}
+	overrideMu.Lock()
+	defer overrideMu.Unlock()
+	if _, ok := c.overridden(c.channelKey(channel))[path]; ok && channel != "" {
+		s.Source = "channel"
+	} else if _, ok := c.overridden("")[path]; ok {
+		s.Source = "everywhere"
+	}
+	return s
+}
+
+// set sets the setting at path from its text. c must not be in use yet.
+func (c *Config) set(path, value string) error {
+	v, path, err := lookup(reflect.ValueOf(c).Elem(), path)
+	if err != nil {
+		return err
+	}
+	if err := setString(v, value); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// editable checks that the setting at path may be changed at runtime,
+// returning its proper name.
+func (c *Config) editable(path string) (string, error) {
+	_, path, err := lookup(reflect.ValueOf(c).Elem(), path)
+	if err != nil {
+		return "", err
+	}
+	if isSecret(path) {
+		return "", fmt.Errorf("%s is a secret and can only be set in the config file", path)
+	}
+	return path, nil
+}
+
+// lookup finds the field a dotted path like emojify.chance names, returning
+// it and the path spelled as the field names are.
+func lookup(v reflect.Value, path string) (reflect.Value, string, error) {
+	names := []string{}
+	for _, part := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, "", fmt.Errorf("%s isn't a setting", path)
+		}
+		f, ok := fieldByKey(v.Type(), part)
+		if !ok || f.PkgPath != "" || f.Name == "Version" {
+			return reflect.Value{}, "", fmt.Errorf("%s isn't a setting", path)
+		}
+		names = append(names, f.Name)
+		v = v.FieldByIndex(f.Index)
+	}
+	if !settable(v.Type()) {
+		return reflect.Value{}, "", fmt.Errorf("%s can't be changed while I'm running", path)
+	}
+	return v, strings.Join(names, "."), nil
+}
+
+// isSecret reports whether a setting holds a password or token.
+func isSecret(path string) bool {
+	name := path[strings.LastIndex(path, ".")+1:]
+	for _, s := range []string{"Token", "Secret", "Authorization", "Pass", "Key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue writes a setting the way Set reads it.
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ", ")
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
@@ -0,0 +1,129 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeConfig(t *testing.T) *Config {
+	db, err := sqlx.Open("sqlite3_custom", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	c := &Config{DBConn: db, Nick: "cat", Type: "irc", RatePerSec: 1}
+	c.DB.File = ":memory:"
+	c.Irc.Server = "irc"
+	c.Emojify.Chance = 0.02
+	c.Reaction.HarrassList = []string{"msherms"}
+	c.Slack.Token = "xoxb"
+	return c
+}
+
+func TestSetEverywhere(t *testing.T) {
+	c := makeConfig(t)
+	assert.Nil(t, c.Set("", "emojify.chance", "0.5"))
+	assert.Equal(t, 0.5, c.Current().Emojify.Chance)
+	s, err := c.Get("#any", "Emojify.Chance")
+	assert.Nil(t, err)
+	assert.Equal(t, Setting{Path: "Emojify.Chance", Value: "0.5", Source: "everywhere"}, s)
+
+	assert.Nil(t, c.Set("", "Reaction.HarrassList", "a, b"))
+	assert.Equal(t, []string{"a", "b"}, c.Current().Reaction.HarrassList)
+
+	assert.Nil(t, c.Unset("", "Emojify.Chance"))
+	assert.Equal(t, 0.02, c.Current().Emojify.Chance)
+	assert.NotNil(t, c.Unset("", "Emojify.Chance"))
+}
+
+func TestSetChannel(t *testing.T) {
+	c := makeConfig(t)
+	assert.Nil(t, c.Set("#cats", "Emojify.Chance", "1"))
+	assert.Equal(t, 0.02, c.Current().Emojify.Chance)
+	assert.Equal(t, 1.0, c.ForChannel("#cats").Emojify.Chance)
+	assert.Equal(t, 0.02, c.ForChannel("#dogs").Emojify.Chance)
+	s, _ := c.Get("#cats", "Emojify.Chance")
+	assert.Equal(t, "channel", s.Source)
+	assert.Equal(t, []Override{{Channel: "#cats", Path: "Emojify.Chance", Value: "1"}}, c.Overrides())
+}
+
+func TestSetRefusals(t *testing.T) {
+	c := makeConfig(t)
+	assert.EqualError(t, c.Set("", "Emojify.Chance", "lots"), `Emojify.Chance: "lots" is not a number`)
+	assert.EqualError(t, c.Set("", "Emojify.Chance", "2"), "Emojify.Chance: 2 is not a probability between 0 and 1")
+	assert.EqualError(t, c.Set("", "Emojify.Odds", "1"), "Emojify.Odds isn't a setting")
+	assert.EqualError(t, c.Set("", "Emojify", "1"), "Emojify can't be changed while I'm running")
+	assert.EqualError(t, c.Set("", "Slack.Token", "x"), "Slack.Token is a secret and can only be set in the config file")
+	assert.Equal(t, 0.02, c.Current().Emojify.Chance)
+	assert.Empty(t, c.Overrides())
+
+	s, err := c.Get("", "Slack.Token")
+	assert.Nil(t, err)
+	assert.Equal(t, "(secret)", s.Value)
+}
+
+func TestOverridesPersist(t *testing.T) {
+	c := makeConfig(t)
+	assert.Nil(t, c.Set("", "Emojify.Chance", "0.5"))
+	assert.Nil(t, c.Set("#cats", "Nick", "kitty"))
+
+	restarted := makeConfig(t)
+	restarted.DBConn = c.DBConn
+	assert.Nil(t, restarted.LoadOverrides())
+	assert.Equal(t, 0.5, restarted.Current().Emojify.Chance)
+	assert.Equal(t, "cat", restarted.Nick)
+	assert.Equal(t, "kitty", restarted.ForChannel("#cats").Nick)
+}
+
+func TestSetLeavesConfigsInUseAlone(t *testing.T) {
+	c := makeConfig(t)
+	before := c.Current()
+	assert.Nil(t, c.Set("", "Emojify.Chance", "0.5"))
+	assert.Equal(t, 0.02, before.Emojify.Chance)
+	assert.Equal(t, 0.5, c.Current().Emojify.Chance)
+	assert.Equal(t, 0.5, c.ForChannel("#cats").Emojify.Chance)
+}
+
+func TestConnectorsSeeChanges(t *testing.T) {
+	c := makeConfig(t)
+	assert.Nil(t, c.LoadOverrides())
+	irc := c.ForConnector(Connector{Name: "irc", Type: "irc"})
+	slack := c.ForConnector(Connector{Name: "slack", Type: "slack"})
+
+	assert.Nil(t, c.Set("", "Emojify.Chance", "0.5"))
+	assert.Equal(t, 0.5, irc.Current().Emojify.Chance)
+	assert.Equal(t, 0.5, slack.ForChannel("#cats").Emojify.Chance)
+	assert.Equal(t, "slack", slack.Current().Type)
+
+	assert.Nil(t, slack.Set("#cats", "Emojify.Chance", "1"))
+	assert.Equal(t, 1.0, slack.ForChannel("#cats").Emojify.Chance)
+	assert.Equal(t, 0.5, irc.ForChannel("#cats").Emojify.Chance)
+	s, _ := slack.Get("#cats", "Emojify.Chance")
+	assert.Equal(t, "channel", s.Source)
+}
+
+func TestSetWhileReading(t *testing.T) {
+	c := makeConfig(t)
+	assert.Nil(t, c.LoadOverrides())
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			c.Set("", "Reaction.HarrassList", "a, b")
+			c.Unset("", "Reaction.HarrassList")
+		}
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			list := c.ForChannel("#cats").Reaction.HarrassList
+			assert.True(t, len(list) == 1 || len(list) == 2, "%v", list)
+		}
+	}
+}
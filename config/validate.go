@@ -0,0 +1,270 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/gluamapper"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Load reads a config file along with its secrets files and environment
+// overrides, and checks it, returning every problem found with it. Unlike
+// Readconfig it doesn't open the database.
+func Load(cfile string) (*Config, []error) {
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoFile(cfile); err != nil {
+		return nil, []error{err}
+	}
+	tbl, ok := L.GetGlobal("config").(*lua.LTable)
+	if !ok {
+		return nil, []error{fmt.Errorf("%s doesn't set config to a table", cfile)}
+	}
+	if err := loadSecrets(L, cfile, tbl); err != nil {
+		return nil, []error{err}
+	}
+
+	var errs []error
+	channels := takeChannelSettings(tbl, &errs)
+	checkKeys("config", tbl, reflect.TypeOf(Config{}), &errs)
+	if len(errs) > 0 {
+		// the mapper's errors would only repeat these less clearly
+		return nil, errs
+	}
+	var c Config
+	if err := gluamapper.Map(tbl, &c); err != nil {
+		return nil, []error{err}
+	}
+	if errs := applyEnv(reflect.ValueOf(&c).Elem(), nil); len(errs) > 0 {
+		return nil, errs
+	}
+	if c.Type == "" {
+		c.Type = "irc"
+	}
+	c.channels = channels
+
+	errs = c.Validate()
+	seen := map[string]bool{}
+	for _, err := range errs {
+		seen[err.Error()] = true
+	}
+	for channel := range channels {
+		for _, err := range c.ForChannel(channel).Validate() {
+			if !seen[err.Error()] {
+				errs = append(errs, fmt.Errorf("ChannelSettings[%s]: %s", channel, err))
+			}
+		}
+	}
+	return &c, errs
+}
+
+// fieldByKey finds the struct field a config key maps to, the way the mapper
+// does.
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	name := gluamapper.ToUpperCamelCase(key)
+	return t.FieldByNameFunc(func(f string) bool {
+		return ast.IsExported(f) && strings.EqualFold(f, name)
+	})
+}
+
+// checkKeys compares a Lua value with the type it will be mapped to, noting
+// keys that map to nothing and values of the wrong type.
+func checkKeys(path string, lv lua.LValue, t reflect.Type, errs *[]error) {
+	mismatch := func(want string) {
+		*errs = append(*errs, fmt.Errorf("%s: expected %s, got a %s", path, want, lv.Type()))
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		tbl, ok := lv.(*lua.LTable)
+		if !ok {
+			mismatch("a table")
+			return
+		}
+		tbl.ForEach(func(k, v lua.LValue) {
+			key := k.String()
+			f, ok := fieldByKey(t, key)
+			if !ok || f.PkgPath != "" || f.Type.Kind() == reflect.Ptr || f.Name == "Version" {
+				*errs = append(*errs, fmt.Errorf("%s.%s: unknown setting", path, key))
+				return
+			}
+			checkKeys(path+"."+key, v, f.Type, errs)
+		})
+	case reflect.Slice:
+		tbl, ok := lv.(*lua.LTable)
+		if !ok {
+			mismatch("a list")
+			return
+		}
+		for i := 1; i <= tbl.MaxN(); i++ {
+			checkKeys(fmt.Sprintf("%s[%d]", path, i), tbl.RawGetInt(i), t.Elem(), errs)
+		}
+	case reflect.Map:
+		tbl, ok := lv.(*lua.LTable)
+		if !ok {
+			mismatch("a table")
+			return
+		}
+		tbl.ForEach(func(k, v lua.LValue) {
+			checkKeys(fmt.Sprintf("%s[%s]", path, k), v, t.Elem(), errs)
+		})
+	case reflect.String:
+		if lv.Type() != lua.LTString && lv.Type() != lua.LTNumber {
+			mismatch("a string")
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		if lv.Type() != lua.LTNumber {
+			mismatch("a number")
+		}
+	case reflect.Bool:
+		if lv.Type() != lua.LTBool {
+			mismatch("true or false")
+		}
+	}
+}
+
+// connectorTypes are the values Type may take.
+var connectorTypes = map[string]bool{"irc": true, "slack": true, "discord": true, "matrix": true}
+
+// Validate checks that the settings make sense together, such as that each
+// connection has what it needs to connect.
+func (c *Config) Validate() []error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Nick == "" {
+		add("Nick: required")
+	}
+	switch c.DB.Type {
+	case "", "sqlite":
+		if c.DB.File == "" {
+			add("DB.File: required")
+		}
+	case "postgres":
+		if c.DB.Name == "" {
+			add("DB.Name: required for postgres")
+		}
+	default:
+		add("DB.Type: must be sqlite or postgres, not %q", c.DB.Type)
+	}
+
+	if len(c.Connectors) == 0 {
+		c.validateConnection("config", c, add)
+	}
+	names := map[string]bool{}
+	eventsPaths := map[string]string{}
+	for i, conn := range c.Connectors {
+		path := fmt.Sprintf("Connectors[%d]", i+1)
+		switch {
+		case conn.Name == "":
+			add("%s.Name: required", path)
+		case strings.Contains(conn.Name, ":"):
+			add("%s.Name: %q may not contain a colon", path, conn.Name)
+		case names[conn.Name]:
+			add("%s.Name: %q is used twice", path, conn.Name)
+		}
+		names[conn.Name] = true
+		cc := c.ForConnector(conn)
+		c.validateConnection(path, cc, add)
+		if cc.Type == "slack" && cc.Slack.Mode == "events" {
+			p := cc.SlackEventsPath()
+			if other, ok := eventsPaths[p]; ok {
+				add("%s.Slack.EventsPath: %s is also used by %s", path, p, other)
+			}
+			eventsPaths[p] = path
+		}
+	}
+	if len(c.Connectors) > 0 {
+		for i, ch := range c.Channels {
+			if parts := strings.SplitN(ch, ":", 2); len(parts) != 2 || !names[parts[0]] {
+				add("Channels[%d]: %q must start with the name of a connector and a colon", i+1, ch)
+			}
+		}
+	}
+
+	chance := func(path string, p float64) {
+		if p < 0 || p > 1 {
+			add("%s: %v is not a probability between 0 and 1", path, p)
+		}
+	}
+	chance("Factoid.QuoteChance", c.Factoid.QuoteChance)
+	chance("Emojify.Chance", c.Emojify.Chance)
+	chance("Reaction.GeneralChance", c.Reaction.GeneralChance)
+	chance("Reaction.HarrassChance", c.Reaction.HarrassChance)
+	for i, r := range c.Your.Replacements {
+		chance(fmt.Sprintf("Your.Replacements[%d].Frequency", i+1), r.Frequency)
+	}
+	if (c.Reaction.GeneralChance > 0 || c.Reaction.HarrassChance > 0) &&
+		len(c.Reaction.PositiveReactions) == 0 {
+		add("Reaction.PositiveReactions: required when reactions have a chance")
+	}
+	if c.Reaction.NegativeHarrassmentMultiplier < 0 {
+		add("Reaction.NegativeHarrassmentMultiplier: may not be negative")
+	}
+
+	for i, w := range c.Webhooks {
+		path := fmt.Sprintf("Webhooks[%d]", i+1)
+		if w.Name == "" || w.Token == "" || len(w.Channels) == 0 {
+			add("%s: Name, Token and Channels are required", path)
+		}
+	}
+	for i, w := range c.OutgoingWebhooks {
+		if w.URL == "" {
+			add("OutgoingWebhooks[%d].URL: required", i+1)
+		}
+	}
+	for i, e := range c.External {
+		if e.Name == "" || len(e.Command) == 0 {
+			add("External[%d]: Name and Command are required", i+1)
+		}
+	}
+	for i, group := range c.Bridge.Channels {
+		if len(group) < 2 {
+			add("Bridge.Channels[%d]: a bridge needs at least two channels", i+1)
+		}
+	}
+	return errs
+}
+
+// validateConnection checks the settings one connection of type c.Type
+// needs.
+func (c *Config) validateConnection(path string, cc *Config, add func(string, ...interface{})) {
+	if !connectorTypes[cc.Type] {
+		add("%s.Type: unknown connection type %q", path, cc.Type)
+		return
+	}
+	required := func(setting, value string) {
+		if value == "" {
+			add("%s.%s: required for %s", path, setting, cc.Type)
+		}
+	}
+	switch cc.Type {
+	case "irc":
+		required("Irc.Server", cc.Irc.Server)
+		if c.RatePerSec <= 0 {
+			add("RatePerSec: must be more than 0 for irc")
+		}
+	case "slack":
+		required("Slack.Token", cc.Slack.Token)
+		switch cc.Slack.Mode {
+		case "", "socket":
+			required("Slack.AppToken", cc.Slack.AppToken)
+		case "events":
+			required("Slack.SigningSecret", cc.Slack.SigningSecret)
+		default:
+			add("%s.Slack.Mode: must be socket or events, not %q", path, cc.Slack.Mode)
+		}
+	case "discord":
+		required("Discord.Token", cc.Discord.Token)
+	case "matrix":
+		required("Matrix.Homeserver", cc.Matrix.Homeserver)
+		required("Matrix.UserID", cc.Matrix.UserID)
+		required("Matrix.AccessToken", cc.Matrix.AccessToken)
+	}
+}
@@ -0,0 +1,120 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func load(t *testing.T, src string) (*Config, []string) {
+	f, err := ioutil.TempFile("", "catbase-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	c, errs := Load(f.Name())
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return c, msgs
+}
+
+func TestExampleConfig(t *testing.T) {
+	c, errs := Load("../example_config.lua")
+	assert.Empty(t, errs)
+	assert.NotNil(t, c)
+}
+
+func TestUnknownKeysAndTypes(t *testing.T) {
+	_, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Your = { DuckingChance = 0.5, max_length = "long" },
+		Irc = { Server = 6667 },
+		RatePerSec = true,
+	}`)
+	assert.Contains(t, errs, "config.Your.DuckingChance: unknown setting")
+	assert.Contains(t, errs, "config.Your.max_length: expected a number, got a string")
+	assert.Contains(t, errs, "config.RatePerSec: expected a number, got a boolean")
+	// numbers are fine where strings are expected
+	assert.Len(t, errs, 3)
+}
+
+func TestRequiredByType(t *testing.T) {
+	_, errs := load(t, `config = {
+		Type = "slack",
+		Slack = { Mode = "events" },
+	}`)
+	assert.Equal(t, []string{
+		"Nick: required",
+		"DB.File: required",
+		"config.Slack.Token: required for slack",
+		"config.Slack.SigningSecret: required for slack",
+	}, errs)
+}
+
+func TestConnectors(t *testing.T) {
+	_, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Channels = { "work:#general", "#nowhere" },
+		Connectors = {
+			{ Name = "work", Type = "discord", Discord = { Token = "t" } },
+			{ Name = "work", Type = "fax" },
+		},
+	}`)
+	assert.Equal(t, []string{
+		`Connectors[2].Name: "work" is used twice`,
+		`Connectors[2].Type: unknown connection type "fax"`,
+		`Channels[2]: "#nowhere" must start with the name of a connector and a colon`,
+	}, errs)
+}
+
+func TestSlackEventsPaths(t *testing.T) {
+	_, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Connectors = {
+			{ Name = "a", Type = "slack", Slack = { Token = "t", Mode = "events", SigningSecret = "s" } },
+			{ Name = "b", Type = "slack", Slack = { Token = "t", Mode = "events", SigningSecret = "s" } },
+			{ Name = "c", Type = "slack", Slack = { Token = "t", Mode = "events", SigningSecret = "s", EventsPath = "/slack/events/a" } },
+		},
+	}`)
+	assert.Equal(t, []string{
+		"Connectors[3].Slack.EventsPath: /slack/events/a is also used by Connectors[1]",
+	}, errs)
+}
+
+func TestProbabilities(t *testing.T) {
+	_, errs := load(t, `config = {
+		Nick = "cat",
+		DB = { File = "cat.db" },
+		Irc = { Server = "irc" },
+		RatePerSec = 1,
+		Emojify = { Chance = 2 },
+		Reaction = { GeneralChance = 0.5 },
+		Your = { Replacements = { { This = "a", That = "b", Frequency = -1 } } },
+	}`)
+	assert.Len(t, errs, 3)
+	assert.True(t, strings.HasPrefix(errs[0], "Emojify.Chance: 2 is not a probability"))
+	assert.Contains(t, errs[1], "Your.Replacements[1].Frequency")
+	assert.Equal(t, "Reaction.PositiveReactions: required when reactions have a chance", errs[2])
+}
+
+func TestLuaErrors(t *testing.T) {
+	_, errs := load(t, `config = {`)
+	assert.Len(t, errs, 1)
+	_, errs = load(t, `settings = {}`)
+	assert.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "doesn't set config to a table")
+}
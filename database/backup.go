@@ -0,0 +1,288 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// PluginTables says which tables hold each plugin's data, in the order
+// they're exported and imported.
+var PluginTables = []struct {
+	Plugin string
+	Tables []string
+}{
+	{"fact", []string{"factoid", "factoid_alias"}},
+	{"counter", []string{"counter"}},
+	{"babbler", []string{"babblers", "babblerWords", "babblerNodes", "babblerArcs"}},
+	{"reminder", []string{"reminders"}},
+	{"inventory", []string{"inventory"}},
+	{"first", []string{"first"}},
+}
+
+// exportVersion is the version of the export format.
+const exportVersion = 1
+
+// Export is the portable form of the plugins' data that ExportJSON writes
+// and ImportJSON reads.
+type Export struct {
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	// Plugins maps each plugin to its tables, and each table to its rows.
+	Plugins map[string]map[string][]map[string]interface{} `json:"plugins"`
+}
+
+// Backup copies an SQLite database to the file path, which must not exist
+// yet. The copy is consistent even with the bot running, and if it can't be
+// finished there is no file left. PostgreSQL has pg_dump for this, and
+// Backup returns an error for it.
+func Backup(db *sqlx.DB, path string) error {
+	if IsPostgres(db) {
+		return errors.New("use pg_dump to back up a PostgreSQL database, or export it")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	if err := backup(db, path); err != nil {
+		os.Remove(path)
+		os.Remove(path + "-journal")
+		return err
+	}
+	return nil
+}
+
+func backup(db *sqlx.DB, path string) error {
+	dest, err := Open(SQLite, path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", s.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// one step copies everything at once, so no write gets
+			// in between the pages
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// ExportJSON writes the data of the named plugins, or of all of them in
+// PluginTables if there are none, to w. Tables that don't exist yet are
+// left out.
+func ExportJSON(db *sqlx.DB, w io.Writer, plugins ...string) error {
+	e := Export{
+		Version:  exportVersion,
+		Exported: time.Now().UTC(),
+		Plugins:  map[string]map[string][]map[string]interface{}{},
+	}
+	for _, p := range selectPlugins(plugins) {
+		tables, err := pluginTables(p)
+		if err != nil {
+			return err
+		}
+		e.Plugins[p] = map[string][]map[string]interface{}{}
+		for _, t := range tables {
+			if !tableExists(db, t) {
+				continue
+			}
+			rows, err := exportTable(db, t)
+			if err != nil {
+				return fmt.Errorf("exporting %s: %s", t, err)
+			}
+			e.Plugins[p][t] = rows
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// ImportJSON reads an export from r and puts it in the database. Each table
+// in the export replaces the table's rows; other tables are left alone.
+// The tables have to exist, so the bot must have run against the database
+// once before. It returns the number of rows imported.
+func ImportJSON(db *sqlx.DB, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var e Export
+	if err := dec.Decode(&e); err != nil {
+		return 0, err
+	}
+	if e.Version != exportVersion {
+		return 0, fmt.Errorf("can't import version %d exports", e.Version)
+	}
+	for p := range e.Plugins {
+		if _, err := pluginTables(p); err != nil {
+			return 0, err
+		}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range PluginTables {
+		tables, ok := e.Plugins[p.Plugin]
+		if !ok {
+			continue
+		}
+		for _, t := range p.Tables {
+			rows, ok := tables[t]
+			if !ok {
+				continue
+			}
+			if !tableExists(tx, t) {
+				tx.Rollback()
+				return 0, fmt.Errorf("there is no %s table yet; start the bot with this database once first", t)
+			}
+			if err := importTable(tx, t, rows); err != nil {
+				tx.Rollback()
+				return 0, fmt.Errorf("importing %s: %s", t, err)
+			}
+			n += len(rows)
+		}
+	}
+	return n, tx.Commit()
+}
+
+func selectPlugins(plugins []string) []string {
+	if len(plugins) > 0 {
+		return plugins
+	}
+	all := []string{}
+	for _, p := range PluginTables {
+		all = append(all, p.Plugin)
+	}
+	return all
+}
+
+func pluginTables(plugin string) ([]string, error) {
+	for _, p := range PluginTables {
+		if p.Plugin == plugin {
+			return p.Tables, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no data to export", plugin)
+}
+
+// queryer is a *sqlx.DB or *sqlx.Tx.
+type queryer interface {
+	sqlx.Queryer
+	DriverName() string
+}
+
+func tableExists(q queryer, table string) bool {
+	query := `select count(*) from sqlite_master where type = 'table' and lower(name) = lower(?)`
+	if q.DriverName() == Postgres {
+		query = `select count(*) from pg_tables where schemaname = current_schema() and tablename = lower(?)`
+	}
+	var n int
+	return sqlx.Get(q, &n, query, table) == nil && n > 0
+}
+
+func exportTable(db *sqlx.DB, table string) ([]map[string]interface{}, error) {
+	rows, err := db.Queryx("select * from " + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []map[string]interface{}{}
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[k] = string(b)
+			}
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// columnName keeps names from an export file out of the SQL unless they
+// could be columns.
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func importTable(tx *sqlx.Tx, table string, rows []map[string]interface{}) error {
+	if _, err := tx.Exec("delete from " + table); err != nil {
+		return err
+	}
+	hasID := false
+	for _, row := range rows {
+		cols := []string{}
+		for c := range row {
+			cols = append(cols, c)
+		}
+		sort.Strings(cols)
+		args := []interface{}{}
+		for _, c := range cols {
+			if !columnName.MatchString(c) {
+				return fmt.Errorf("%q isn't a column", c)
+			}
+			args = append(args, importValue(row[c]))
+			hasID = hasID || strings.ToLower(c) == "id"
+		}
+		q := fmt.Sprintf("insert into %s (%s) values (%s)", table, strings.Join(cols, ", "),
+			strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	if hasID && tx.DriverName() == Postgres {
+		// the ids were given, so the sequence handing them out hasn't moved
+		_, err := tx.Exec(`select setval(pg_get_serial_sequence(?, 'id'),
+			coalesce((select max(id) from `+table+`), 0) + 1, false)`, strings.ToLower(table))
+		return err
+	}
+	return nil
+}
+
+// importValue turns numbers back into the integers they were exported from.
+func importValue(v interface{}) interface{} {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
@@ -0,0 +1,144 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package database
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTables(t *testing.T, db *sqlx.DB) {
+	for _, ddl := range []string{
+		`create table if not exists counter (id integer primary key, nick string, item string, count integer);`,
+		`create table if not exists factoid_alias (fact string, next string, primary key (fact, next));`,
+		`create table if not exists inventory (item string primary key);`,
+	} {
+		require.NoError(t, CreateTable(db, ddl))
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catbase")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(SQLite, filepath.Join(dir, "catbase.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	makeTables(t, db)
+	_, err = db.Exec(`insert into inventory (item) values (?)`, "a hat")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "backup.db")
+	require.NoError(t, Backup(db, path))
+	assert.Error(t, Backup(db, path), "it won't overwrite")
+
+	copy, err := Open(SQLite, path)
+	require.NoError(t, err)
+	defer copy.Close()
+	var item string
+	require.NoError(t, copy.Get(&item, `select item from inventory`))
+	assert.Equal(t, "a hat", item)
+}
+
+func TestFailedBackupLeavesNothing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catbase")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "catbase.db")
+	db, err := Open(SQLite, file+"?_busy_timeout=10")
+	require.NoError(t, err)
+	defer db.Close()
+	makeTables(t, db)
+
+	// the copy can't read while another connection has the database to
+	// itself
+	writer, err := Open(SQLite, file+"?_txlock=exclusive")
+	require.NoError(t, err)
+	defer writer.Close()
+	tx, err := writer.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	path := filepath.Join(dir, "backup.db")
+	assert.Error(t, Backup(db, path))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "%s is left: %v", path, err)
+}
+
+func TestExportImport(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		makeTables(t, db)
+		_, err := db.Exec(`insert into counter (nick, item, count) values (?, ?, ?)`, "tester", "cheese", 3)
+		require.NoError(t, err)
+		_, err = db.Exec(`insert into factoid_alias (fact, next) values (?, ?)`, "cat", "dog")
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		require.NoError(t, ExportJSON(db, &out))
+		assert.Contains(t, out.String(), `"cheese"`)
+		assert.NotContains(t, out.String(), "babblers", "tables that don't exist are left out")
+
+		_, err = db.Exec(`delete from counter`)
+		require.NoError(t, err)
+		_, err = db.Exec(`insert into inventory (item) values (?)`, "a hat")
+		require.NoError(t, err)
+
+		n, err := ImportJSON(db, &out)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		var count int
+		require.NoError(t, db.Get(&count, `select count from counter where item = ?`, "cheese"))
+		assert.Equal(t, 3, count)
+		var items int
+		require.NoError(t, db.Get(&items, `select count(*) from inventory`))
+		assert.Equal(t, 0, items, "the export's empty inventory replaces it")
+
+		id, err := InsertID(db, `insert into counter (nick, item, count) values (?, ?, ?)`, "tester", "bread", 1)
+		require.NoError(t, err)
+		assert.True(t, id > 1, "new ids come after the imported ones")
+	})
+}
+
+func TestExportSomePlugins(t *testing.T) {
+	db, err := Open(SQLite, ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	makeTables(t, db)
+
+	var out bytes.Buffer
+	require.NoError(t, ExportJSON(db, &out, "inventory"))
+	assert.Contains(t, out.String(), `"inventory"`)
+	assert.NotContains(t, out.String(), `"counter"`)
+
+	assert.Error(t, ExportJSON(db, &out, "zork"))
+}
+
+func TestImportErrors(t *testing.T) {
+	db, err := Open(SQLite, ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	makeTables(t, db)
+
+	for src, msg := range map[string]string{
+		`{"version": 2, "plugins": {}}`:                                         "version 2",
+		`{"version": 1, "plugins": {"zork": {}}}`:                               "zork",
+		`{"version": 1, "plugins": {"first": {"first": []}}}`:                   "no first table",
+		`{"version": 1, "plugins": {"inventory": {"inventory": [{"a b": 1}]}}}`: "isn't a column",
+	} {
+		_, err := ImportJSON(db, strings.NewReader(src))
+		if assert.Error(t, err, src) {
+			assert.Contains(t, err.Error(), msg)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package database opens the bot's database, which is SQLite or PostgreSQL,
+// and papers over the differences between the two that plugins run into.
+//
+// Queries are written for SQLite, with ? placeholders, and mostly run on
+// PostgreSQL as they are. What doesn't has a helper here: CreateTable for
+// column types, Upsert for insert or replace, InsertID for LastInsertId and
+// Like for case-insensitive matching.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/lib/pq"
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// The drivers the bot's database can be opened with.
+const (
+	// SQLite is SQLite with a REGEXP function.
+	SQLite = "sqlite3_custom"
+	// Postgres is PostgreSQL taking ? placeholders.
+	Postgres = "postgres_custom"
+)
+
+func init() {
+	regex := func(re, s string) (bool, error) {
+		return regexp.MatchString(re, s)
+	}
+	sql.Register(SQLite,
+		&sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				return conn.RegisterFunc("REGEXP", regex, true)
+			},
+		})
+	sql.Register(Postgres, postgresDriver{})
+}
+
+// Open opens a database with one of the drivers above. For SQLite source is
+// a file name, and for PostgreSQL a connection string.
+func Open(driverName, source string) (*sqlx.DB, error) {
+	db, err := sqlx.Open(driverName, source)
+	if err != nil {
+		return nil, err
+	}
+	if IsPostgres(db) {
+		// PostgreSQL folds unquoted names like babblerId to lower case
+		db.Mapper = reflectx.NewMapperTagFunc("db", strings.ToLower, strings.ToLower)
+	}
+	return db, nil
+}
+
+// PostgresSource makes a connection string from the DB settings.
+func PostgresSource(server, name, user, password, options string) string {
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+	}
+	parts := []string{}
+	if server != "" {
+		host, port := server, ""
+		if i := strings.LastIndex(server, ":"); i >= 0 && !strings.HasSuffix(server, "]") {
+			host, port = server[:i], server[i+1:]
+		}
+		parts = append(parts, "host="+quote(strings.Trim(host, "[]")))
+		if port != "" {
+			parts = append(parts, "port="+quote(port))
+		}
+	}
+	if name != "" {
+		parts = append(parts, "dbname="+quote(name))
+	}
+	if user != "" {
+		parts = append(parts, "user="+quote(user))
+	}
+	if password != "" {
+		parts = append(parts, "password="+quote(password))
+	}
+	if options != "" {
+		parts = append(parts, options)
+	}
+	return strings.Join(parts, " ")
+}
+
+// IsPostgres reports whether db is PostgreSQL.
+func IsPostgres(db *sqlx.DB) bool {
+	return db.DriverName() == Postgres
+}
+
+var (
+	idColumn      = regexp.MustCompile(`(?i)\binteger primary key\b`)
+	integerColumn = regexp.MustCompile(`(?i)\binte?ger\b`)
+	stringColumn  = regexp.MustCompile(`(?i)\bstring\b`)
+)
+
+// CreateTable runs a create table statement written for SQLite. On
+// PostgreSQL, integer primary keys become bigserial, other integers bigint
+// and string columns text.
+func CreateTable(db *sqlx.DB, ddl string) error {
+	if IsPostgres(db) {
+		ddl = idColumn.ReplaceAllString(ddl, "bigserial primary key")
+		ddl = integerColumn.ReplaceAllString(ddl, "bigint")
+		ddl = stringColumn.ReplaceAllString(ddl, "text")
+	}
+	_, err := db.Exec(ddl)
+	return err
+}
+
+// Upsert makes a statement inserting a row into table, replacing the row
+// with the same keys if there is one. It takes the keys and then the values
+// as arguments.
+func Upsert(db *sqlx.DB, table string, keys []string, values ...string) string {
+	columns := append(append([]string{}, keys...), values...)
+	marks := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	if !IsPostgres(db) {
+		return fmt.Sprintf("insert or replace into %s (%s) values (%s)",
+			table, strings.Join(columns, ", "), marks)
+	}
+	q := fmt.Sprintf("insert into %s (%s) values (%s) on conflict (%s) do ",
+		table, strings.Join(columns, ", "), marks, strings.Join(keys, ", "))
+	if len(values) == 0 {
+		return q + "nothing"
+	}
+	sets := []string{}
+	for _, v := range values {
+		sets = append(sets, v+"=excluded."+v)
+	}
+	return q + "update set " + strings.Join(sets, ", ")
+}
+
+// InsertID runs an insert into a table with an id column and returns the id
+// of the new row.
+func InsertID(db *sqlx.DB, query string, args ...interface{}) (int64, error) {
+	if !IsPostgres(db) {
+		res, err := db.Exec(query, args...)
+		if err != nil {
+			return 0, err
+		}
+		return res.LastInsertId()
+	}
+	var id int64
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";") + " returning id"
+	err := db.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
+
+// Like is the operator matching a pattern without regard to case.
+func Like(db *sqlx.DB) string {
+	if IsPostgres(db) {
+		return "ilike"
+	}
+	return "like"
+}
+
+// postgresDriver is the PostgreSQL driver, with ? placeholders rewritten to
+// the $1 that PostgreSQL expects.
+type postgresDriver struct{}
+
+func (postgresDriver) Open(name string) (driver.Conn, error) {
+	c, err := pq.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return postgresConn{c}, nil
+}
+
+type postgresConn struct {
+	driver.Conn
+}
+
+// rebind numbers the ? placeholders in a query, leaving alone those in
+// quoted strings and identifiers. Doubled quotes inside them are escapes,
+// which toggling in and out of the quote gets right; backslash escapes in
+// E'' strings are not understood.
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (c postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, rebind(query))
+}
+
+func (c postgresConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, rebind(query), args)
+}
+
+func (c postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, rebind(query), args)
+}
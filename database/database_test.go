@@ -0,0 +1,124 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package database
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Set to a PostgreSQL connection string to run the tests there too.
+const testPostgresEnv = "CATBASE_TEST_POSTGRES"
+
+// forEachDB runs f against SQLite and, when it's set up, PostgreSQL.
+func forEachDB(t *testing.T, f func(t *testing.T, db *sqlx.DB)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := Open(SQLite, ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+		f(t, db)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		source := os.Getenv(testPostgresEnv)
+		if source == "" {
+			t.Skip(testPostgresEnv + " is not set")
+		}
+		db, err := Open(Postgres, source)
+		require.NoError(t, err)
+		defer db.Close()
+		schema := fmt.Sprintf("catbase_test_%d", time.Now().UnixNano())
+		_, err = db.Exec("create schema " + schema)
+		require.NoError(t, err)
+		defer db.Exec("drop schema " + schema + " cascade")
+		sdb, err := Open(Postgres, source+" search_path="+schema)
+		require.NoError(t, err)
+		defer sdb.Close()
+		f(t, sdb)
+	})
+}
+
+func TestCreateTableAndInsertID(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		err := CreateTable(db, `create table if not exists things (
+			id integer primary key,
+			name string,
+			count integer
+		);`)
+		require.NoError(t, err)
+		first, err := InsertID(db, `insert into things (name, count) values (?, ?);`, "one", 1)
+		require.NoError(t, err)
+		second, err := InsertID(db, `insert into things (name, count) values (?, ?)`, "two", 2)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+
+		var name string
+		err = db.Get(&name, `select name from things where id = ?`, second)
+		require.NoError(t, err)
+		assert.Equal(t, "two", name)
+	})
+}
+
+func TestUpsert(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		err := CreateTable(db, `create table pairs (
+			k string,
+			v string,
+			primary key (k)
+		);`)
+		require.NoError(t, err)
+		q := Upsert(db, "pairs", []string{"k"}, "v")
+		_, err = db.Exec(q, "a", "1")
+		require.NoError(t, err)
+		_, err = db.Exec(q, "a", "2")
+		require.NoError(t, err)
+
+		var v string
+		err = db.Get(&v, `select v from pairs where k = ?`, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "2", v)
+
+		err = CreateTable(db, `create table keys (k string, primary key (k));`)
+		require.NoError(t, err)
+		q = Upsert(db, "keys", []string{"k"})
+		for i := 0; i < 2; i++ {
+			_, err = db.Exec(q, "a")
+			require.NoError(t, err)
+		}
+		var n int
+		err = db.Get(&n, `select count(*) from keys`)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestLike(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		err := CreateTable(db, `create table words (word string);`)
+		require.NoError(t, err)
+		_, err = db.Exec(`insert into words (word) values (?)`, "Hello")
+		require.NoError(t, err)
+		var n int
+		err = db.Get(&n, `select count(*) from words where word `+Like(db)+` ?`, "%hell%")
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestRebind(t *testing.T) {
+	assert.Equal(t, "select * from t where a = $1 and b = $2", rebind("select * from t where a = ? and b = ?"))
+	assert.Equal(t, "select 'what?', \"who?\" from t where a = $1", rebind("select 'what?', \"who?\" from t where a = ?"))
+	assert.Equal(t, "select 'it''s ?' where a = $1", rebind("select 'it''s ?' where a = ?"))
+}
+
+func TestPostgresSource(t *testing.T) {
+	assert.Equal(t, "host='db.example.com' port='5433' dbname='catbase' user='cat' password='it\\'s' sslmode=disable",
+		PostgresSource("db.example.com:5433", "catbase", "cat", "it's", "sslmode=disable"))
+	assert.Equal(t, "host='::1' dbname='catbase'", PostgresSource("[::1]", "catbase", "", "", ""))
+	assert.Equal(t, "", PostgresSource("", "", "", "", ""))
+}
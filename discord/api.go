@@ -0,0 +1,131 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultAPIURL is where the Discord REST API lives unless the config
+	// says otherwise.
+	defaultAPIURL = "https://discord.com/api/v10/"
+
+	// requestTimeout bounds every REST request, including reading the body.
+	requestTimeout = 30 * time.Second
+
+	// maxRetries is how many times a rate limited request is retried before
+	// we give up and return a RateLimitError.
+	maxRetries = 3
+)
+
+// APIError is an error response from the REST API.
+type APIError struct {
+	Method, Path string `json:"-"`
+	StatusCode   int    `json:"-"`
+	// Code is Discord's JSON error code, e.g. 10008 for an unknown message
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("discord %s %s: %d %s (%d)", e.Method, e.Path, e.StatusCode, e.Message, e.Code)
+}
+
+// RateLimitError is returned once a request has been rate limited more times
+// than we're willing to wait for.
+type RateLimitError struct {
+	Method, Path string
+	RetryAfter   time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("discord %s %s: rate limited, retry after %s", e.Method, e.Path, e.RetryAfter)
+}
+
+// api makes a REST request with a JSON body, which may be nil, and decodes the
+// response into out, which may also be nil.
+func (d *Discord) api(method, path string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return d.request(method, path, "application/json", data, out)
+}
+
+// request makes a REST request, retrying when Discord rate limits us.
+func (d *Discord) request(method, path, contentType string, body []byte, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := d.do(method, path, contentType, body, out)
+		if retryAfter == 0 {
+			if err != nil {
+				log.Printf("discord: %s %s failed: %s", method, path, err)
+			}
+			return err
+		}
+		if attempt >= maxRetries {
+			err := &RateLimitError{Method: method, Path: path, RetryAfter: retryAfter}
+			log.Printf("discord: %s %s failed: %s", method, path, err)
+			return err
+		}
+		log.Printf("discord: %s %s rate limited, retrying in %s", method, path, retryAfter)
+		d.sleep(retryAfter)
+	}
+}
+
+// do makes a single request. A non-zero duration means we were rate limited
+// and should try again after it.
+func (d *Discord) do(method, path, contentType string, body []byte, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequest(method, d.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bot "+d.config.Discord.Token)
+	req.Header.Set("User-Agent", "DiscordBot (https://github.com/velour/catbase, 1)")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("reading body: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		json.Unmarshal(respBody, &limit)
+		wait := time.Duration(limit.RetryAfter * float64(time.Second))
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, nil
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		json.Unmarshal(respBody, apiErr)
+		return 0, apiErr
+	}
+	if out == nil || len(respBody) == 0 {
+		return 0, nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return 0, fmt.Errorf("decoding response: %s", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,497 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package discord connects to Discord
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+)
+
+type Discord struct {
+	config *config.Config
+
+	// url is the base of the REST API, ending in a slash
+	url  string
+	http *http.Client
+	// sleep is time.Sleep, replaced in tests
+	sleep func(time.Duration)
+
+	// mu guards the session state, which is kept across reconnections so
+	// that we can resume instead of identifying again
+	mu        sync.Mutex
+	id        string
+	sessionID string
+	resumeURL string
+	seq       int64
+
+	emojiMu sync.Mutex
+	// emoji maps the guild's custom emoji names to their IDs
+	emoji map[string]string
+
+	eventReceived   func(msg.Message)
+	messageReceived func(msg.Message)
+}
+
+// discordUser is a user as the API describes them.
+type discordUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Bot      bool   `json:"bot"`
+}
+
+// discordMessage is a message as the API describes it.
+type discordMessage struct {
+	ID        string        `json:"id"`
+	ChannelID string        `json:"channel_id"`
+	GuildID   string        `json:"guild_id"`
+	Author    *discordUser  `json:"author"`
+	Content   string        `json:"content"`
+	Timestamp string        `json:"timestamp"`
+	Mentions  []discordUser `json:"mentions"`
+}
+
+type discordEmoji struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// reactionEvent is the payload of MESSAGE_REACTION_ADD and _REMOVE.
+type reactionEvent struct {
+	UserID    string       `json:"user_id"`
+	ChannelID string       `json:"channel_id"`
+	MessageID string       `json:"message_id"`
+	Emoji     discordEmoji `json:"emoji"`
+	Member    *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+}
+
+func New(c *config.Config) *Discord {
+	apiURL := c.Discord.APIURL
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+	return &Discord{
+		config: c,
+		url:    apiURL,
+		http:   &http.Client{Timeout: requestTimeout},
+		sleep:  time.Sleep,
+		emoji:  make(map[string]string),
+	}
+}
+
+func (d *Discord) RegisterEventReceived(f func(msg.Message)) {
+	d.eventReceived = f
+}
+
+func (d *Discord) RegisterMessageReceived(f func(msg.Message)) {
+	d.messageReceived = f
+}
+
+// createMessage posts a message and returns its ID.
+func (d *Discord) createMessage(channel string, body map[string]interface{}) (string, error) {
+	var m discordMessage
+	if err := d.api("POST", "channels/"+channel+"/messages", body, &m); err != nil {
+		return "", err
+	}
+	return m.ID, nil
+}
+
+// SendMessage sends a message, split over as many as it takes to fit, and
+// returns the ID of the first.
+func (d *Discord) SendMessage(channel, message string) string {
+	log.Printf("Sending message to %s: %s", channel, message)
+	first := ""
+	for _, content := range splitContent(d.render(message)) {
+		id, err := d.createMessage(channel, map[string]interface{}{
+			"content": content,
+		})
+		if err != nil {
+			log.Printf("Error sending Discord message: %s", err)
+			break
+		}
+		if first == "" {
+			first = id
+		}
+	}
+	return first
+}
+
+// maxContent is the most characters Discord allows in a message.
+const maxContent = 2000
+
+// splitContent splits message content into pieces Discord will take,
+// breaking at the last line ending that fits where there is one.
+func splitContent(content string) []string {
+	var pieces []string
+	for utf8.RuneCountInString(content) > maxContent {
+		end := 0
+		for i := 0; i < maxContent; i++ {
+			_, n := utf8.DecodeRuneInString(content[end:])
+			end += n
+		}
+		if nl := strings.LastIndex(content[:end], "\n"); nl > 0 {
+			pieces = append(pieces, content[:nl])
+			content = content[nl+1:]
+		} else {
+			pieces = append(pieces, content[:end])
+			content = content[end:]
+		}
+	}
+	return append(pieces, content)
+}
+
+// SendAction sends an action in italics, which is how Discord shows /me.
+func (d *Discord) SendAction(channel, message string) string {
+	log.Printf("Sending action to %s: %s", channel, message)
+	return d.SendMessage(channel, msg.Italic(message))
+}
+
+// SendRich sends a structured message as text, uploading any attachments
+// that have data as files on the same message.
+func (d *Discord) SendRich(channel string, message msg.Rich) string {
+	var files []msg.Attachment
+	text := message
+	text.Attachments = nil
+	for _, a := range message.Attachments {
+		if len(a.Data) > 0 && a.Kind != msg.AttachSnippet {
+			files = append(files, a)
+		} else {
+			text.Attachments = append(text.Attachments, a)
+		}
+	}
+	if len(files) == 0 {
+		return d.SendMessage(channel, text.Fallback())
+	}
+
+	log.Printf("Sending rich message to %s: %s", channel, message.Fallback())
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	payloadJSON, _ := json.Marshal(map[string]interface{}{
+		"content": d.render(text.Fallback()),
+	})
+	w.WriteField("payload_json", string(payloadJSON))
+	for i, a := range files {
+		fw, err := w.CreateFormFile(fmt.Sprintf("files[%d]", i), a.Name)
+		if err != nil {
+			log.Printf("Error attaching %s: %s", a.Name, err)
+			return ""
+		}
+		fw.Write(a.Data)
+	}
+	w.Close()
+
+	var m discordMessage
+	err := d.request("POST", "channels/"+channel+"/messages", w.FormDataContentType(), body.Bytes(), &m)
+	if err != nil {
+		log.Printf("Error sending Discord message: %s", err)
+		return ""
+	}
+	return m.ID
+}
+
+// ReplyToMessageIdentifier replies to a message. Replies too long for one
+// message go on in plain messages after it.
+func (d *Discord) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
+	pieces := splitContent(d.render(message))
+	id, err := d.createMessage(channel, map[string]interface{}{
+		"content":           pieces[0],
+		"message_reference": map[string]string{"message_id": identifier},
+	})
+	if err != nil {
+		log.Printf("Error sending Discord reply: %s", err)
+		return "", false
+	}
+	for _, content := range pieces[1:] {
+		if _, err := d.createMessage(channel, map[string]interface{}{"content": content}); err != nil {
+			log.Printf("Error sending Discord reply: %s", err)
+			break
+		}
+	}
+	return id, true
+}
+
+func (d *Discord) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
+	return d.ReplyToMessageIdentifier(channel, message, replyTo.ID)
+}
+
+func (d *Discord) React(channel, reaction string, message msg.Message) bool {
+	log.Printf("Reacting in %s: %s", channel, reaction)
+	path := fmt.Sprintf("channels/%s/messages/%s/reactions/%s/@me",
+		channel, message.ID, url.PathEscape(d.reactionEmoji(reaction)))
+	if err := d.api("PUT", path, nil, nil); err != nil {
+		log.Printf("reaction failed: %s", err)
+		return false
+	}
+	return true
+}
+
+// reactionEmoji turns a shortcode into the form the reactions endpoint wants:
+// name:id for custom emoji, the character itself for the rest.
+func (d *Discord) reactionEmoji(name string) string {
+	name = strings.Trim(name, ":")
+	if id, ok := d.emojiID(name); ok {
+		return name + ":" + id
+	}
+	if e, ok := msg.EmojiCharacter(name); ok {
+		return e
+	}
+	return name
+}
+
+func (d *Discord) Edit(channel, newMessage, identifier string) bool {
+	log.Printf("Editing in (%s) %s: %s", identifier, channel, newMessage)
+	err := d.api("PATCH", "channels/"+channel+"/messages/"+identifier,
+		map[string]string{"content": d.render(newMessage)}, nil)
+	if err != nil {
+		log.Printf("edit failed: %s", err)
+		return false
+	}
+	return true
+}
+
+func (d *Discord) GetEmojiList() map[string]string {
+	d.emojiMu.Lock()
+	defer d.emojiMu.Unlock()
+	list := make(map[string]string, len(d.emoji))
+	for name, id := range d.emoji {
+		list[name] = "https://cdn.discordapp.com/emojis/" + id + ".png"
+	}
+	return list
+}
+
+func (d *Discord) emojiID(name string) (string, bool) {
+	d.emojiMu.Lock()
+	defer d.emojiMu.Unlock()
+	id, ok := d.emoji[name]
+	return id, ok
+}
+
+func (d *Discord) populateEmojiList() {
+	if d.config.Discord.GuildID == "" {
+		return
+	}
+	var list []discordEmoji
+	if err := d.api("GET", "guilds/"+d.config.Discord.GuildID+"/emojis", nil, &list); err != nil {
+		log.Printf("Error retrieving emoji list from Discord: %s", err)
+		return
+	}
+	d.emojiMu.Lock()
+	defer d.emojiMu.Unlock()
+	for _, e := range list {
+		d.emoji[e.Name] = e.ID
+	}
+}
+
+// Who gets the usernames of the guild's members. Discord has no per-channel
+// member list, so the channel is ignored.
+func (d *Discord) Who(channel string) []string {
+	log.Println("Who is queried for ", channel)
+	handles := []string{}
+	after := "0"
+	for {
+		var members []struct {
+			User discordUser `json:"user"`
+		}
+		path := fmt.Sprintf("guilds/%s/members?limit=1000&after=%s", d.config.Discord.GuildID, after)
+		if err := d.api("GET", path, nil, &members); err != nil {
+			log.Printf("Error getting guild members: %s", err)
+			return handles
+		}
+		for _, m := range members {
+			handles = append(handles, m.User.Username)
+		}
+		if len(members) < 1000 {
+			break
+		}
+		after = members[len(members)-1].User.ID
+	}
+	log.Printf("Returning %d handles", len(handles))
+	return handles
+}
+
+// OpenDM opens a direct message channel with a user. Discord needs the
+// user's ID for this.
+func (d *Discord) OpenDM(u user.User) (string, error) {
+	if u.ID == "" {
+		return "", fmt.Errorf("no Discord user ID for %s", u.Name)
+	}
+	var ch struct {
+		ID string `json:"id"`
+	}
+	if err := d.api("POST", "users/@me/channels", map[string]string{"recipient_id": u.ID}, &ch); err != nil {
+		return "", err
+	}
+	return ch.ID, nil
+}
+
+// Serve connects to Discord and dispatches events until the connection can't
+// be recovered.
+func (d *Discord) Serve() error {
+	if d.eventReceived == nil || d.messageReceived == nil {
+		return fmt.Errorf("Missing an event handler")
+	}
+	d.populateEmojiList()
+	return d.serveGateway()
+}
+
+// handleEvent dispatches a gateway event.
+func (d *Discord) handleEvent(kind string, data json.RawMessage) {
+	switch kind {
+	case "READY":
+		var ready struct {
+			SessionID        string      `json:"session_id"`
+			ResumeGatewayURL string      `json:"resume_gateway_url"`
+			User             discordUser `json:"user"`
+		}
+		if err := json.Unmarshal(data, &ready); err != nil {
+			log.Printf("Error decoding Discord READY: %s", err)
+			return
+		}
+		d.mu.Lock()
+		d.id = ready.User.ID
+		d.sessionID = ready.SessionID
+		d.resumeURL = ready.ResumeGatewayURL
+		d.mu.Unlock()
+		log.Println("Connected to Discord")
+
+	case "RESUMED":
+		log.Println("Resumed Discord session")
+
+	case "MESSAGE_CREATE":
+		var m discordMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("Error decoding Discord message: %s", err)
+			return
+		}
+		if !d.shouldHandle(m) {
+			return
+		}
+		d.messageReceived(d.buildMessage(m))
+
+	case "MESSAGE_UPDATE":
+		var m discordMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("Error decoding Discord message: %s", err)
+			return
+		}
+		// Updates without an author are embeds being filled in.
+		if m.Author == nil || !d.shouldHandle(m) {
+			return
+		}
+		ev := d.buildMessage(m)
+		ev.Kind = msg.MessageEdited
+		ev.Target = m.ID
+		d.eventReceived(ev)
+
+	case "MESSAGE_DELETE":
+		var m discordMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("Error decoding Discord message: %s", err)
+			return
+		}
+		d.eventReceived(msg.Message{
+			User:    &user.User{},
+			Channel: m.ChannelID,
+			Time:    time.Now(),
+			Kind:    msg.MessageDeleted,
+			Target:  m.ID,
+		})
+
+	case "MESSAGE_REACTION_ADD", "MESSAGE_REACTION_REMOVE":
+		var r reactionEvent
+		if err := json.Unmarshal(data, &r); err != nil {
+			log.Printf("Error decoding Discord reaction: %s", err)
+			return
+		}
+		ev := msg.Message{
+			User:     &user.User{ID: r.UserID},
+			Channel:  r.ChannelID,
+			Time:     time.Now(),
+			Kind:     msg.ReactionAdded,
+			Target:   r.MessageID,
+			Reaction: r.Emoji.Name,
+		}
+		if kind == "MESSAGE_REACTION_REMOVE" {
+			ev.Kind = msg.ReactionRemoved
+		}
+		if r.Member != nil {
+			ev.User.Name = r.Member.User.Username
+		}
+		d.eventReceived(ev)
+
+	default:
+		log.Printf("Unhandled Discord event type: '%s'", kind)
+	}
+}
+
+// shouldHandle filters out our own messages and those of bots we don't know.
+func (d *Discord) shouldHandle(m discordMessage) bool {
+	if m.Author == nil {
+		return false
+	}
+	d.mu.Lock()
+	me := d.id
+	d.mu.Unlock()
+	if m.Author.ID == me {
+		return false
+	}
+	if m.Author.Bot {
+		return d.config.BotList[strings.Title(m.Author.Username)]
+	}
+	return true
+}
+
+// Convert a discordMessage to a msg.Message
+func (d *Discord) buildMessage(m discordMessage) msg.Message {
+	text := fixText(m.Content, m.Mentions)
+	isCmd, text := bot.IsCmd(d.config.ForChannel(m.ChannelID), text)
+
+	// Discord sends /me as a message wrapped in underscores.
+	isAction := false
+	if len(text) > 2 && strings.HasPrefix(text, "_") && strings.HasSuffix(text, "_") &&
+		!strings.Contains(text[1:len(text)-1], "_") {
+		isAction = true
+		text = text[1 : len(text)-1]
+	}
+
+	tstamp, err := time.Parse(time.RFC3339, m.Timestamp)
+	if err != nil {
+		tstamp = time.Now()
+	}
+
+	u := &user.User{}
+	if m.Author != nil {
+		u.ID = m.Author.ID
+		u.Name = m.Author.Username
+	}
+
+	return msg.Message{
+		User:    u,
+		Body:    text,
+		Raw:     m.Content,
+		Channel: m.ChannelID,
+		Command: isCmd,
+		Action:  isAction,
+		Time:    tstamp,
+		ID:      m.ID,
+	}
+}
@@ -0,0 +1,398 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package discord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/config"
+	"golang.org/x/net/websocket"
+)
+
+// fakeDiscord is just enough of the REST API and gateway to run the connector
+// against.
+type fakeDiscord struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+	// limited is how many more requests to answer with a 429
+	limited int
+	// identified receives the op of each identify or resume we get
+	identified chan int
+	// gateway is run on each gateway connection after the handshake
+	gateway func(ws *websocket.Conn)
+	// heartbeat is the interval in milliseconds we ask for heartbeats at
+	heartbeat int
+}
+
+func newFakeDiscord() *fakeDiscord {
+	f := &fakeDiscord{
+		bodies:     map[string]string{},
+		identified: make(chan int, 10),
+		heartbeat:  60000,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", f.serveAPI)
+	mux.Handle("/gateway/", websocket.Handler(f.serveGateway))
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeDiscord) serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/")
+	call := r.Method + " " + path
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, call)
+	f.bodies[call] = string(body)
+	limited := f.limited > 0
+	if limited {
+		f.limited--
+	}
+	f.mu.Unlock()
+
+	if limited {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"message":"You are being rate limited.","retry_after":0.25}`)
+		return
+	}
+
+	var resp interface{} = map[string]string{}
+	switch {
+	case path == "gateway/bot":
+		resp = map[string]string{"url": "ws" + strings.TrimPrefix(f.URL, "http") + "/gateway"}
+	case path == "guilds/G1/emojis":
+		resp = []discordEmoji{{ID: "42", Name: "catbase"}}
+	case path == "guilds/G1/members":
+		var members []map[string]discordUser
+		n := 1000
+		if r.URL.Query().Get("after") != "0" {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			members = append(members, map[string]discordUser{"user": {ID: fmt.Sprint(i + 1), Username: "u"}})
+		}
+		resp = members
+	case path == "channels/C1/messages":
+		resp = discordMessage{ID: "M9"}
+	case path == "channels/C2/messages":
+		w.WriteHeader(http.StatusNotFound)
+		resp = map[string]interface{}{"code": 10003, "message": "Unknown Channel"}
+	case path == "users/@me/channels":
+		resp = map[string]string{"id": "DM1"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeDiscord) serveGateway(ws *websocket.Conn) {
+	hello, _ := json.Marshal(map[string]int{"heartbeat_interval": f.heartbeat})
+	websocket.JSON.Send(ws, payload{Op: opHello, Data: hello})
+	var p payload
+	if err := websocket.JSON.Receive(ws, &p); err != nil {
+		return
+	}
+	f.identified <- p.Op
+	f.gateway(ws)
+}
+
+func dispatch(ws *websocket.Conn, seq int64, kind string, data interface{}) {
+	raw, _ := json.Marshal(data)
+	websocket.JSON.Send(ws, payload{Op: opDispatch, Type: kind, Sequence: &seq, Data: raw})
+}
+
+func newTestDiscord(f *fakeDiscord) *Discord {
+	c := &config.Config{}
+	c.Nick = "catbase"
+	c.Discord.Token = "token"
+	c.Discord.GuildID = "G1"
+	c.Discord.APIURL = f.URL + "/api"
+	d := New(c)
+	d.sleep = func(time.Duration) {}
+	return d
+}
+
+func TestGatewayDispatchesAndResumes(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+
+	connections := 0
+	f.gateway = func(ws *websocket.Conn) {
+		f.mu.Lock()
+		connections++
+		n := connections
+		f.mu.Unlock()
+		if n == 1 {
+			dispatch(ws, 1, "READY", map[string]interface{}{
+				"session_id":         "S1",
+				"resume_gateway_url": "ws" + strings.TrimPrefix(f.URL, "http") + "/gateway",
+				"user":               discordUser{ID: "BOT", Username: "catbase"},
+			})
+			dispatch(ws, 2, "MESSAGE_CREATE", discordMessage{
+				ID: "M1", ChannelID: "C1", Content: "ignore me",
+				Author: &discordUser{ID: "BOT", Username: "catbase"},
+			})
+			dispatch(ws, 3, "MESSAGE_CREATE", discordMessage{
+				ID: "M2", ChannelID: "C1", Content: "catbase: hi <@7> <:catbase:42>",
+				Author:   &discordUser{ID: "5", Username: "alice"},
+				Mentions: []discordUser{{ID: "7", Username: "bob"}},
+			})
+			websocket.JSON.Send(ws, payload{Op: opReconnect})
+			return
+		}
+		dispatch(ws, 4, "MESSAGE_REACTION_ADD", reactionEvent{
+			UserID: "5", ChannelID: "C1", MessageID: "M2",
+			Emoji: discordEmoji{Name: "👍"},
+		})
+		time.Sleep(time.Minute)
+	}
+
+	d := newTestDiscord(f)
+	messages := make(chan msg.Message, 10)
+	events := make(chan msg.Message, 10)
+	d.RegisterMessageReceived(func(m msg.Message) { messages <- m })
+	d.RegisterEventReceived(func(m msg.Message) { events <- m })
+	go d.Serve()
+
+	select {
+	case m := <-messages:
+		assert.Equal(t, "M2", m.ID)
+		assert.Equal(t, "alice", m.User.Name)
+		assert.True(t, m.Command)
+		assert.Equal(t, "hi @bob :catbase:", m.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+	}
+
+	select {
+	case ev := <-events:
+		assert.Equal(t, msg.ReactionAdded, ev.Kind)
+		assert.Equal(t, "M2", ev.Target)
+		assert.Equal(t, "👍", ev.Reaction)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reaction")
+	}
+
+	assert.Equal(t, opIdentify, <-f.identified)
+	assert.Equal(t, opResume, <-f.identified)
+	assert.Equal(t, map[string]string{"catbase": "https://cdn.discordapp.com/emojis/42.png"}, d.GetEmojiList())
+}
+
+// closeWith closes the gateway with a code the way Discord does.
+func closeWith(ws *websocket.Conn, code int) {
+	ws.PayloadType = websocket.CloseFrame
+	ws.Write([]byte{byte(code >> 8), byte(code)})
+}
+
+func TestGatewayStopsOnFatalClose(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+
+	connections := 0
+	f.gateway = func(ws *websocket.Conn) {
+		f.mu.Lock()
+		connections++
+		n := connections
+		f.mu.Unlock()
+		if n < 3 {
+			closeWith(ws, 4000)
+			return
+		}
+		closeWith(ws, 4004)
+	}
+
+	d := newTestDiscord(f)
+	var slept []time.Duration
+	d.sleep = func(wait time.Duration) { slept = append(slept, wait) }
+	d.RegisterMessageReceived(func(msg.Message) {})
+	d.RegisterEventReceived(func(msg.Message) {})
+
+	done := make(chan error)
+	go func() { done <- d.Serve() }()
+	select {
+	case err := <-done:
+		assert.True(t, bot.IsPermanent(err), "expected a permanent error, got %v", err)
+		assert.Contains(t, err.Error(), "4004")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve kept going after a fatal close")
+	}
+	assert.Equal(t, []time.Duration{initialBackoff, 2 * initialBackoff}, slept)
+}
+
+func TestCloseReaderFollowsFrames(t *testing.T) {
+	stream := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n" +
+		"\x81\x05hello" +
+		"\x81\x7e\x01\x00" + strings.Repeat("x", 256) +
+		"\x88\x02\x0f\xae"
+	c := &closeReader{}
+	for i := 0; i < len(stream); i += 7 {
+		end := i + 7
+		if end > len(stream) {
+			end = len(stream)
+		}
+		assert.Equal(t, 0, c.closeCode())
+		c.follow([]byte(stream[i:end]))
+	}
+	assert.Equal(t, 4014, c.closeCode())
+}
+
+func TestSendReactEdit(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	d := newTestDiscord(f)
+	d.populateEmojiList()
+
+	assert.Equal(t, "M9", d.SendMessage("C1", msg.Bold("hi")+" "+msg.Emoji("catbase")))
+	assert.JSONEq(t, `{"content":"**hi** <:catbase:42>"}`, f.bodies["POST channels/C1/messages"])
+
+	id, ok := d.ReplyToMessage("C1", "yes", msg.Message{ID: "M1"})
+	assert.True(t, ok)
+	assert.Equal(t, "M9", id)
+	assert.Contains(t, f.bodies["POST channels/C1/messages"], `"message_reference":{"message_id":"M1"}`)
+
+	assert.True(t, d.React("C1", "+1", msg.Message{ID: "M1"}))
+	assert.True(t, d.React("C1", "catbase", msg.Message{ID: "M1"}))
+	assert.True(t, d.Edit("C1", "fixed", "M1"))
+
+	assert.Equal(t, []string{
+		"GET guilds/G1/emojis",
+		"POST channels/C1/messages",
+		"POST channels/C1/messages",
+		"PUT channels/C1/messages/M1/reactions/👍/@me",
+		"PUT channels/C1/messages/M1/reactions/catbase:42/@me",
+		"PATCH channels/C1/messages/M1",
+	}, f.requests)
+}
+
+func TestSplitContent(t *testing.T) {
+	assert.Equal(t, []string{"short"}, splitContent("short"))
+
+	line := strings.Repeat("a", 1500)
+	assert.Equal(t, []string{line, line}, splitContent(line+"\n"+line))
+
+	long := strings.Repeat("é", maxContent+10)
+	pieces := splitContent(long)
+	if assert.Len(t, pieces, 2) {
+		assert.Equal(t, strings.Repeat("é", maxContent), pieces[0])
+		assert.Equal(t, strings.Repeat("é", 10), pieces[1])
+	}
+}
+
+func TestSendLongMessage(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	d := newTestDiscord(f)
+
+	line := strings.Repeat("a", 1500)
+	assert.Equal(t, "M9", d.SendMessage("C1", line+"\n"+line+"\nend"))
+	assert.Equal(t, []string{"POST channels/C1/messages", "POST channels/C1/messages"}, f.requests)
+	assert.JSONEq(t, `{"content":"`+line+`\nend"}`, f.bodies["POST channels/C1/messages"])
+
+	f.requests = nil
+	_, ok := d.ReplyToMessage("C1", line+"\n"+line, msg.Message{ID: "M1"})
+	assert.True(t, ok)
+	assert.Len(t, f.requests, 2)
+	assert.NotContains(t, f.bodies["POST channels/C1/messages"], "message_reference")
+}
+
+func TestGatewayReconnectsWithoutHeartbeatAck(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	f.heartbeat = 50
+
+	connections := 0
+	f.gateway = func(ws *websocket.Conn) {
+		f.mu.Lock()
+		connections++
+		n := connections
+		f.mu.Unlock()
+		if n == 1 {
+			dispatch(ws, 1, "READY", map[string]interface{}{
+				"session_id":         "S1",
+				"resume_gateway_url": "ws" + strings.TrimPrefix(f.URL, "http") + "/gateway",
+				"user":               discordUser{ID: "BOT", Username: "catbase"},
+			})
+			// Swallow heartbeats without acknowledging them.
+			var p payload
+			for websocket.JSON.Receive(ws, &p) == nil {
+			}
+			return
+		}
+		var p payload
+		for websocket.JSON.Receive(ws, &p) == nil {
+			if p.Op == opHeartbeat {
+				websocket.JSON.Send(ws, payload{Op: opHeartbeatAck})
+			}
+		}
+	}
+
+	d := newTestDiscord(f)
+	d.RegisterMessageReceived(func(msg.Message) {})
+	d.RegisterEventReceived(func(msg.Message) {})
+	go d.Serve()
+
+	for _, op := range []int{opIdentify, opResume} {
+		select {
+		case got := <-f.identified:
+			assert.Equal(t, op, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the gateway")
+		}
+	}
+	select {
+	case got := <-f.identified:
+		t.Fatalf("reconnected again with op %d while heartbeats were acknowledged", got)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	d := newTestDiscord(f)
+	var slept []time.Duration
+	d.sleep = func(wait time.Duration) { slept = append(slept, wait) }
+
+	f.limited = 1
+	assert.Equal(t, "M9", d.SendMessage("C1", "hi"))
+	assert.Equal(t, []time.Duration{250 * time.Millisecond}, slept)
+
+	f.limited = maxRetries + 1
+	err := d.api("GET", "gateway/bot", nil, nil)
+	_, ok := err.(*RateLimitError)
+	assert.True(t, ok, "expected a RateLimitError, got %v", err)
+}
+
+func TestAPIError(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	d := newTestDiscord(f)
+
+	_, err := d.createMessage("C2", map[string]interface{}{"content": "hi"})
+	if apiErr, ok := err.(*APIError); assert.True(t, ok) {
+		assert.Equal(t, 10003, apiErr.Code)
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	}
+}
+
+func TestWhoPaginates(t *testing.T) {
+	f := newFakeDiscord()
+	defer f.Close()
+	d := newTestDiscord(f)
+
+	assert.Len(t, d.Who("C1"), 1002)
+	assert.Equal(t, "GET guilds/G1/members", f.requests[1])
+}
@@ -0,0 +1,69 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package discord
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// render converts marked up text to Discord's markdown.
+func (d *Discord) render(text string) string {
+	return msg.Render(text, d.renderSpan)
+}
+
+func (d *Discord) renderSpan(s msg.Span) string {
+	switch s.Style {
+	case msg.StyleBold:
+		return "**" + s.Text + "**"
+	case msg.StyleItalic:
+		return "_" + s.Text + "_"
+	case msg.StyleCode:
+		return "`" + s.Text + "`"
+	case msg.StyleCodeBlock:
+		return "```\n" + s.Text + "\n```"
+	case msg.StyleEmoji:
+		// Bots can't use shortcodes, only custom emoji by ID or the
+		// characters themselves.
+		if id, ok := d.emojiID(s.Text); ok {
+			return fmt.Sprintf("<:%s:%s>", s.Text, id)
+		}
+		if e, ok := msg.EmojiCharacter(s.Text); ok {
+			return e
+		}
+		return ":" + s.Text + ":"
+	case msg.StyleLink:
+		if s.Text == "" || s.Text == s.Target {
+			return s.Target
+		}
+		return fmt.Sprintf("[%s](%s)", s.Text, s.Target)
+	case msg.StyleMention:
+		if s.Target != "" {
+			return "<@" + s.Target + ">"
+		}
+		return "@" + s.Text
+	}
+	return s.Text
+}
+
+var (
+	mentionRE = regexp.MustCompile(`<@!?(\d+)>`)
+	emojiRE   = regexp.MustCompile(`<a?:(\w+):\d+>`)
+)
+
+// fixText replaces user mentions like <@1234> with @ followed by the user's
+// name and custom emoji like <:catbase:1234> with their :shortcode:.
+func fixText(text string, mentions []discordUser) string {
+	text = mentionRE.ReplaceAllStringFunc(text, func(m string) string {
+		id := mentionRE.FindStringSubmatch(m)[1]
+		for _, u := range mentions {
+			if u.ID == id {
+				return "@" + u.Username
+			}
+		}
+		return m
+	})
+	return emojiRE.ReplaceAllString(text, ":$1:")
+}
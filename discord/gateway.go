@@ -0,0 +1,430 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package discord
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"golang.org/x/net/websocket"
+)
+
+// Gateway opcodes, see https://discord.com/developers/docs/topics/opcodes-and-status-codes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatAck   = 11
+)
+
+// intents are the gateway events we subscribe to: guild messages, guild
+// message reactions and direct messages. Message content is privileged and
+// has to be enabled for the bot in the developer portal, but without it
+// Discord leaves the text out of messages that don't mention us.
+const intents = 1<<9 | 1<<10 | 1<<12 | 1<<15
+
+// fatalCloses are the codes Discord closes the gateway with when connecting
+// again won't help.
+var fatalCloses = map[int]string{
+	4004: "authentication failed",
+	4010: "invalid shard",
+	4011: "sharding required",
+	4012: "invalid API version",
+	4013: "invalid intents",
+	4014: "disallowed intents, enable message content for the bot in the developer portal",
+}
+
+const (
+	// initialBackoff is how long to wait before the first reconnection
+	// attempt. Each consecutive failure doubles it up to maxBackoff.
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// payload is a frame sent or received over the gateway.
+type payload struct {
+	Op       int             `json:"op"`
+	Data     json.RawMessage `json:"d,omitempty"`
+	Sequence *int64          `json:"s,omitempty"`
+	Type     string          `json:"t,omitempty"`
+}
+
+// gateway is a single gateway connection. Heartbeats and dispatch both write
+// to it, so sends are serialized.
+type gateway struct {
+	ws     *websocket.Conn
+	frames *closeReader
+
+	mu sync.Mutex
+	// acked is whether Discord has acknowledged our last heartbeat
+	acked bool
+}
+
+func (g *gateway) send(op int, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return websocket.JSON.Send(g.ws, payload{Op: op, Data: raw})
+}
+
+// serveGateway keeps a gateway connection up, resuming the session whenever
+// we're disconnected. Connections that fail before the session is ready are
+// retried with a growing delay. It only returns if Discord won't let us
+// connect at all.
+func (d *Discord) serveGateway() error {
+	backoff := initialBackoff
+	for {
+		ready, err := d.connectGateway()
+		if bot.IsPermanent(err) {
+			return err
+		}
+		if ready {
+			backoff = initialBackoff
+			if err != nil {
+				log.Printf("Discord gateway closed: %s", err)
+			}
+			continue
+		}
+		log.Printf("Discord gateway connection failed, retrying in %s: %s", backoff, err)
+		d.sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connectGateway runs one gateway connection and reports whether the session
+// got as far as being ready.
+func (d *Discord) connectGateway() (bool, error) {
+	g, err := d.openGateway()
+	if err != nil {
+		if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == 401 {
+			return false, bot.Permanent(err)
+		}
+		return false, err
+	}
+	defer g.ws.Close()
+	return d.readGateway(g)
+}
+
+// openGateway dials the gateway and either resumes our last session or
+// identifies a new one.
+func (d *Discord) openGateway() (*gateway, error) {
+	d.mu.Lock()
+	url, session, seq := d.resumeURL, d.sessionID, d.seq
+	d.mu.Unlock()
+
+	if url == "" {
+		var gw struct {
+			URL string `json:"url"`
+		}
+		if err := d.api("GET", "gateway/bot", nil, &gw); err != nil {
+			return nil, err
+		}
+		url = gw.URL
+		session = ""
+	}
+
+	ws, frames, err := dialGateway(url+"/?v=10&encoding=json", d.url)
+	if err != nil {
+		// Start over with a fresh session next time.
+		d.mu.Lock()
+		d.resumeURL = ""
+		d.mu.Unlock()
+		return nil, err
+	}
+	g := &gateway{ws: ws, frames: frames, acked: true}
+
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	var p payload
+	if err := websocket.JSON.Receive(ws, &p); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if p.Op != opHello {
+		ws.Close()
+		return nil, fmt.Errorf("expected hello, got op %d", p.Op)
+	}
+	json.Unmarshal(p.Data, &hello)
+	go d.heartbeat(g, time.Duration(hello.HeartbeatInterval)*time.Millisecond)
+
+	if session != "" {
+		err = g.send(opResume, map[string]interface{}{
+			"token":      d.config.Discord.Token,
+			"session_id": session,
+			"seq":        seq,
+		})
+	} else {
+		err = g.send(opIdentify, map[string]interface{}{
+			"token":   d.config.Discord.Token,
+			"intents": intents,
+			"properties": map[string]string{
+				"os":      "linux",
+				"browser": "catbase",
+				"device":  "catbase",
+			},
+		})
+	}
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+// heartbeat keeps the connection alive until it is closed. If Discord
+// doesn't acknowledge a heartbeat before the next one is due the connection
+// is a zombie, so we close it and resume on a new one.
+func (d *Discord) heartbeat(g *gateway, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		if !g.beat() {
+			log.Println("Discord didn't acknowledge our heartbeat, reconnecting")
+			g.ws.Close()
+			return
+		}
+		d.mu.Lock()
+		seq := d.seq
+		d.mu.Unlock()
+		if err := g.send(opHeartbeat, seq); err != nil {
+			return
+		}
+	}
+}
+
+// beat reports whether the last heartbeat was acknowledged and starts
+// waiting for the next one to be.
+func (g *gateway) beat() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	acked := g.acked
+	g.acked = false
+	return acked
+}
+
+func (g *gateway) ack() {
+	g.mu.Lock()
+	g.acked = true
+	g.mu.Unlock()
+}
+
+// readGateway dispatches events until Discord disconnects us or the
+// connection fails, and reports whether the session was ready by then. A nil
+// error means Discord asked us to reconnect.
+func (d *Discord) readGateway(g *gateway) (ready bool, err error) {
+	for {
+		var p payload
+		if err := websocket.JSON.Receive(g.ws, &p); err != nil {
+			code := g.frames.closeCode()
+			if reason, ok := fatalCloses[code]; ok {
+				return ready, bot.Permanent(fmt.Errorf("Discord closed the gateway with %d: %s", code, reason))
+			}
+			return ready, err
+		}
+		if p.Sequence != nil {
+			d.mu.Lock()
+			d.seq = *p.Sequence
+			d.mu.Unlock()
+		}
+
+		switch p.Op {
+		case opDispatch:
+			d.handleEvent(p.Type, p.Data)
+			if p.Type == "READY" || p.Type == "RESUMED" {
+				ready = true
+			}
+		case opHeartbeat:
+			d.mu.Lock()
+			seq := d.seq
+			d.mu.Unlock()
+			if err := g.send(opHeartbeat, seq); err != nil {
+				return ready, err
+			}
+		case opHeartbeatAck:
+			g.ack()
+		case opReconnect:
+			log.Println("Discord asked us to reconnect")
+			return ready, nil
+		case opInvalidSession:
+			var resumable bool
+			json.Unmarshal(p.Data, &resumable)
+			if !resumable {
+				d.mu.Lock()
+				d.resumeURL, d.sessionID, d.seq = "", "", 0
+				d.mu.Unlock()
+			}
+			log.Printf("Discord invalidated our session, resumable: %v", resumable)
+			return ready, fmt.Errorf("session invalidated")
+		default:
+			log.Printf("Unhandled Discord gateway op: %d", p.Op)
+		}
+	}
+}
+
+// dialGateway opens a websocket to the gateway over a connection that keeps
+// track of the code it is closed with, which the websocket package throws
+// away.
+func dialGateway(location, origin string) (*websocket.Conn, *closeReader, error) {
+	config, err := websocket.NewConfig(location, origin)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conn net.Conn
+	switch config.Location.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", hostPort(config.Location, "80"))
+	case "wss":
+		conn, err = tls.Dial("tcp", hostPort(config.Location, "443"), nil)
+	default:
+		err = websocket.ErrBadScheme
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	frames := &closeReader{ReadWriteCloser: conn}
+	ws, err := websocket.NewClient(config, frames)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return ws, frames, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// closeReader follows the frames read from the server, starting after the
+// handshake's headers, and remembers the code of the close frame.
+type closeReader struct {
+	io.ReadWriteCloser
+
+	mu sync.Mutex
+	// crlfs counts the line endings read at the end of the handshake so
+	// far, up to the blank line that ends it
+	crlfs int
+	// header holds the frame header read so far, and left the length of
+	// the frame's payload once it is all there
+	header []byte
+	left   uint64
+	close  bool
+	code   []byte
+}
+
+func (c *closeReader) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.mu.Lock()
+	c.follow(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// closeCode returns the code the server closed the connection with, or 0 if
+// it hasn't sent one.
+func (c *closeReader) closeCode() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.code) < 2 {
+		return 0
+	}
+	return int(c.code[0])<<8 | int(c.code[1])
+}
+
+func (c *closeReader) follow(b []byte) {
+	const endOfHeaders = "\r\n\r\n"
+	for len(b) > 0 {
+		switch {
+		case c.crlfs < len(endOfHeaders):
+			if b[0] == endOfHeaders[c.crlfs] {
+				c.crlfs++
+			} else if b[0] == '\r' {
+				c.crlfs = 1
+			} else {
+				c.crlfs = 0
+			}
+			b = b[1:]
+
+		case c.left > 0:
+			n := uint64(len(b))
+			if n > c.left {
+				n = c.left
+			}
+			if c.close {
+				for _, x := range b[:n] {
+					if len(c.code) < 2 {
+						c.code = append(c.code, x)
+					}
+				}
+			}
+			c.left -= n
+			b = b[n:]
+
+		default:
+			c.header = append(c.header, b[0])
+			b = b[1:]
+			if size := headerSize(c.header); size > 0 && len(c.header) == size {
+				c.close = c.header[0]&0x0f == websocket.CloseFrame
+				c.left = payloadLength(c.header)
+				c.header = c.header[:0]
+			}
+		}
+	}
+}
+
+// headerSize is how long a frame header that starts with h is, or 0 if h is
+// too short to tell.
+func headerSize(h []byte) int {
+	if len(h) < 2 {
+		return 0
+	}
+	size := 2
+	switch h[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if h[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+func payloadLength(h []byte) uint64 {
+	switch n := h[1] & 0x7f; n {
+	case 126:
+		return uint64(h[2])<<8 | uint64(h[3])
+	case 127:
+		var l uint64
+		for _, x := range h[2:10] {
+			l = l<<8 | uint64(x)
+		}
+		return l
+	default:
+		return uint64(n)
+	}
+}
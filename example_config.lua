@@ -24,16 +24,29 @@ config = {
 	FullName = "CatBase",
 	Your = {
 	  MaxLength = 140,
-	  DuckingChance = 0.5,
-	  FuckingChance = 0.15,
-	  YourChance = 0.4
+	  Replacements = {
+		{ This = "fucking", That = "ducking", Frequency = 0.5 },
+		{ This = "your", That = "you're", Frequency = 0.4 }
+	  }
 	},
 	Emojify = {
 	  Chance = 0.02
 	},
 	DB = {
+	  -- "sqlite" (the default) or "postgres"
+	  Type = "sqlite",
+	  -- the SQLite database file
 	  File = "catbase.db",
+	  -- for PostgreSQL, the server as host or host:port, the database name and
+	  -- who to log in as; Password is best kept in a secrets file or set with
+	  -- CATBASE_DB_PASSWORD, and Options takes any other connection settings,
+	  -- e.g. "sslmode=disable"
 	  Server = "127.0.0.1"
+	  -- Name = "catbase",
+	  -- User = "catbase",
+	  -- Options = "sslmode=disable",
+	  -- where the backup and export commands save files, by default next to File
+	  -- BackupDir = "backups"
 	},
 	Plugins = {
 	},
@@ -87,7 +100,20 @@ config = {
 	  Pass = "CatBaseTest:test"
 	},
 	Slack = {
-	  Token = "<your slack token>"
+	  Token = "<your slack bot token>",
+	  AppToken = "<your slack app token>",
+	  Mode = "socket",
+	  SigningSecret = "<your slack signing secret>",
+	  EventsPath = "/slack/events"
+	},
+	Discord = {
+	  Token = "<your discord bot token>",
+	  GuildID = "<your discord server id>"
+	},
+	Matrix = {
+	  Homeserver = "https://matrix.example.org",
+	  UserID = "@catbase:example.org",
+	  AccessToken = "<your matrix access token>"
 	},
 	TwitterConsumerKey = "<Consumer Key>",
 	Babbler = {
@@ -96,6 +122,14 @@ config = {
 	  }
 	},
 	Type = "slack",
+	-- To connect to several services at once, list them here instead of
+	-- setting Type, and name channels like "name:channel".
+	-- Connectors = {
+	--   { Name = "freenode", Type = "irc" },
+	--   { Name = "work", Type = "slack" },
+	--   { Name = "other", Type = "irc", Irc = { Server = "irc.example.org:6697" } }
+	-- },
+	-- On Matrix, admins are their full user IDs, like "@you:example.org".
 	Admins = {
 	  "<Admin Nick>"
 	},
@@ -103,9 +137,58 @@ config = {
 	  Sightings = {
 		"user"
 	  },
-	  DBPath = "stats.db"
+	  DBPath = "stats.db",
+	  -- stats are saved up and written this often
+	  FlushSeconds = 10,
+	  -- words not worth counting, besides the usual ones like "the"
+	  Stopwords = {
+	  }
 	},
 	HttpAddr = "127.0.0.1:1337",
+	-- Admins can change settings while the bot runs with "config set", or
+	-- at http://<HttpAddr>/config given this token.
+	-- ConfigToken = "<a long random string>",
+	-- Secrets can live elsewhere: each file here sets a table named secrets,
+	-- such as secrets = { Slack = { Token = "..." } }, which is merged into
+	-- this one. Any setting can also be overridden from the environment, with
+	-- names like CATBASE_SLACK_TOKEN for Slack.Token, or read from a file
+	-- named by CATBASE_SLACK_TOKEN_FILE.
+	SecretsFiles = {
+	  -- "secrets.lua"
+	},
+	Webhooks = {
+	  -- POST to http://<HttpAddr>/webhook/<Name>
+	  -- { Name = "cron", Token = "<a long random string>", Channels = { "#CatBaseTest" } },
+	  -- { Name = "alerts", Token = "<a long random string>", Channels = { "#CatBaseTest" }, Format = "alert" },
+	  -- { Name = "git", Token = "<a long random string>", Channels = { "#CatBaseTest" }, Format = "gitpush" },
+	  -- { Name = "ci", Token = "<a long random string>", Channels = { "#CatBaseTest" },
+	  --   Template = "{{bold .name}} build {{.status}}" }
+	},
+	Scripts = {
+	  -- every .lua file here is loaded as a plugin, see plugins/script
+	  Dir = "scripts"
+	},
+	External = {
+	  -- programs speaking the protocol documented in plugins/external
+	  -- { Name = "weather", Command = { "python3", "weather.py" }, Dir = "plugins.d", Env = { "API_KEY=..." } }
+	},
+	OutgoingWebhooks = {
+	  -- POSTs matching messages as JSON, and with Reply posts the response back
+	  -- { Name = "deploy", URL = "https://ci.example.org/hooks/chat", Token = "<a long random string>",
+	  --   Match = "^deploy (\\w+)$", Channels = { "#CatBaseTest" }, Command = true, Reply = true }
+	},
+	ChannelSettings = {
+	  -- settings for one channel, over those above
+	  -- ["#quiet"] = { Emojify = { Chance = 0 }, Factoid = { QuoteChance = 0 } }
+	},
+	Bridge = {
+	  -- each group of channels is mirrored into each other
+	  Channels = {
+	    -- { "freenode:#catbase", "work:#catbase" }
+	  },
+	  Edits = true,
+	  Reactions = true
+	},
   Inventory = {
     Max = 5
   },
@@ -114,5 +197,3 @@ config = {
     MinPush = 1
   }
 }
-
-}
\ No newline at end of file
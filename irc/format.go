@@ -0,0 +1,114 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package irc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// IRC formatting control codes.
+const (
+	codeBold      = '\x02'
+	codeColor     = '\x03'
+	codeHexColor  = '\x04'
+	codeReset     = '\x0f'
+	codeMonospace = '\x11'
+	codeReverse   = '\x16'
+	codeItalic    = '\x1d'
+	codeStrike    = '\x1e'
+	codeUnderline = '\x1f'
+)
+
+// render converts marked up text to IRC formatting codes.
+func render(text string) string {
+	return msg.Render(text, renderSpan)
+}
+
+func renderSpan(s msg.Span) string {
+	switch s.Style {
+	case msg.StyleBold:
+		return fmt.Sprintf("%c%s%c", codeBold, s.Text, codeBold)
+	case msg.StyleItalic:
+		return fmt.Sprintf("%c%s%c", codeItalic, s.Text, codeItalic)
+	case msg.StyleCode:
+		return fmt.Sprintf("%c%s%c", codeMonospace, s.Text, codeMonospace)
+	case msg.StyleEmoji:
+		if e, ok := msg.EmojiCharacter(s.Text); ok {
+			return e
+		}
+	case msg.StyleLink:
+		if s.Text != "" && s.Text != s.Target {
+			return fmt.Sprintf("%s <%s>", s.Text, s.Target)
+		}
+	}
+	return msg.StripSpan(s)
+}
+
+// stripFormatting removes IRC bold, color, italic and similar control codes
+// from text so plugins only ever see what the user typed.
+func stripFormatting(text string) string {
+	if strings.IndexFunc(text, isFormatting) < 0 {
+		return text
+	}
+	var out []byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case codeColor:
+			// ^C[fg[,bg]] where fg and bg are up to two digits.
+			n := skipDigits(text[i+1:], 2)
+			i += n
+			if n > 0 && i+2 < len(text) && text[i+1] == ',' && isDigit(text[i+2]) {
+				i++
+				i += skipDigits(text[i+1:], 2)
+			}
+		case codeHexColor:
+			// ^D[RRGGBB[,RRGGBB]]
+			n := skipHex(text[i+1:])
+			i += n
+			if n > 0 && i+1 < len(text) && text[i+1] == ',' && skipHex(text[i+2:]) > 0 {
+				i++
+				i += skipHex(text[i+1:])
+			}
+		case codeBold, codeReset, codeMonospace, codeReverse, codeItalic, codeStrike, codeUnderline:
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
+
+func isFormatting(r rune) bool {
+	switch r {
+	case codeBold, codeColor, codeHexColor, codeReset, codeMonospace,
+		codeReverse, codeItalic, codeStrike, codeUnderline:
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func skipDigits(s string, max int) int {
+	n := 0
+	for n < len(s) && n < max && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
+
+func skipHex(s string) int {
+	if len(s) < 6 {
+		return 0
+	}
+	for i := 0; i < 6; i++ {
+		c := s[i]
+		if !isDigit(c) && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return 0
+		}
+	}
+	return 6
+}
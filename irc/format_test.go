@@ -0,0 +1,39 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot/msg"
+)
+
+func TestRender(t *testing.T) {
+	assert.Equal(t, "plain", render("plain"))
+	assert.Equal(t, "\x02bold\x02 \x1dit\x1d \x11code\x11", render(msg.Bold("bold")+" "+msg.Italic("it")+" "+msg.Code("code")))
+	assert.Equal(t, "🍵 :nope:", render(msg.Emoji("tea")+" "+msg.Emoji("nope")))
+	assert.Equal(t, "docs <http://x> http://y", render(msg.Link("docs", "http://x")+" "+msg.Link("", "http://y")))
+	assert.Equal(t, "alice", render(msg.Mention("alice", "")))
+}
+
+func TestRenderNested(t *testing.T) {
+	assert.Equal(t, "\x1dhugs 🍵 ok\x1d", render(msg.Italic("hugs "+msg.Emoji("tea")+" ok")))
+	assert.Equal(t, "\x02\x1dboth\x1d\x02", render(msg.Bold(msg.Italic("both"))))
+}
+
+func TestStripFormatting(t *testing.T) {
+	for in, want := range map[string]string{
+		"plain":                         "plain",
+		"\x02bold\x02 \x1dit\x1d":       "bold it",
+		"\x0304red\x03 \x0304,12on\x03": "red on",
+		"\x03,no color":                 ",no color",
+		"\x0312":                        "",
+		"\x04ff0000hex\x04":             "hex",
+		"\x04ff0000,00ff00both":         "both",
+		"\x04nothex":                    "nothex",
+		"\x0freset \x1funder\x1e\x16":   "reset under",
+	} {
+		assert.Equal(t, want, stripFormatting(in), "%q", in)
+	}
+}
@@ -44,7 +44,6 @@ type Irc struct {
 
 	eventReceived   func(msg.Message)
 	messageReceived func(msg.Message)
-	replyMessageReceived  func(msg.Message, string)
 }
 
 func New(c *config.Config) *Irc {
@@ -62,16 +61,20 @@ func (i *Irc) RegisterMessageReceived(f func(msg.Message)) {
 	i.messageReceived = f
 }
 
-func (i *Irc) RegisterReplyMessageReceived(f func(msg.Message, string)) {
-	i.replyMessageReceived = f
-}
-
 func (i *Irc) JoinChannel(channel string) {
 	log.Printf("Joining channel: %s", channel)
 	i.Client.Out <- irc.Msg{Cmd: irc.JOIN, Args: []string{channel}}
 }
 
 func (i *Irc) SendMessage(channel, message string) string {
+	// IRC messages can't span lines, so send each line on its own.
+	for _, line := range strings.Split(render(message), "\n") {
+		i.sendLine(channel, line)
+	}
+	return bot.NoIdentifier
+}
+
+func (i *Irc) sendLine(channel, message string) {
 	for len(message) > 0 {
 		m := irc.Msg{
 			Cmd:  "PRIVMSG",
@@ -95,23 +98,27 @@ func (i *Irc) SendMessage(channel, message string) string {
 
 		i.Client.Out <- m
 	}
-	return "NO_IRC_IDENTIFIERS"
 }
 
 // Sends action to channel
 func (i *Irc) SendAction(channel, message string) string {
-	message = actionPrefix + " " + message + "\x01"
+	message = actionPrefix + " " + render(message) + "\x01"
 
-	i.SendMessage(channel, message)
-	return "NO_IRC_IDENTIFIERS"
+	i.sendLine(channel, message)
+	return bot.NoIdentifier
+}
+
+// SendRich sends a structured message as plain text, since that's all IRC has.
+func (i *Irc) SendRich(channel string, message msg.Rich) string {
+	return i.SendMessage(channel, message.Fallback())
 }
 
 func (i *Irc) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
-	return "NO_IRC_IDENTIFIERS", false
+	return bot.NoIdentifier, false
 }
 
 func (i *Irc) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
-	return "NO_IRC_IDENTIFIERS", false
+	return bot.NoIdentifier, false
 }
 
 func (i *Irc) React(channel, reaction string, message msg.Message) bool {
@@ -275,9 +282,10 @@ func (i *Irc) buildMessage(inMsg irc.Msg) msg.Message {
 	}
 
 	isAction := false
-	var message string
+	var message, raw string
 	if len(inMsg.Args) > 1 {
-		message = inMsg.Args[1]
+		raw = inMsg.Args[1]
+		message = stripFormatting(raw)
 
 		isAction = strings.HasPrefix(message, actionPrefix)
 		if isAction {
@@ -290,18 +298,19 @@ func (i *Irc) buildMessage(inMsg irc.Msg) msg.Message {
 	iscmd := false
 	filteredMessage := message
 	if !isAction {
-		iscmd, filteredMessage = bot.IsCmd(i.config, message)
+		iscmd, filteredMessage = bot.IsCmd(i.config.ForChannel(channel), message)
 	}
 
 	msg := msg.Message{
 		User:    &u,
 		Channel: channel,
 		Body:    filteredMessage,
-		Raw:     message,
+		Raw:     raw,
 		Command: iscmd,
 		Action:  isAction,
 		Time:    time.Now(),
 		Host:    inMsg.Host,
+		Kind:    inMsg.Cmd,
 	}
 
 	return msg
@@ -310,3 +319,9 @@ func (i *Irc) buildMessage(inMsg irc.Msg) msg.Message {
 func (i Irc) Who(channel string) []string {
 	return []string{}
 }
+
+// OpenDM returns the user's nick, since messages sent to a nick open a query
+// window with them.
+func (i *Irc) OpenDM(u user.User) (string, error) {
+	return u.Name, nil
+}
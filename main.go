@@ -4,17 +4,25 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/discord"
 	"github.com/velour/catbase/irc"
+	"github.com/velour/catbase/matrix"
 	"github.com/velour/catbase/plugins/admin"
 	"github.com/velour/catbase/plugins/babbler"
 	"github.com/velour/catbase/plugins/beers"
+	"github.com/velour/catbase/plugins/bridge"
 	"github.com/velour/catbase/plugins/counter"
 	"github.com/velour/catbase/plugins/dice"
 	"github.com/velour/catbase/plugins/emojifyme"
+	"github.com/velour/catbase/plugins/external"
 	"github.com/velour/catbase/plugins/fact"
 	"github.com/velour/catbase/plugins/first"
 	"github.com/velour/catbase/plugins/inventory"
@@ -23,11 +31,13 @@ import (
 	"github.com/velour/catbase/plugins/reminder"
 	"github.com/velour/catbase/plugins/rpgORdie"
 	"github.com/velour/catbase/plugins/rss"
+	"github.com/velour/catbase/plugins/script"
 	"github.com/velour/catbase/plugins/sisyphus"
 	"github.com/velour/catbase/plugins/stats"
 	"github.com/velour/catbase/plugins/talker"
 	"github.com/velour/catbase/plugins/tell"
 	"github.com/velour/catbase/plugins/twitch"
+	"github.com/velour/catbase/plugins/webhook"
 	"github.com/velour/catbase/plugins/your"
 	"github.com/velour/catbase/plugins/zork"
 	"github.com/velour/catbase/slack"
@@ -36,23 +46,53 @@ import (
 func main() {
 	var cfile = flag.String("config", "config.lua",
 		"Config file to load. (Defaults to config.lua)")
+	var checkConfig = flag.Bool("check-config", false,
+		"Check the config file for problems and exit.")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [command]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr, commandUsage)
+	}
 	flag.Parse() // parses the logging flags.
 
+	if *checkConfig {
+		if _, errs := config.Load(*cfile); len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("%s is OK.\n", *cfile)
+		return
+	}
+
 	c := config.Readconfig(Version, *cfile)
-	var client bot.Connector
+	if flag.NArg() > 0 {
+		if err := runCommand(c, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	switch c.Type {
-	case "irc":
-		client = irc.New(c)
-	case "slack":
-		client = slack.New(c)
-	default:
-		log.Fatalf("Unknown connection type: %s", c.Type)
+	var client bot.Connector
+	name := c.Type
+	if len(c.Connectors) == 0 {
+		client = newConnector(c)
+	} else {
+		name = "connectors"
+		multi := bot.NewMulti()
+		for _, conn := range c.Connectors {
+			multi.Add(conn.Name, newConnector(c.ForConnector(conn)))
+		}
+		client = multi
 	}
 
 	b := bot.New(c, client)
 
 	// b.AddHandler(plugins.NewTestPlugin(b))
+	// bridge first, it relays everything and never handles anything
+	b.AddHandler("bridge", bridge.New(b))
 	b.AddHandler("admin", admin.New(b))
 	b.AddHandler("stats", stats.New(b))
 	b.AddHandler("first", first.New(b))
@@ -75,11 +115,39 @@ func main() {
 	b.AddHandler("rpgORdie", rpgORdie.New(b))
 	b.AddHandler("sisyphus", sisyphus.New(b))
 	b.AddHandler("tell", tell.New(b))
+	b.AddHandler("webhook", webhook.New(b))
+	b.AddHandler("script", script.New(b))
+	for _, e := range c.External {
+		b.AddHandler(e.Name, external.New(b, e))
+	}
 	// catches anything left, will always return true
 	b.AddHandler("factoid", fact.New(b))
 
-	for {
-		err := client.Serve()
-		log.Println(err)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		log.Printf("Got %s, stopping.", <-signals)
+		b.Close()
+		os.Exit(0)
+	}()
+
+	err := bot.ServeForever(name, client)
+	b.Close()
+	log.Fatal(err)
+}
+
+// newConnector makes the connection c.Type names.
+func newConnector(c *config.Config) bot.Connector {
+	switch c.Type {
+	case "irc":
+		return irc.New(c)
+	case "slack":
+		return slack.New(c)
+	case "discord":
+		return discord.New(c)
+	case "matrix":
+		return matrix.New(c)
 	}
+	log.Fatalf("Unknown connection type: %s", c.Type)
+	return nil
 }
@@ -0,0 +1,116 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	clientPath = "_matrix/client/v3/"
+	mediaPath  = "_matrix/media/v3/"
+
+	// requestTimeout bounds ordinary requests. Syncs add their own long
+	// poll timeout on top.
+	requestTimeout = 30 * time.Second
+
+	// maxRetries is how many times a rate limited request is retried before
+	// we give up and return the M_LIMIT_EXCEEDED error.
+	maxRetries = 3
+)
+
+// APIError is an error response from the homeserver.
+type APIError struct {
+	Method, Path string `json:"-"`
+	StatusCode   int    `json:"-"`
+	// Code is the Matrix error code, e.g. M_FORBIDDEN
+	Code         string `json:"errcode"`
+	Message      string `json:"error"`
+	RetryAfterMs int64  `json:"retry_after_ms"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("matrix %s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, e.Code, e.Message)
+}
+
+// api makes a client-server API request with a JSON body, which may be nil,
+// and decodes the response into out, which may also be nil.
+func (m *Matrix) api(method, path string, query url.Values, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	return m.request(method, clientPath+path, query, "application/json", data, out)
+}
+
+// request makes a request to the homeserver, retrying when it rate limits us.
+func (m *Matrix) request(method, path string, query url.Values, contentType string, body []byte, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		err := m.do(method, path, query, contentType, body, out)
+		apiErr, ok := err.(*APIError)
+		if !ok || apiErr.Code != "M_LIMIT_EXCEEDED" || attempt >= maxRetries {
+			if err != nil {
+				log.Printf("matrix: %s %s failed: %s", method, path, err)
+			}
+			return err
+		}
+		wait := time.Duration(apiErr.RetryAfterMs) * time.Millisecond
+		if wait <= 0 {
+			wait = time.Second
+		}
+		log.Printf("matrix: %s %s rate limited, retrying in %s", method, path, wait)
+		m.sleep(wait)
+	}
+}
+
+func (m *Matrix) do(method, path string, query url.Values, contentType string, body []byte, out interface{}) error {
+	u := m.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.config.Matrix.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client := m.http
+	if query.Get("timeout") != "" {
+		client = m.syncHTTP
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading body: %s", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		json.Unmarshal(respBody, apiErr)
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("decoding response: %s", err)
+	}
+	return nil
+}
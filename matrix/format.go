@@ -0,0 +1,64 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package matrix
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// renderPlain converts marked up text to the plain body of a message.
+func renderPlain(text string) string {
+	return msg.Render(text, func(s msg.Span) string {
+		if s.Style == msg.StyleEmoji {
+			if e, ok := msg.EmojiCharacter(s.Text); ok {
+				return e
+			}
+		}
+		return msg.StripSpan(s)
+	})
+}
+
+// renderHTML converts marked up text to the HTML subset Matrix clients show.
+func renderHTML(text string) string {
+	return msg.Render(text, renderSpan)
+}
+
+// renderSpan escapes the text of plain spans, emoji and mentions, which Render
+// leaves as it is.
+func renderSpan(s msg.Span) string {
+	t := s.Text
+	switch s.Style {
+	case msg.StylePlain, msg.StyleEmoji, msg.StyleMention:
+		t = html.EscapeString(t)
+	}
+	switch s.Style {
+	case msg.StyleBold:
+		return "<strong>" + t + "</strong>"
+	case msg.StyleItalic:
+		return "<em>" + t + "</em>"
+	case msg.StyleCode:
+		return "<code>" + t + "</code>"
+	case msg.StyleCodeBlock:
+		return "<pre><code>" + t + "</code></pre>"
+	case msg.StyleEmoji:
+		if e, ok := msg.EmojiCharacter(s.Text); ok {
+			return e
+		}
+		return ":" + t + ":"
+	case msg.StyleLink:
+		if t == "" {
+			t = html.EscapeString(s.Target)
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(s.Target), t)
+	case msg.StyleMention:
+		if s.Target != "" {
+			return fmt.Sprintf(`<a href="https://matrix.to/#/%s">%s</a>`, html.EscapeString(s.Target), t)
+		}
+		return t
+	}
+	return strings.Replace(t, "\n", "<br>", -1)
+}
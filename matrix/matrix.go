@@ -0,0 +1,610 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package matrix connects to Matrix homeservers
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+)
+
+const (
+	// syncTimeout is how long the homeserver may hold a sync open waiting
+	// for events.
+	syncTimeout = 30 * time.Second
+
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+type Matrix struct {
+	config *config.Config
+
+	// url is the homeserver's base URL, ending in a slash
+	url      string
+	http     *http.Client
+	syncHTTP *http.Client
+	// sleep is time.Sleep, replaced in tests
+	sleep func(time.Duration)
+
+	// txn numbers the events we send so retries aren't duplicated
+	txn     int64
+	txnBase int64
+
+	mu sync.Mutex
+	// rooms maps the aliases in the config to the room IDs they joined
+	rooms map[string]string
+	// threads holds the IDs of events that are the root of a thread
+	threads map[string]bool
+
+	eventReceived   func(msg.Message)
+	messageReceived func(msg.Message)
+}
+
+// event is a room event from the sync timeline.
+type event struct {
+	Type           string          `json:"type"`
+	EventID        string          `json:"event_id"`
+	Sender         string          `json:"sender"`
+	OriginServerTS int64           `json:"origin_server_ts"`
+	StateKey       *string         `json:"state_key"`
+	Redacts        string          `json:"redacts"`
+	Content        json.RawMessage `json:"content"`
+}
+
+// content is the part of an event's content that we understand.
+type content struct {
+	MsgType       string     `json:"msgtype,omitempty"`
+	Body          string     `json:"body,omitempty"`
+	Format        string     `json:"format,omitempty"`
+	FormattedBody string     `json:"formatted_body,omitempty"`
+	URL           string     `json:"url,omitempty"`
+	Info          *fileInfo  `json:"info,omitempty"`
+	RelatesTo     *relatesTo `json:"m.relates_to,omitempty"`
+	NewContent    *content   `json:"m.new_content,omitempty"`
+	Membership    string     `json:"membership,omitempty"`
+}
+
+type fileInfo struct {
+	Size int `json:"size"`
+}
+
+type relatesTo struct {
+	RelType       string     `json:"rel_type,omitempty"`
+	EventID       string     `json:"event_id,omitempty"`
+	Key           string     `json:"key,omitempty"`
+	IsFallingBack bool       `json:"is_falling_back,omitempty"`
+	InReplyTo     *inReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type inReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]invite `json:"invite"`
+	} `json:"rooms"`
+}
+
+// invite is a room we've been invited to, described by some of its state.
+type invite struct {
+	InviteState struct {
+		Events []event `json:"events"`
+	} `json:"invite_state"`
+}
+
+func New(c *config.Config) *Matrix {
+	base := c.Matrix.Homeserver
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return &Matrix{
+		config:   c,
+		url:      base,
+		http:     &http.Client{Timeout: requestTimeout},
+		syncHTTP: &http.Client{Timeout: requestTimeout + syncTimeout},
+		sleep:    time.Sleep,
+		txnBase:  time.Now().UnixNano(),
+		rooms:    make(map[string]string),
+		threads:  make(map[string]bool),
+	}
+}
+
+func (m *Matrix) RegisterEventReceived(f func(msg.Message)) {
+	m.eventReceived = f
+}
+
+func (m *Matrix) RegisterMessageReceived(f func(msg.Message)) {
+	m.messageReceived = f
+}
+
+// roomID resolves a channel from the config to the room ID we joined.
+func (m *Matrix) roomID(channel string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.rooms[channel]; ok {
+		return id
+	}
+	return channel
+}
+
+// sendEvent sends a room event and returns its event ID.
+func (m *Matrix) sendEvent(channel, eventType string, c interface{}) (string, error) {
+	txn := fmt.Sprintf("catbase%d.%d", m.txnBase, atomic.AddInt64(&m.txn, 1))
+	path := fmt.Sprintf("rooms/%s/send/%s/%s",
+		url.PathEscape(m.roomID(channel)), eventType, txn)
+	var resp struct {
+		EventID string `json:"event_id"`
+	}
+	if err := m.api("PUT", path, nil, c, &resp); err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
+}
+
+// textContent builds the content of a message from marked up text.
+func textContent(msgType, message string) *content {
+	return &content{
+		MsgType:       msgType,
+		Body:          renderPlain(message),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: renderHTML(message),
+	}
+}
+
+func (m *Matrix) SendMessage(channel, message string) string {
+	log.Printf("Sending message to %s: %s", channel, message)
+	id, err := m.sendEvent(channel, "m.room.message", textContent("m.text", message))
+	if err != nil {
+		log.Printf("Error sending Matrix message: %s", err)
+	}
+	return id
+}
+
+func (m *Matrix) SendAction(channel, message string) string {
+	log.Printf("Sending action to %s: %s", channel, message)
+	id, err := m.sendEvent(channel, "m.room.message", textContent("m.emote", message))
+	if err != nil {
+		log.Printf("Error sending Matrix action: %s", err)
+	}
+	return id
+}
+
+// SendRich sends a structured message as text and uploads attached files to
+// the homeserver's media repository.
+func (m *Matrix) SendRich(channel string, message msg.Rich) string {
+	var files []msg.Attachment
+	text := message
+	text.Attachments = nil
+	for _, a := range message.Attachments {
+		if len(a.Data) > 0 && a.Kind != msg.AttachSnippet {
+			files = append(files, a)
+		} else {
+			text.Attachments = append(text.Attachments, a)
+		}
+	}
+
+	var id string
+	if body := text.Fallback(); body != "" {
+		if message.ThreadID != "" {
+			id, _ = m.ReplyToMessageIdentifier(channel, body, message.ThreadID)
+		} else {
+			id = m.SendMessage(channel, body)
+		}
+	}
+	for _, a := range files {
+		if err := m.sendFile(channel, a); err != nil {
+			log.Printf("Error uploading %s to Matrix: %s", a.Name, err)
+		}
+	}
+	return id
+}
+
+func (m *Matrix) sendFile(channel string, a msg.Attachment) error {
+	var upload struct {
+		ContentURI string `json:"content_uri"`
+	}
+	err := m.request("POST", mediaPath+"upload", url.Values{"filename": {a.Name}},
+		"application/octet-stream", a.Data, &upload)
+	if err != nil {
+		return err
+	}
+	msgType := "m.file"
+	if a.Kind == msg.AttachImage {
+		msgType = "m.image"
+	}
+	name := a.Title
+	if name == "" {
+		name = a.Name
+	}
+	_, err = m.sendEvent(channel, "m.room.message", &content{
+		MsgType: msgType,
+		Body:    name,
+		URL:     upload.ContentURI,
+		Info:    &fileInfo{Size: len(a.Data)},
+	})
+	return err
+}
+
+// ReplyToMessageIdentifier replies to an event, in its thread if it started
+// one.
+func (m *Matrix) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
+	c := textContent("m.text", message)
+	c.RelatesTo = &relatesTo{InReplyTo: &inReplyTo{EventID: identifier}}
+	m.mu.Lock()
+	if m.threads[identifier] {
+		c.RelatesTo.RelType = "m.thread"
+		c.RelatesTo.EventID = identifier
+		c.RelatesTo.IsFallingBack = true
+	}
+	m.mu.Unlock()
+
+	id, err := m.sendEvent(channel, "m.room.message", c)
+	if err != nil {
+		log.Printf("Error sending Matrix reply: %s", err)
+		return "", false
+	}
+	return id, true
+}
+
+func (m *Matrix) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
+	return m.ReplyToMessageIdentifier(channel, message, replyTo.ID)
+}
+
+func (m *Matrix) React(channel, reaction string, message msg.Message) bool {
+	log.Printf("Reacting in %s: %s", channel, reaction)
+	key, ok := msg.EmojiCharacter(reaction)
+	if !ok {
+		key = ":" + strings.Trim(reaction, ":") + ":"
+	}
+	_, err := m.sendEvent(channel, "m.reaction", &content{
+		RelatesTo: &relatesTo{RelType: "m.annotation", EventID: message.ID, Key: key},
+	})
+	if err != nil {
+		log.Printf("reaction failed: %s", err)
+		return false
+	}
+	return true
+}
+
+// Edit replaces an event's text. Clients that don't understand edits show the
+// fallback body, which is the new text marked with an asterisk.
+func (m *Matrix) Edit(channel, newMessage, identifier string) bool {
+	log.Printf("Editing in (%s) %s: %s", identifier, channel, newMessage)
+	c := textContent("m.text", "* "+newMessage)
+	c.NewContent = textContent("m.text", newMessage)
+	c.RelatesTo = &relatesTo{RelType: "m.replace", EventID: identifier}
+	if _, err := m.sendEvent(channel, "m.room.message", c); err != nil {
+		log.Printf("edit failed: %s", err)
+		return false
+	}
+	return true
+}
+
+// GetEmojiList returns nothing, Matrix has no custom emoji.
+func (m *Matrix) GetEmojiList() map[string]string {
+	return make(map[string]string)
+}
+
+// Who gets the names of the users joined to a room
+func (m *Matrix) Who(channel string) []string {
+	log.Println("Who is queried for ", channel)
+	var resp struct {
+		Joined map[string]json.RawMessage `json:"joined"`
+	}
+	path := "rooms/" + url.PathEscape(m.roomID(channel)) + "/joined_members"
+	if err := m.api("GET", path, nil, nil, &resp); err != nil {
+		log.Printf("Error getting room members: %s", err)
+		return []string{}
+	}
+	handles := []string{}
+	for id := range resp.Joined {
+		handles = append(handles, localpart(id))
+	}
+	log.Printf("Returning %d handles", len(handles))
+	return handles
+}
+
+// OpenDM creates a direct chat with a user. Users we only know by a name
+// that isn't a user ID are assumed to be on the bot's homeserver.
+func (m *Matrix) OpenDM(u user.User) (string, error) {
+	id := u.ID
+	if id == "" && strings.HasPrefix(u.Name, "@") && strings.Contains(u.Name, ":") {
+		id = u.Name
+	}
+	if id == "" {
+		id = "@" + u.Name + ":" + serverName(m.config.Matrix.UserID)
+	}
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	err := m.api("POST", "createRoom", nil, map[string]interface{}{
+		"is_direct": true,
+		"invite":    []string{id},
+		"preset":    "trusted_private_chat",
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.RoomID, nil
+}
+
+// localpart returns the name part of a user ID like @name:server.
+func localpart(id string) string {
+	id = strings.TrimPrefix(id, "@")
+	if i := strings.Index(id, ":"); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// serverName returns the server part of a user ID like @name:server.
+func serverName(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 {
+		return id[i+1:]
+	}
+	return ""
+}
+
+// Serve joins the configured rooms and syncs until the access token stops
+// working.
+func (m *Matrix) Serve() error {
+	if m.eventReceived == nil || m.messageReceived == nil {
+		return fmt.Errorf("Missing an event handler")
+	}
+
+	for _, ch := range m.config.Channels {
+		if err := m.join(ch); err != nil {
+			log.Printf("Error joining %s: %s", ch, err)
+		}
+	}
+
+	since := ""
+	backoff := initialBackoff
+	for {
+		query := url.Values{"timeout": {fmt.Sprint(int64(syncTimeout / time.Millisecond))}}
+		if since == "" {
+			// Skip whatever happened while we were away.
+			query.Set("timeout", "0")
+			query.Set("filter", `{"room":{"timeline":{"limit":0}}}`)
+		} else {
+			query.Set("since", since)
+		}
+
+		var resp syncResponse
+		if err := m.api("GET", "sync", query, nil, &resp); err != nil {
+			if apiErr, ok := err.(*APIError); ok && apiErr.StatusCode == http.StatusUnauthorized {
+				return err
+			}
+			log.Printf("Matrix sync failed, retrying in %s: %s", backoff, err)
+			m.sleep(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = initialBackoff
+
+		if since != "" {
+			m.handleSync(resp)
+		}
+		since = resp.NextBatch
+	}
+}
+
+func (m *Matrix) join(room string) error {
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := m.api("POST", "join/"+url.PathEscape(room), nil, struct{}{}, &resp); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.rooms[room] = resp.RoomID
+	m.mu.Unlock()
+	log.Printf("Joined %s as %s", room, resp.RoomID)
+	return nil
+}
+
+func (m *Matrix) handleSync(resp syncResponse) {
+	for room, inv := range resp.Rooms.Invite {
+		inviter, ok := m.acceptInvite(room, inv)
+		if !ok {
+			log.Printf("Ignoring invite to %s from %s", room, inviter)
+			continue
+		}
+		if err := m.join(room); err != nil {
+			log.Printf("Error accepting invite to %s: %s", room, err)
+		}
+	}
+	for room, joined := range resp.Rooms.Join {
+		for _, ev := range joined.Timeline.Events {
+			if ev.Sender == m.config.Matrix.UserID {
+				continue
+			}
+			m.handleEvent(room, ev)
+		}
+	}
+}
+
+// acceptInvite decides whether to join a room we've been invited to: only
+// rooms in the config, by ID or alias, or invites from admins are accepted.
+// It also returns who sent the invite.
+func (m *Matrix) acceptInvite(room string, inv invite) (string, bool) {
+	names := []string{room}
+	inviter := ""
+	for _, ev := range inv.InviteState.Events {
+		switch {
+		case ev.Type == "m.room.canonical_alias":
+			var alias struct {
+				Alias string `json:"alias"`
+			}
+			json.Unmarshal(ev.Content, &alias)
+			names = append(names, alias.Alias)
+		case ev.Type == "m.room.member" && ev.StateKey != nil && *ev.StateKey == m.config.Matrix.UserID:
+			inviter = ev.Sender
+		}
+	}
+	for _, ch := range m.config.Channels {
+		for _, name := range names {
+			if ch == name {
+				return inviter, true
+			}
+		}
+	}
+	if inviter == "" {
+		return inviter, false
+	}
+	// Anyone can pick any name on their own homeserver, so admins are only
+	// recognized by their full user ID.
+	for _, admin := range m.config.Admins {
+		if admin == inviter {
+			return inviter, true
+		}
+	}
+	return inviter, false
+}
+
+// handleEvent dispatches a single timeline event.
+func (m *Matrix) handleEvent(room string, ev event) {
+	var c content
+	if err := json.Unmarshal(ev.Content, &c); err != nil {
+		log.Printf("Error decoding Matrix event %s: %s", ev.EventID, err)
+		return
+	}
+
+	switch ev.Type {
+	case "m.room.message":
+		if c.MsgType == "m.notice" {
+			// Notices are what bots send, and bots shouldn't answer
+			// each other.
+			return
+		}
+		message := m.buildMessage(room, ev, c)
+		if c.RelatesTo != nil && c.RelatesTo.RelType == "m.replace" {
+			if c.NewContent != nil {
+				message = m.buildMessage(room, ev, *c.NewContent)
+			}
+			message.Kind = msg.MessageEdited
+			message.Target = c.RelatesTo.EventID
+			m.eventReceived(message)
+			return
+		}
+		m.messageReceived(message)
+
+	case "m.reaction":
+		if c.RelatesTo == nil || c.RelatesTo.RelType != "m.annotation" {
+			return
+		}
+		m.eventReceived(msg.Message{
+			User:     m.user(ev.Sender),
+			Channel:  room,
+			Time:     eventTime(ev),
+			ID:       ev.EventID,
+			Kind:     msg.ReactionAdded,
+			Target:   c.RelatesTo.EventID,
+			Reaction: strings.Trim(c.RelatesTo.Key, ":"),
+		})
+
+	case "m.room.redaction":
+		// Redacting a reaction also lands here; Matrix doesn't tell us
+		// which kind of event was redacted.
+		m.eventReceived(msg.Message{
+			User:    m.user(ev.Sender),
+			Channel: room,
+			Time:    eventTime(ev),
+			ID:      ev.EventID,
+			Kind:    msg.MessageDeleted,
+			Target:  ev.Redacts,
+		})
+
+	case "m.room.member":
+		if c.Membership == "join" && ev.StateKey != nil && *ev.StateKey == ev.Sender {
+			m.eventReceived(msg.Message{
+				User:    m.user(ev.Sender),
+				Channel: room,
+				Time:    eventTime(ev),
+				ID:      ev.EventID,
+				Kind:    "JOIN",
+			})
+		}
+
+	default:
+		log.Printf("Unhandled Matrix event type: '%s'", ev.Type)
+	}
+}
+
+// user makes a user for an ID. The name is the full user ID too, since the
+// localpart alone doesn't say who someone is and names are what admins are
+// checked by.
+func (m *Matrix) user(id string) *user.User {
+	return &user.User{ID: id, Name: id}
+}
+
+func eventTime(ev event) time.Time {
+	return time.Unix(0, ev.OriginServerTS*int64(time.Millisecond))
+}
+
+// Convert a message event to a msg.Message
+func (m *Matrix) buildMessage(room string, ev event, c content) msg.Message {
+	text := stripReplyFallback(c.Body)
+	isAction := c.MsgType == "m.emote"
+	isCmd := false
+	if !isAction {
+		isCmd, text = bot.IsCmd(m.config.ForChannel(room), text)
+	}
+
+	thread := ""
+	if c.RelatesTo != nil && c.RelatesTo.RelType == "m.thread" {
+		thread = c.RelatesTo.EventID
+		m.mu.Lock()
+		m.threads[thread] = true
+		m.mu.Unlock()
+	}
+
+	return msg.Message{
+		User:     m.user(ev.Sender),
+		Body:     text,
+		Raw:      c.Body,
+		Channel:  room,
+		Command:  isCmd,
+		Action:   isAction,
+		Time:     eventTime(ev),
+		ID:       ev.EventID,
+		ThreadID: thread,
+	}
+}
+
+// stripReplyFallback removes the quote of the original message that clients
+// put at the top of replies.
+func stripReplyFallback(body string) string {
+	if !strings.HasPrefix(body, "> ") {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, ">") {
+			return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+		}
+	}
+	return body
+}
@@ -0,0 +1,303 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package matrix
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+)
+
+// fakeHomeserver is just enough of the client-server API to run the connector
+// against.
+type fakeHomeserver struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	sent  []map[string]interface{}
+	paths []string
+	syncs int
+	// limited is how many more requests to answer with M_LIMIT_EXCEEDED
+	limited int
+	// timeline is returned by the first incremental sync
+	timeline []event
+	done     chan bool
+}
+
+func newFakeHomeserver() *fakeHomeserver {
+	f := &fakeHomeserver{done: make(chan bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeHomeserver) Close() {
+	close(f.done)
+	f.Server.Close()
+}
+
+func (f *fakeHomeserver) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/"+clientPath)
+	body, _ := ioutil.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.paths = append(f.paths, r.Method+" "+path)
+	if f.limited > 0 {
+		f.limited--
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(APIError{Code: "M_LIMIT_EXCEEDED", Message: "Too many requests", RetryAfterMs: 250})
+		return
+	}
+	var resp interface{} = map[string]string{}
+	switch {
+	case strings.HasPrefix(path, "join/"):
+		resp = map[string]string{"room_id": "!room:example.org"}
+	case path == "sync":
+		f.syncs++
+		switch f.syncs {
+		case 1:
+			resp = map[string]string{"next_batch": "b1"}
+		case 2:
+			var s syncResponse
+			s.NextBatch = "b2"
+			s.Rooms.Join = map[string]struct {
+				Timeline struct {
+					Events []event `json:"events"`
+				} `json:"timeline"`
+			}{}
+			room := s.Rooms.Join["!room:example.org"]
+			room.Timeline.Events = f.timeline
+			s.Rooms.Join["!room:example.org"] = room
+			resp = s
+		default:
+			f.mu.Unlock()
+			<-f.done
+			return
+		}
+	case strings.Contains(path, "/send/"):
+		var c map[string]interface{}
+		json.Unmarshal(body, &c)
+		f.sent = append(f.sent, c)
+		resp = map[string]string{"event_id": "$sent"}
+	case strings.HasSuffix(path, "/joined_members"):
+		resp = map[string]interface{}{"joined": map[string]interface{}{
+			"@alice:example.org": map[string]string{},
+			"@bob:example.org":   map[string]string{},
+		}}
+	case path == "createRoom":
+		var c map[string]interface{}
+		json.Unmarshal(body, &c)
+		f.sent = append(f.sent, c)
+		resp = map[string]string{"room_id": "!dm:example.org"}
+	}
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestMatrix(f *fakeHomeserver) *Matrix {
+	c := &config.Config{}
+	c.Nick = "catbase"
+	c.Channels = []string{"#room:example.org"}
+	c.Matrix.Homeserver = f.URL
+	c.Matrix.UserID = "@catbase:example.org"
+	c.Matrix.AccessToken = "token"
+	m := New(c)
+	m.sleep = func(time.Duration) {}
+	return m
+}
+
+func textEvent(id, sender string, c interface{}) event {
+	raw, _ := json.Marshal(c)
+	return event{Type: "m.room.message", EventID: id, Sender: sender, Content: raw, OriginServerTS: 1500000000000}
+}
+
+func TestSyncDispatches(t *testing.T) {
+	f := newFakeHomeserver()
+	defer f.Close()
+
+	reaction, _ := json.Marshal(content{RelatesTo: &relatesTo{RelType: "m.annotation", EventID: "$1", Key: "👍"}})
+	f.timeline = []event{
+		textEvent("$0", "@catbase:example.org", content{MsgType: "m.text", Body: "myself"}),
+		textEvent("$1", "@alice:example.org", content{MsgType: "m.text", Body: "catbase: hello"}),
+		textEvent("$2", "@alice:example.org", content{MsgType: "m.emote", Body: "waves"}),
+		textEvent("$2a", "@otherbot:example.org", content{MsgType: "m.notice", Body: "catbase: beep"}),
+		textEvent("$3", "@bob:example.org", content{
+			MsgType:   "m.text",
+			Body:      "> <@alice:example.org> hello\n\nhi alice",
+			RelatesTo: &relatesTo{RelType: "m.thread", EventID: "$1"},
+		}),
+		textEvent("$4", "@alice:example.org", content{
+			MsgType:    "m.text",
+			Body:       "* thing++",
+			NewContent: &content{MsgType: "m.text", Body: "thing++"},
+			RelatesTo:  &relatesTo{RelType: "m.replace", EventID: "$1"},
+		}),
+		{Type: "m.reaction", EventID: "$5", Sender: "@bob:example.org", Content: reaction},
+	}
+
+	m := newTestMatrix(f)
+	messages := make(chan msg.Message, 10)
+	events := make(chan msg.Message, 10)
+	m.RegisterMessageReceived(func(ms msg.Message) { messages <- ms })
+	m.RegisterEventReceived(func(ms msg.Message) { events <- ms })
+	go m.Serve()
+
+	var got []msg.Message
+	for len(got) < 3 {
+		select {
+		case ms := <-messages:
+			got = append(got, ms)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+	assert.Equal(t, "hello", got[0].Body)
+	assert.True(t, got[0].Command)
+	assert.Equal(t, "@alice:example.org", got[0].User.Name)
+	assert.Equal(t, "!room:example.org", got[0].Channel)
+	assert.Equal(t, "waves", got[1].Body)
+	assert.True(t, got[1].Action)
+	assert.Equal(t, "hi alice", got[2].Body)
+	assert.Equal(t, "$1", got[2].ThreadID)
+
+	edit := <-events
+	assert.Equal(t, msg.MessageEdited, edit.Kind)
+	assert.Equal(t, "thing++", edit.Body)
+	assert.Equal(t, "$1", edit.Target)
+	react := <-events
+	assert.Equal(t, msg.ReactionAdded, react.Kind)
+	assert.Equal(t, "👍", react.Reaction)
+
+	// Config channels are sent to the room they joined
+	m.SendMessage("#room:example.org", "hi")
+	f.mu.Lock()
+	assert.Equal(t, "POST join/#room:example.org", f.paths[0])
+	assert.Contains(t, f.paths[len(f.paths)-1], "rooms/!room:example.org/send/m.room.message/")
+	f.mu.Unlock()
+}
+
+func inviteFrom(sender, alias string) invite {
+	var inv invite
+	me := "@catbase:example.org"
+	member, _ := json.Marshal(content{Membership: "invite"})
+	inv.InviteState.Events = []event{{Type: "m.room.member", Sender: sender, StateKey: &me, Content: member}}
+	if alias != "" {
+		raw, _ := json.Marshal(map[string]string{"alias": alias})
+		inv.InviteState.Events = append(inv.InviteState.Events, event{Type: "m.room.canonical_alias", Content: raw})
+	}
+	return inv
+}
+
+func TestInvitesFromAdminsOrToChannels(t *testing.T) {
+	f := newFakeHomeserver()
+	defer f.Close()
+	m := newTestMatrix(f)
+	m.config.Admins = []string{"@alice:example.org", "bob"}
+
+	var s syncResponse
+	s.Rooms.Invite = map[string]invite{
+		"!admin:example.org":    inviteFrom("@alice:example.org", ""),
+		"!stranger:example.org": inviteFrom("@mallory:example.org", ""),
+		"!evil:example.org":     inviteFrom("@alice:evil.example", ""),
+		"!bob:example.org":      inviteFrom("@bob:example.org", ""),
+		"!channel:example.org":  inviteFrom("@mallory:example.org", "#room:example.org"),
+	}
+	m.handleSync(s)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Contains(t, f.paths, "POST join/!admin:example.org")
+	assert.Contains(t, f.paths, "POST join/!channel:example.org")
+	assert.NotContains(t, f.paths, "POST join/!stranger:example.org")
+	assert.NotContains(t, f.paths, "POST join/!evil:example.org")
+	assert.NotContains(t, f.paths, "POST join/!bob:example.org")
+}
+
+func TestSending(t *testing.T) {
+	f := newFakeHomeserver()
+	defer f.Close()
+	m := newTestMatrix(f)
+	m.threads["$root"] = true
+
+	assert.Equal(t, "$sent", m.SendMessage("!r", msg.Bold("hi")+" <you>"))
+	m.SendAction("!r", "waves")
+	m.ReplyToMessageIdentifier("!r", "yes", "$root")
+	m.ReplyToMessageIdentifier("!r", "no", "$other")
+	m.React("!r", "+1", msg.Message{ID: "$root"})
+	m.Edit("!r", "fixed", "$other")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, "hi <you>", f.sent[0]["body"])
+	assert.Equal(t, "<strong>hi</strong> &lt;you&gt;", f.sent[0]["formatted_body"])
+	assert.Equal(t, "m.emote", f.sent[1]["msgtype"])
+	assert.Equal(t, map[string]interface{}{
+		"rel_type":        "m.thread",
+		"event_id":        "$root",
+		"is_falling_back": true,
+		"m.in_reply_to":   map[string]interface{}{"event_id": "$root"},
+	}, f.sent[2]["m.relates_to"])
+	assert.Equal(t, map[string]interface{}{
+		"m.in_reply_to": map[string]interface{}{"event_id": "$other"},
+	}, f.sent[3]["m.relates_to"])
+	assert.Equal(t, map[string]interface{}{
+		"rel_type": "m.annotation", "event_id": "$root", "key": "👍",
+	}, f.sent[4]["m.relates_to"])
+	assert.Equal(t, "* fixed", f.sent[5]["body"])
+	assert.Equal(t, "fixed", f.sent[5]["m.new_content"].(map[string]interface{})["body"])
+}
+
+func TestRenderNested(t *testing.T) {
+	assert.Equal(t, "<em>a &amp; 🍵</em>", renderHTML(msg.Italic("a & "+msg.Emoji("tea"))))
+	assert.Equal(t, "a & 🍵", renderPlain(msg.Italic("a & "+msg.Emoji("tea"))))
+}
+
+func TestWhoAndDM(t *testing.T) {
+	f := newFakeHomeserver()
+	defer f.Close()
+	m := newTestMatrix(f)
+
+	assert.ElementsMatch(t, []string{"alice", "bob"}, m.Who("!r"))
+
+	room, err := m.OpenDM(user.User{Name: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, "!dm:example.org", room)
+	f.mu.Lock()
+	assert.Equal(t, []interface{}{"@alice:example.org"}, f.sent[0]["invite"])
+	f.mu.Unlock()
+
+	_, err = m.OpenDM(user.User{Name: "@carol:other.example"})
+	assert.Nil(t, err)
+	f.mu.Lock()
+	assert.Equal(t, []interface{}{"@carol:other.example"}, f.sent[1]["invite"])
+	f.mu.Unlock()
+}
+
+func TestRateLimitRetries(t *testing.T) {
+	f := newFakeHomeserver()
+	defer f.Close()
+	m := newTestMatrix(f)
+	var slept []time.Duration
+	m.sleep = func(wait time.Duration) { slept = append(slept, wait) }
+
+	f.limited = 1
+	assert.Equal(t, "$sent", m.SendMessage("!r", "hi"))
+	assert.Equal(t, []time.Duration{250 * time.Millisecond}, slept)
+
+	f.limited = maxRetries + 1
+	err := m.api("GET", "rooms/!r/joined_members", nil, nil, nil)
+	if apiErr, ok := err.(*APIError); assert.True(t, ok) {
+		assert.Equal(t, "M_LIMIT_EXCEEDED", apiErr.Code)
+		assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	}
+}
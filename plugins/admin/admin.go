@@ -5,6 +5,7 @@ package admin
 import (
 	"log"
 	"math/rand"
+	"net/http"
 	"strings"
 	"time"
 
@@ -40,6 +41,14 @@ func (p *AdminPlugin) Message(message msg.Message) bool {
 		return p.handleVariables(message)
 	}
 
+	if message.Command && p.handleConfig(message) {
+		return true
+	}
+
+	if message.Command && p.handleBackup(message) {
+		return true
+	}
+
 	return false
 }
 
@@ -50,10 +59,10 @@ func (p *AdminPlugin) handleVariables(message msg.Message) bool {
 
 		_, err := p.db.Exec(`delete from variables where name=? and value=?`, variable, value)
 		if err != nil {
-			p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.")
+			p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.", message)
 			log.Println("[admin]: ", err)
 		} else {
-			p.Bot.SendMessage(message.Channel, "Removed.")
+			p.Bot.SendMessage(message.Channel, "Removed.", message)
 		}
 
 		return true
@@ -71,21 +80,21 @@ func (p *AdminPlugin) handleVariables(message msg.Message) bool {
 	row := p.db.QueryRow(`select count(*) from variables where value = ?`, variable, value)
 	err := row.Scan(&count)
 	if err != nil {
-		p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.")
+		p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.", message)
 		log.Println("[admin]: ", err)
 		return true
 	}
 
 	if count > 0 {
-		p.Bot.SendMessage(message.Channel, "I've already got that one.")
+		p.Bot.SendMessage(message.Channel, "I've already got that one.", message)
 	} else {
 		_, err := p.db.Exec(`INSERT INTO variables (name, value) VALUES (?, ?)`, variable, value)
 		if err != nil {
-			p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.")
+			p.Bot.SendMessage(message.Channel, "I'm broke and need attention in my variable creation code.", message)
 			log.Println("[admin]: ", err)
 			return true
 		}
-		p.Bot.SendMessage(message.Channel, "Added.")
+		p.Bot.SendMessage(message.Channel, "Added.", message)
 	}
 	return true
 }
@@ -99,7 +108,16 @@ func (p *AdminPlugin) LoadData() {
 }
 
 // Help responds to help requests. Every plugin must implement a help function.
+// The parts are the whole request, like "help admin config".
 func (p *AdminPlugin) Help(channel string, parts []string) {
+	if len(parts) > 2 && strings.ToLower(parts[2]) == "config" {
+		p.Bot.SendMessage(channel, configHelp)
+		return
+	}
+	if len(parts) > 2 && strings.ToLower(parts[2]) == "backup" {
+		p.Bot.SendMessage(channel, backupHelp)
+		return
+	}
 	p.Bot.SendMessage(channel, "This does super secret things that you're not allowed to know about.")
 }
 
@@ -115,7 +133,9 @@ func (p *AdminPlugin) BotMessage(message msg.Message) bool {
 
 // Register any web URLs desired
 func (p *AdminPlugin) RegisterWeb() *string {
-	return nil
+	http.HandleFunc("/config", p.serveConfig)
+	tmp := "/config"
+	return &tmp
 }
 
 func (p *AdminPlugin) ReplyMessage(message msg.Message, identifier string) bool { return false }
@@ -0,0 +1,142 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package admin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+// adminBot makes everyone an admin.
+type adminBot struct {
+	*bot.MockBot
+}
+
+func (b adminBot) CheckAdmin(string) bool { return true }
+
+func makeMessage(payload string) msg.Message {
+	isCmd := strings.HasPrefix(payload, "!")
+	if isCmd {
+		payload = payload[1:]
+	}
+	return msg.Message{
+		User:    &user.User{Name: "tester"},
+		Channel: "#test",
+		Body:    payload,
+		Command: isCmd,
+	}
+}
+
+func makePlugin(t *testing.T) (*AdminPlugin, *bot.MockBot) {
+	mb := bot.NewMockBot()
+	mb.Cfg.Emojify.Chance = 0.02
+	p := New(adminBot{mb})
+	assert.NotNil(t, p)
+	return p, mb
+}
+
+func TestConfigCommands(t *testing.T) {
+	p, mb := makePlugin(t)
+	assert.True(t, p.Message(makeMessage("!config set emojify.chance 0.5")))
+	assert.Equal(t, 0.5, mb.Config().Emojify.Chance)
+	assert.True(t, p.Message(makeMessage("!config set here Emojify.Chance 1")))
+	assert.Equal(t, 0.5, mb.Config().Emojify.Chance)
+	assert.True(t, p.Message(makeMessage("!config get Emojify.Chance")))
+	assert.True(t, p.Message(makeMessage("!config unset Emojify.Chance")))
+	assert.Equal(t, 0.02, mb.Config().Emojify.Chance)
+	assert.True(t, p.Message(makeMessage("!config set Emojify.Chance 7")))
+	assert.Equal(t, []string{
+		"Okay, Emojify.Chance is 0.5 everywhere.",
+		"Okay, Emojify.Chance is 1 here.",
+		"Emojify.Chance is 1 (set for this channel)",
+		"Okay, Emojify.Chance is 0.02 everywhere.",
+		"Emojify.Chance: 7 is not a probability between 0 and 1",
+	}, mb.Messages)
+}
+
+func TestConfigValueIsTheRest(t *testing.T) {
+	p, mb := makePlugin(t)
+	assert.True(t, p.Message(makeMessage("!config  set here\tNick   Nick  the  cat ")))
+	assert.Equal(t, "Nick  the  cat", mb.Config().ForChannel("#test").Nick)
+}
+
+func TestConfigNeedsAdmin(t *testing.T) {
+	mb := bot.NewMockBot()
+	p := New(mb)
+	assert.True(t, p.Message(makeMessage("!config set Emojify.Chance 0.5")))
+	assert.Equal(t, 0.0, mb.Config().Emojify.Chance)
+	assert.False(t, p.Message(makeMessage("config set Emojify.Chance 0.5")))
+}
+
+func TestConfigHelp(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Help("test", []string{"help", "admin", "config"})
+	assert.Equal(t, []string{configHelp}, mb.Messages)
+}
+
+func TestConfigWeb(t *testing.T) {
+	p, mb := makePlugin(t)
+	post := func(form url.Values) int {
+		req := httptest.NewRequest("POST", "/config", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		p.serveConfig(w, req)
+		return w.Code
+	}
+	form := url.Values{"path": {"Emojify.Chance"}, "value": {"0.5"}, "token": {"hunter2"}}
+	assert.Equal(t, http.StatusForbidden, post(form))
+
+	mb.Cfg.ConfigToken = "hunter2"
+	assert.Equal(t, http.StatusOK, post(form))
+	assert.Equal(t, 0.5, mb.Config().Emojify.Chance)
+
+	w := httptest.NewRecorder()
+	p.serveConfig(w, httptest.NewRequest("GET", "/config", nil))
+	assert.Contains(t, w.Body.String(), "Emojify.Chance")
+	assert.NotContains(t, w.Body.String(), "hunter2")
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catbase")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "backups")
+	p, mb := makePlugin(t)
+	mb.Cfg.DB.BackupDir = dir
+
+	assert.True(t, p.Message(makeMessage("!export zork")))
+	assert.Contains(t, mb.Messages[0], "zork has no data to export")
+	files, _ := filepath.Glob(filepath.Join(dir, "catbase-*.json"))
+	assert.Empty(t, files)
+
+	assert.True(t, p.Message(makeMessage("!export inventory")))
+	files, _ = filepath.Glob(filepath.Join(dir, "catbase-*.json"))
+	if assert.Len(t, files, 1) && assert.Len(t, mb.Messages, 2) {
+		assert.Equal(t, "Saved to "+files[0]+".", mb.Messages[1])
+	}
+}
+
+func TestBackupHelp(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Help("test", []string{"help", "admin", "backup"})
+	assert.Equal(t, []string{backupHelp}, mb.Messages)
+}
+
+func TestBackupNeedsAdmin(t *testing.T) {
+	mb := bot.NewMockBot()
+	p := New(mb)
+	assert.True(t, p.Message(makeMessage("!backup")))
+	assert.Equal(t, []string{"You're not the boss of me."}, mb.Messages)
+	assert.False(t, p.Message(makeMessage("!backup my hard drive")))
+}
@@ -0,0 +1,78 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package admin
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
+)
+
+const backupHelp = "backup copies my database and export [plugin...] saves plugins' data " +
+	"(fact, counter, babbler, reminder, inventory, first) as JSON, both next to the database."
+
+// handleBackup runs the backup commands:
+//
+//	backup
+//	export [plugin...]
+func (p *AdminPlugin) handleBackup(message msg.Message) bool {
+	parts := strings.Fields(strings.ToLower(message.Body))
+	if len(parts) == 0 || (parts[0] != "backup" && parts[0] != "export") {
+		return false
+	}
+	if parts[0] == "backup" && len(parts) > 1 {
+		return false
+	}
+	if !p.Bot.CheckAdmin(message.User.Name) {
+		p.Bot.SendMessage(message.Channel, "You're not the boss of me.", message)
+		return true
+	}
+
+	path, err := p.save(parts[0], parts[1:])
+	if err != nil {
+		log.Printf("[admin]: %s failed: %s", parts[0], err)
+		p.Bot.SendMessage(message.Channel, fmt.Sprintf("Couldn't %s: %s", parts[0], err), message)
+		return true
+	}
+	p.Bot.SendMessage(message.Channel, "Saved to "+path+".", message)
+	return true
+}
+
+// save backs up or exports to a new file in the backup directory, which is
+// made if need be, and returns its path.
+func (p *AdminPlugin) save(command string, plugins []string) (string, error) {
+	dir := p.Bot.Config().BackupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	stamp := time.Now().Format("20060102-150405")
+	if command == "backup" {
+		path := filepath.Join(dir, "catbase-"+stamp+".db")
+		return path, database.Backup(p.db, path)
+	}
+	path := filepath.Join(dir, "catbase-"+stamp+".json")
+	return path, exportFile(p, path, plugins)
+}
+
+func exportFile(p *AdminPlugin, path string, plugins []string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := database.ExportJSON(p.db, f, plugins...); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package admin
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+const configHelp = "config get <setting>, config set [here] <setting> <value> and " +
+	"config unset [here] <setting> change settings like Emojify.Chance while I'm running. " +
+	"With here the change is only for this channel. Lists are separated by commas."
+
+// handleConfig runs the config commands:
+//
+//	config get <path>
+//	config set [here] <path> <value>
+//	config unset [here] <path>
+func (p *AdminPlugin) handleConfig(message msg.Message) bool {
+	parts := strings.Fields(message.Body)
+	if len(parts) < 2 || strings.ToLower(parts[0]) != "config" {
+		return false
+	}
+	verb, args := strings.ToLower(parts[1]), parts[2:]
+	c := p.Bot.Config()
+
+	if verb == "get" {
+		if len(args) != 1 {
+			p.Bot.SendMessage(message.Channel, "Usage: config get <setting>", message)
+			return true
+		}
+		s, err := c.Get(message.Channel, args[0])
+		if err != nil {
+			p.Bot.SendMessage(message.Channel, err.Error(), message)
+			return true
+		}
+		p.Bot.SendMessage(message.Channel, fmt.Sprintf("%s is %s (%s)", s.Path, s.Value, describeSource(s.Source)), message)
+		return true
+	}
+	if verb != "set" && verb != "unset" {
+		return false
+	}
+
+	if !p.Bot.CheckAdmin(message.User.Name) {
+		p.Bot.SendMessage(message.Channel, "You're not the boss of me.", message)
+		return true
+	}
+	channel, where := "", "everywhere"
+	if len(args) > 0 && strings.ToLower(args[0]) == "here" {
+		channel, where, args = message.Channel, "here", args[1:]
+	}
+
+	var err error
+	switch {
+	case verb == "set" && len(args) >= 2:
+		// the value is the rest of the message, spaces and all
+		value := afterFields(message.Body, len(parts)-len(args)+1)
+		err = c.Set(channel, args[0], value)
+	case verb == "unset" && len(args) == 1:
+		err = c.Unset(channel, args[0])
+	default:
+		p.Bot.SendMessage(message.Channel, "Usage: "+configHelp, message)
+		return true
+	}
+	if err != nil {
+		p.Bot.SendMessage(message.Channel, err.Error(), message)
+		return true
+	}
+	s, _ := c.Get(channel, args[0])
+	p.Bot.SendMessage(message.Channel, fmt.Sprintf("Okay, %s is %s %s.", s.Path, s.Value, where), message)
+	return true
+}
+
+// afterFields returns what's left of s after its first n fields, trimmed.
+func afterFields(s string, n int) string {
+	for i := 0; i < n; i++ {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if end := strings.IndexFunc(s, unicode.IsSpace); end >= 0 {
+			s = s[end:]
+		} else {
+			s = ""
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+func describeSource(source string) string {
+	switch source {
+	case "channel":
+		return "set for this channel"
+	case "everywhere":
+		return "set everywhere"
+	}
+	return "from the config file"
+}
+
+var configIndex = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Settings</title>
+		<link rel="stylesheet" href="http://yui.yahooapis.com/pure/0.1.0/pure-min.css">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+	</head>
+	<body style="padding: 1em;">
+	<form class="pure-form" method="GET">
+		<input type="text" name="channel" placeholder="Channel" value="{{.Channel}}">
+		<button type="submit" class="pure-button">Show</button>
+	</form>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	{{if .Editable}}
+	<form class="pure-form" method="POST" style="padding-top: 1em;">
+		<input type="hidden" name="channel" value="{{.Channel}}">
+		<input type="text" name="path" placeholder="Setting">
+		<input type="text" name="value" placeholder="Value">
+		<input type="password" name="token" placeholder="Token">
+		<button type="submit" name="action" value="set" class="pure-button pure-button-primary">Set</button>
+		<button type="submit" name="action" value="unset" class="pure-button">Unset</button>
+		{{if .Channel}}for {{.Channel}} only{{else}}everywhere{{end}}
+	</form>
+	{{end}}
+	<div style="padding-top: 1em;">
+		<table class="pure-table">
+			<thead>
+				<tr>
+					<th>Setting</th>
+					<th>Value</th>
+					<th>From</th>
+				</tr>
+			</thead>
+			<tbody>
+				{{range .Settings}}
+				<tr>
+					<td>{{.Path}}</td>
+					<td>{{.Value}}</td>
+					<td>{{.Source}}</td>
+				</tr>
+				{{end}}
+			</tbody>
+		</table>
+	</div>
+	</body>
+</html>
+`
+
+func (p *AdminPlugin) serveConfig(w http.ResponseWriter, r *http.Request) {
+	c := p.Bot.Config()
+	context := make(map[string]interface{})
+	channel := r.FormValue("channel")
+	context["Channel"] = channel
+	context["Editable"] = c.ConfigToken != ""
+
+	if r.Method == http.MethodPost {
+		status, err := http.StatusBadRequest, error(nil)
+		switch {
+		case c.ConfigToken == "" ||
+			subtle.ConstantTimeCompare([]byte(r.FormValue("token")), []byte(c.ConfigToken)) != 1:
+			status, err = http.StatusForbidden, fmt.Errorf("That's not the token.")
+		case r.FormValue("action") == "unset":
+			err = c.Unset(channel, r.FormValue("path"))
+		default:
+			err = c.Set(channel, r.FormValue("path"), r.FormValue("value"))
+		}
+		if err != nil {
+			w.WriteHeader(status)
+			context["Error"] = err.Error()
+		}
+	}
+
+	context["Settings"] = c.Settings(channel)
+	t, err := template.New("configIndex").Parse(configIndex)
+	if err != nil {
+		log.Println(err)
+	}
+	t.Execute(w, context)
+}
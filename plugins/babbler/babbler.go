@@ -14,6 +14,7 @@ import (
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
 )
 
 var (
@@ -56,21 +57,21 @@ type BabblerArc struct {
 func New(bot bot.Bot) *BabblerPlugin {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	if _, err := bot.DB().Exec(`create table if not exists babblers (
+	if err := database.CreateTable(bot.DB(), `create table if not exists babblers (
 			id integer primary key,
 			babbler string
 		);`); err != nil {
 		log.Fatal(err)
 	}
 
-	if _, err := bot.DB().Exec(`create table if not exists babblerWords (
+	if err := database.CreateTable(bot.DB(), `create table if not exists babblerWords (
 			id integer primary key,
 			word string
 		);`); err != nil {
 		log.Fatal(err)
 	}
 
-	if _, err := bot.DB().Exec(`create table if not exists babblerNodes (
+	if err := database.CreateTable(bot.DB(), `create table if not exists babblerNodes (
 			id integer primary key,
 			babblerId integer,
 			wordId integer,
@@ -80,10 +81,10 @@ func New(bot bot.Bot) *BabblerPlugin {
 		log.Fatal(err)
 	}
 
-	if _, err := bot.DB().Exec(`create table if not exists babblerArcs (
+	if err := database.CreateTable(bot.DB(), `create table if not exists babblerArcs (
 			id integer primary key,
 			fromNodeId integer,
-			toNodeId interger,
+			toNodeId integer,
 			frequency integer
 		);`); err != nil {
 		log.Fatal(err)
@@ -142,7 +143,7 @@ func (p *BabblerPlugin) Message(message msg.Message) bool {
 	}
 
 	if saidSomething {
-		p.Bot.SendMessage(message.Channel, saidWhat)
+		p.Bot.SendMessage(message.Channel, saidWhat, message)
 	}
 	return saidSomething
 }
@@ -156,7 +157,10 @@ func (p *BabblerPlugin) Help(channel string, parts []string) {
 		"seabass says-middle-out ...",
 		"seabass says-bridge ... | ...",
 	}
-	p.Bot.SendMessage(channel, strings.Join(commands, "\n\n"))
+	for i := range commands {
+		commands[i] = msg.Code(commands[i])
+	}
+	p.Bot.SendRich(channel, msg.Section(strings.Join(commands, "\n")))
 }
 
 func (p *BabblerPlugin) Event(kind string, message msg.Message) bool {
@@ -172,13 +176,8 @@ func (p *BabblerPlugin) RegisterWeb() *string {
 }
 
 func (p *BabblerPlugin) makeBabbler(name string) (*Babbler, error) {
-	res, err := p.db.Exec(`insert into babblers (babbler) values (?);`, name)
+	id, err := database.InsertID(p.db, `insert into babblers (babbler) values (?);`, name)
 	if err == nil {
-		id, err := res.LastInsertId()
-		if err != nil {
-			log.Print(err)
-			return nil, err
-		}
 		return &Babbler{
 			BabblerId: id,
 			Name:      name,
@@ -254,12 +253,7 @@ func (p *BabblerPlugin) getWord(word string) (*BabblerWord, error) {
 }
 
 func (p *BabblerPlugin) createNewWord(word string) (*BabblerWord, error) {
-	res, err := p.db.Exec(`insert into babblerWords (word) values (?);`, word)
-	if err != nil {
-		log.Print(err)
-		return nil, err
-	}
-	id, err := res.LastInsertId()
+	id, err := database.InsertID(p.db, `insert into babblerWords (word) values (?);`, word)
 	if err != nil {
 		log.Print(err)
 		return nil, err
@@ -305,13 +299,7 @@ func (p *BabblerPlugin) createBabblerNode(babbler *Babbler, word string) (*Babbl
 		return nil, err
 	}
 
-	res, err := p.db.Exec(`insert into babblerNodes (babblerId, wordId, root, rootFrequency) values (?, ?, 0, 0)`, babbler.BabblerId, w.WordId)
-	if err != nil {
-		log.Print(err)
-		return nil, err
-	}
-
-	id, err := res.LastInsertId()
+	id, err := database.InsertID(p.db, `insert into babblerNodes (babblerId, wordId, root, rootFrequency) values (?, ?, 0, 0)`, babbler.BabblerId, w.WordId)
 	if err != nil {
 		log.Print(err)
 		return nil, err
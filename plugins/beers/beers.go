@@ -17,6 +17,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 	"github.com/velour/catbase/plugins/counter"
 )
 
@@ -38,7 +39,7 @@ type untappdUser struct {
 // NewBeersPlugin creates a new BeersPlugin with the Plugin interface
 func New(bot bot.Bot) *BeersPlugin {
 	if bot.DBVersion() == 1 {
-		if _, err := bot.DB().Exec(`create table if not exists untappd (
+		if err := database.CreateTable(bot.DB(), `create table if not exists untappd (
 			id integer primary key,
 			untappdUser string,
 			channel string,
@@ -82,61 +83,61 @@ func (p *BeersPlugin) Message(message msg.Message) bool {
 			count, err := strconv.Atoi(parts[2])
 			if err != nil {
 				// if it's not a number, maybe it's a nick!
-				p.Bot.SendMessage(channel, "Sorry, that didn't make any sense.")
+				p.Bot.SendMessage(channel, "Sorry, that didn't make any sense.", message)
 			}
 
 			if count < 0 {
 				// you can't be negative
 				msg := fmt.Sprintf("Sorry %s, you can't have negative beers!", nick)
-				p.Bot.SendMessage(channel, msg)
+				p.Bot.SendMessage(channel, msg, message)
 				return true
 			}
 			if parts[1] == "+=" {
 				p.addBeers(nick, count)
-				p.randomReply(channel)
+				p.randomReply(message)
 			} else if parts[1] == "=" {
 				if count == 0 {
-					p.puke(nick, channel)
+					p.puke(nick, message)
 				} else {
 					p.setBeers(nick, count)
-					p.randomReply(channel)
+					p.randomReply(message)
 				}
 			} else {
-				p.Bot.SendMessage(channel, "I don't know your math.")
+				p.Bot.SendMessage(channel, "I don't know your math.", message)
 			}
 		} else if len(parts) == 2 {
 			if p.doIKnow(parts[1]) {
-				p.reportCount(parts[1], channel, false)
+				p.reportCount(parts[1], message, false)
 			} else {
 				msg := fmt.Sprintf("Sorry, I don't know %s.", parts[1])
-				p.Bot.SendMessage(channel, msg)
+				p.Bot.SendMessage(channel, msg, message)
 			}
 		} else if len(parts) == 1 {
-			p.reportCount(nick, channel, true)
+			p.reportCount(nick, message, true)
 		}
 
 		// no matter what, if we're in here, then we've responded
 		return true
 	} else if parts[0] == "beers--" {
 		p.addBeers(nick, -1)
-		p.Bot.SendAction(channel, "flushes")
+		p.Bot.SendAction(channel, "flushes", message)
 		return true
 	} else if parts[0] == "beers++" {
 		p.addBeers(nick, 1)
-		p.randomReply(channel)
+		p.randomReply(message)
 		return true
 	} else if parts[0] == "bourbon++" {
 		p.addBeers(nick, 2)
-		p.randomReply(channel)
+		p.randomReply(message)
 		return true
 	} else if parts[0] == "puke" {
-		p.puke(nick, channel)
+		p.puke(nick, message)
 		return true
 	}
 
 	if message.Command && parts[0] == "imbibe" {
 		p.addBeers(nick, 1)
-		p.randomReply(channel)
+		p.randomReply(message)
 		return true
 	}
 
@@ -145,7 +146,7 @@ func (p *BeersPlugin) Message(message msg.Message) bool {
 		channel := message.Channel
 
 		if len(parts) < 2 {
-			p.Bot.SendMessage(channel, "You must also provide a user name.")
+			p.Bot.SendMessage(channel, "You must also provide a user name.", message)
 		} else if len(parts) == 3 {
 			chanNick = parts[2]
 		} else if len(parts) == 4 {
@@ -167,7 +168,7 @@ func (p *BeersPlugin) Message(message msg.Message) bool {
 			log.Println("Error registering untappd: ", err)
 		}
 		if count > 0 {
-			p.Bot.SendMessage(channel, "I'm already watching you.")
+			p.Bot.SendMessage(message.Channel, "I'm already watching you.", message)
 			return true
 		}
 		_, err = p.db.Exec(`insert into untappd (
@@ -183,11 +184,11 @@ func (p *BeersPlugin) Message(message msg.Message) bool {
 		)
 		if err != nil {
 			log.Println("Error registering untappd: ", err)
-			p.Bot.SendMessage(channel, "I can't see.")
+			p.Bot.SendMessage(message.Channel, "I can't see.", message)
 			return true
 		}
 
-		p.Bot.SendMessage(channel, "I'll be watching you.")
+		p.Bot.SendMessage(message.Channel, "I'll be watching you.", message)
 
 		p.checkUntappd(channel)
 
@@ -249,7 +250,7 @@ func (p *BeersPlugin) getBeers(nick string) int {
 	return ub.Count
 }
 
-func (p *BeersPlugin) reportCount(nick, channel string, himself bool) {
+func (p *BeersPlugin) reportCount(nick string, message msg.Message, himself bool) {
 	beers := p.getBeers(nick)
 	msg := fmt.Sprintf("%s has had %d beers so far.", nick, beers)
 	if himself {
@@ -259,13 +260,13 @@ func (p *BeersPlugin) reportCount(nick, channel string, himself bool) {
 			msg = fmt.Sprintf("You've had %d beers so far, %s.", beers, nick)
 		}
 	}
-	p.Bot.SendMessage(channel, msg)
+	p.Bot.SendMessage(message.Channel, msg, message)
 }
 
-func (p *BeersPlugin) puke(user string, channel string) {
+func (p *BeersPlugin) puke(user string, message msg.Message) {
 	p.setBeers(user, 0)
 	msg := fmt.Sprintf("Ohhhhhh, and a reversal of fortune for %s!", user)
-	p.Bot.SendMessage(channel, msg)
+	p.Bot.SendMessage(message.Channel, msg, message)
 }
 
 func (p *BeersPlugin) doIKnow(nick string) bool {
@@ -277,10 +278,10 @@ func (p *BeersPlugin) doIKnow(nick string) bool {
 	return count > 0
 }
 
-// Sends random affirmation to the channel. This could be better (with a datastore for sayings)
-func (p *BeersPlugin) randomReply(channel string) {
+// Sends random affirmation in answer to message. This could be better (with a datastore for sayings)
+func (p *BeersPlugin) randomReply(message msg.Message) {
 	replies := []string{"ZIGGY! ZAGGY!", "HIC!", "Stay thirsty, my friend!"}
-	p.Bot.SendMessage(channel, replies[rand.Intn(len(replies))])
+	p.Bot.SendMessage(message.Channel, replies[rand.Intn(len(replies))], message)
 }
 
 type checkin struct {
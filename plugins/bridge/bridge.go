@@ -0,0 +1,202 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+)
+
+// maxRelayed is how many relayed messages we remember for relaying their
+// edits and reactions.
+const maxRelayed = 1000
+
+// BridgePlugin mirrors messages between channels, usually on different
+// networks.
+type BridgePlugin struct {
+	Bot bot.Bot
+
+	// links maps each bridged channel to the channels it relays to
+	links map[string][]string
+
+	mu sync.Mutex
+	// relayed maps channel and ID of a message to the IDs of its copies by
+	// the channel they were sent to
+	relayed map[string]map[string]string
+	// order is the keys of relayed, oldest first
+	order []string
+	// ours is the channels and IDs of the copies, so we never relay them
+	// back
+	ours map[string]bool
+}
+
+// New creates a BridgePlugin from the bridge configuration.
+func New(b bot.Bot) *BridgePlugin {
+	links := map[string][]string{}
+	for _, group := range b.Config().Bridge.Channels {
+		for _, from := range group {
+			for _, to := range group {
+				if to != from {
+					links[from] = append(links[from], to)
+				}
+			}
+		}
+	}
+	return &BridgePlugin{
+		Bot:     b,
+		links:   links,
+		relayed: map[string]map[string]string{},
+		ours:    map[string]bool{},
+	}
+}
+
+func key(channel, id string) string {
+	return channel + " " + id
+}
+
+// fromUs reports whether a message is one of the bot's own, which includes
+// everything we relayed.
+func (p *BridgePlugin) fromUs(message msg.Message) bool {
+	if message.User != nil && message.User.Name == p.Bot.Config().Nick {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return message.ID != "" && p.ours[key(message.Channel, message.ID)]
+}
+
+// text is what was said, with the command prefix the connector took off
+// commands put back.
+func text(message msg.Message) string {
+	if message.Command && message.Raw != "" {
+		return message.Raw
+	}
+	return message.Body
+}
+
+// said is how a message reads once relayed: every line is marked with who
+// said it, so that connectors which send lines on their own, like IRC, don't
+// leave all but the first anonymous.
+func said(message msg.Message) string {
+	format := "<%s> %s"
+	if message.Action {
+		format = "%s %s"
+	}
+	lines := strings.Split(text(message), "\n")
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf(format, message.User.Name, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// remember records the copies of a message so that edits and reactions can
+// follow it.
+func (p *BridgePlugin) remember(channel, id string, copies map[string]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for to, c := range copies {
+		p.ours[key(to, c)] = true
+	}
+	if id == "" {
+		return
+	}
+	k := key(channel, id)
+	p.relayed[k] = copies
+	p.order = append(p.order, k)
+	if len(p.order) > maxRelayed {
+		for to, c := range p.relayed[p.order[0]] {
+			delete(p.ours, key(to, c))
+		}
+		delete(p.relayed, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+func (p *BridgePlugin) copies(channel, id string) map[string]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.relayed[key(channel, id)]
+}
+
+// relay sends a message to every channel bridged with its own.
+func (p *BridgePlugin) relay(message msg.Message) {
+	targets := p.links[message.Channel]
+	if len(targets) == 0 || message.User == nil || p.fromUs(message) {
+		return
+	}
+	copies := map[string]string{}
+	for _, to := range targets {
+		var id string
+		if message.Action {
+			id = p.Bot.SendAction(to, said(message))
+		} else {
+			id = p.Bot.SendMessage(to, said(message))
+		}
+		if id != "" && id != bot.NoIdentifier {
+			copies[to] = id
+		}
+	}
+	p.remember(message.Channel, message.ID, copies)
+}
+
+// Message relays messages from bridged channels. It never handles them, so
+// the rest of the plugins see them too.
+func (p *BridgePlugin) Message(message msg.Message) bool {
+	// ReplyMessage has already seen these
+	if !message.InThread() {
+		p.relay(message)
+	}
+	return false
+}
+
+// ReplyMessage relays messages in threads, which the bridged channels don't
+// share, into the channel.
+func (p *BridgePlugin) ReplyMessage(message msg.Message, identifier string) bool {
+	p.relay(message)
+	return false
+}
+
+// Event relays edits and reactions of relayed messages, if configured to.
+func (p *BridgePlugin) Event(kind string, message msg.Message) bool {
+	if message.User == nil || p.fromUs(message) {
+		return false
+	}
+	cfg := p.Bot.Config().Bridge
+	switch kind {
+	case msg.MessageEdited:
+		if !cfg.Edits {
+			return false
+		}
+		for to, id := range p.copies(message.Channel, message.Target) {
+			if message.Action {
+				p.Bot.Edit(to, msg.Italic(said(message)), id)
+			} else {
+				p.Bot.Edit(to, said(message), id)
+			}
+		}
+	case msg.ReactionAdded:
+		if !cfg.Reactions {
+			return false
+		}
+		for to, id := range p.copies(message.Channel, message.Target) {
+			p.Bot.React(to, message.Reaction, msg.Message{ID: id, Channel: to})
+		}
+	}
+	return false
+}
+
+func (p *BridgePlugin) BotMessage(message msg.Message) bool { return false }
+
+func (p *BridgePlugin) Help(channel string, parts []string) {
+	if len(p.links[channel]) == 0 {
+		p.Bot.SendMessage(channel, "This channel isn't bridged to any others.")
+		return
+	}
+	p.Bot.SendMessage(channel, "Messages in this channel are mirrored to: "+strings.Join(p.links[channel], ", "))
+}
+
+func (p *BridgePlugin) RegisterWeb() *string { return nil }
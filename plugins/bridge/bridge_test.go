@@ -0,0 +1,115 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+func makeMessage(channel, id, payload string) msg.Message {
+	return msg.Message{
+		ID:      id,
+		User:    &user.User{Name: "tester"},
+		Channel: channel,
+		Body:    payload,
+	}
+}
+
+func makePlugin(t *testing.T) (*BridgePlugin, *bot.MockBot) {
+	mb := bot.NewMockBot()
+	mb.Cfg.Nick = "catbase"
+	mb.Cfg.Bridge.Channels = [][]string{{"irc:#cats", "slack:C1"}}
+	mb.Cfg.Bridge.Edits = true
+	mb.Cfg.Bridge.Reactions = true
+	p := New(mb)
+	assert.NotNil(t, p)
+	return p, mb
+}
+
+func TestRelaysMessagesAndActions(t *testing.T) {
+	p, mb := makePlugin(t)
+	assert.False(t, p.Message(makeMessage("irc:#cats", "1", "hello")))
+	action := makeMessage("slack:C1", "2", "waves")
+	action.Action = true
+	assert.False(t, p.Message(action))
+	assert.False(t, p.Message(makeMessage("irc:#dogs", "3", "woof")))
+	assert.Equal(t, []string{"<tester> hello"}, mb.Messages)
+	assert.Equal(t, []string{"tester waves"}, mb.Actions)
+}
+
+func TestRelaysEveryLineAsSaid(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Message(makeMessage("irc:#cats", "1", "roses are red\nviolets are blue"))
+	assert.Equal(t, []string{"<tester> roses are red\n<tester> violets are blue"}, mb.Messages)
+}
+
+func TestNoLoops(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Message(makeMessage("irc:#cats", "1", "hello"))
+	// our copy coming back from the other side
+	p.Message(makeMessage("slack:C1", "m-0", "<tester> hello"))
+	own := makeMessage("slack:C1", "9", "hi")
+	own.User.Name = "catbase"
+	p.Message(own)
+	assert.Len(t, mb.Messages, 1)
+}
+
+func TestRelaysEditsAndReactions(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Message(makeMessage("irc:#cats", "1", "hello"))
+
+	edit := makeMessage("irc:#cats", "1", "hello there")
+	edit.Kind = msg.MessageEdited
+	edit.Target = "1"
+	p.Event(edit.Kind, edit)
+	assert.Equal(t, []string{"<tester> hello there"}, mb.Messages)
+
+	react := makeMessage("irc:#cats", "", "")
+	react.Kind = msg.ReactionAdded
+	react.Target = "1"
+	react.Reaction = "cat"
+	p.Event(react.Kind, react)
+	assert.Equal(t, []string{"cat m-0"}, mb.Reactions)
+}
+
+func TestRelaysCommandsAsSaid(t *testing.T) {
+	p, mb := makePlugin(t)
+	cmd := makeMessage("irc:#cats", "1", "remember that")
+	cmd.Command = true
+	cmd.Raw = "catbase: remember that"
+	p.Message(cmd)
+	assert.Equal(t, []string{"<tester> catbase: remember that"}, mb.Messages)
+}
+
+func TestOnlyOurCopiesAreIgnored(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Message(makeMessage("irc:#cats", "1", "hello"))
+	// the same ID as our copy, but on the other network
+	p.Message(makeMessage("irc:#cats", "m-0", "hi"))
+	assert.Len(t, mb.Messages, 2)
+}
+
+// ircBot sends messages the way IRC does, without IDs.
+type ircBot struct {
+	*bot.MockBot
+}
+
+func (b ircBot) SendMessage(ch, message string, replyTo ...msg.Message) string {
+	b.MockBot.SendMessage(ch, message, replyTo...)
+	return bot.NoIdentifier
+}
+
+func TestPlaceholderIDsAreNotOurs(t *testing.T) {
+	mb := bot.NewMockBot()
+	mb.Cfg.Bridge.Channels = [][]string{{"irc:#cats", "irc:#kittens"}}
+	p := New(ircBot{mb})
+	p.Message(makeMessage("irc:#cats", "1", "hello"))
+	p.Message(makeMessage("irc:#kittens", bot.NoIdentifier, "hi"))
+	assert.Equal(t, []string{"<tester> hello", "<tester> hi"}, mb.Messages)
+	assert.Len(t, p.ours, 0)
+}
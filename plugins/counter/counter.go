@@ -9,10 +9,12 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 )
 
 // This is a counter plugin to count arbitrary things.
@@ -20,6 +22,8 @@ import (
 type CounterPlugin struct {
 	Bot bot.Bot
 	DB  *sqlx.DB
+
+	applied applied
 }
 
 type Item struct {
@@ -67,9 +71,8 @@ func GetItem(db *sqlx.DB, nick, itemName string) (Item, error) {
 
 // Create saves a counter
 func (i *Item) Create() error {
-	res, err := i.Exec(`insert into counter (nick, item, count) values (?, ?, ?);`,
+	id, err := database.InsertID(i.DB, `insert into counter (nick, item, count) values (?, ?, ?);`,
 		i.Nick, i.Item, i.Count)
-	id, _ := res.LastInsertId()
 	// hackhackhack?
 	i.ID = id
 	return err
@@ -107,7 +110,7 @@ func (i *Item) Delete() error {
 // NewCounterPlugin creates a new CounterPlugin with the Plugin interface
 func New(bot bot.Bot) *CounterPlugin {
 	if bot.DBVersion() == 1 {
-		if _, err := bot.DB().Exec(`create table if not exists counter (
+		if err := database.CreateTable(bot.DB(), `create table if not exists counter (
 			id integer primary key,
 			nick string,
 			item string,
@@ -146,20 +149,20 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		log.Printf("About to update item: %#v", item)
 		item.UpdateDelta(1)
 		p.Bot.SendMessage(channel, fmt.Sprintf("bleep-bloop-blop... %s has %d :tea:",
-			nick, item.Count))
+			nick, item.Count), message)
 		return true
 	} else if message.Command && message.Body == "reset me" {
 		items, err := GetItems(p.DB, strings.ToLower(nick))
 		if err != nil {
 			log.Printf("Error getting items to reset %s: %s", nick, err)
-			p.Bot.SendMessage(channel, "Something is technically wrong with your counters.")
+			p.Bot.SendMessage(channel, "Something is technically wrong with your counters.", message)
 			return true
 		}
 		log.Printf("Items: %+v", items)
 		for _, item := range items {
 			item.Delete()
 		}
-		p.Bot.SendMessage(channel, fmt.Sprintf("%s, you are as new, my son.", nick))
+		p.Bot.SendMessage(channel, fmt.Sprintf("%s, you are as new, my son.", nick), message)
 		return true
 	} else if message.Command && parts[0] == "inspect" && len(parts) == 2 {
 		var subject string
@@ -175,7 +178,7 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		items, err := GetItems(p.DB, subject)
 		if err != nil {
 			log.Fatalf("Error retrieving items for %s: %s", subject, err)
-			p.Bot.SendMessage(channel, "Something went wrong finding that counter;")
+			p.Bot.SendMessage(channel, "Something went wrong finding that counter;", message)
 			return true
 		}
 
@@ -195,11 +198,11 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		resp += "."
 
 		if count == 0 {
-			p.Bot.SendMessage(channel, fmt.Sprintf("%s has no counters.", subject))
+			p.Bot.SendMessage(channel, fmt.Sprintf("%s has no counters.", subject), message)
 			return true
 		}
 
-		p.Bot.SendMessage(channel, resp)
+		p.Bot.SendMessage(channel, resp, message)
 		return true
 	} else if message.Command && len(parts) == 2 && parts[0] == "clear" {
 		subject := strings.ToLower(nick)
@@ -208,18 +211,18 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		it, err := GetItem(p.DB, subject, itemName)
 		if err != nil {
 			log.Printf("Error getting item to remove %s.%s: %s", subject, itemName, err)
-			p.Bot.SendMessage(channel, "Something went wrong removing that counter;")
+			p.Bot.SendMessage(channel, "Something went wrong removing that counter;", message)
 			return true
 		}
 		err = it.Delete()
 		if err != nil {
 			log.Printf("Error removing item %s.%s: %s", subject, itemName, err)
-			p.Bot.SendMessage(channel, "Something went wrong removing that counter;")
+			p.Bot.SendMessage(channel, "Something went wrong removing that counter;", message)
 			return true
 		}
 
 		p.Bot.SendAction(channel, fmt.Sprintf("chops a few %s out of his brain",
-			itemName))
+			itemName), message)
 		return true
 
 	} else if message.Command && parts[0] == "count" {
@@ -242,7 +245,7 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		switch {
 		case err == sql.ErrNoRows:
 			p.Bot.SendMessage(channel, fmt.Sprintf("I don't think %s has any %s.",
-				subject, itemName))
+				subject, itemName), message)
 			return true
 		case err != nil:
 			log.Printf("Error retrieving item count for %s.%s: %s",
@@ -251,108 +254,171 @@ func (p *CounterPlugin) Message(message msg.Message) bool {
 		}
 
 		p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject, item.Count,
-			itemName))
+			itemName), message)
 
 		return true
-	} else if len(parts) == 1 {
-		// Need to have at least 3 characters to ++ or --
-		if len(parts[0]) < 3 {
+	} else if subject, itemName, delta, ok := parseDelta(nick, message.Body); ok {
+		item, err := GetItem(p.DB, subject, itemName)
+		if err != nil {
+			log.Printf("Error finding item %s.%s: %s.", subject, itemName, err)
+			// Item ain't there, I guess
 			return false
 		}
+		log.Printf("About to update item by %d: %#v", delta, item)
+		item.UpdateDelta(delta)
+		p.applied.add(message.ID, change{subject, itemName, delta})
+		p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject,
+			item.Count, item.Item), message)
+		return true
+	}
 
-		subject := strings.ToLower(nick)
-		itemName := strings.ToLower(parts[0])[:len(parts[0])-2]
+	return false
+}
 
-		if nameParts := strings.SplitN(itemName, ".", 2); len(nameParts) == 2 {
-			subject = nameParts[0]
-			itemName = nameParts[1]
-		}
+// Help responds to help requests. Every plugin must implement a help function.
+func (p *CounterPlugin) Help(channel string, parts []string) {
+	p.Bot.SendMessage(channel, "You can set counters incrementally by using "+
+		"<noun>++ and <noun>--. You can see all of your counters using "+
+		"\"inspect\", erase them with \"clear\", and view single counters with "+
+		"\"count\".")
+}
 
-		if strings.HasSuffix(parts[0], "++") {
-			// ++ those fuckers
-			item, err := GetItem(p.DB, subject, itemName)
-			if err != nil {
-				log.Printf("Error finding item %s.%s: %s.", subject, itemName, err)
-				// Item ain't there, I guess
-				return false
-			}
-			log.Printf("About to update item: %#v", item)
-			item.UpdateDelta(1)
-			p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject,
-				item.Count, item.Item))
-			return true
-		} else if strings.HasSuffix(parts[0], "--") {
-			// -- those fuckers
-			item, err := GetItem(p.DB, subject, itemName)
-			if err != nil {
-				log.Printf("Error finding item %s.%s: %s.", subject, itemName, err)
-				// Item ain't there, I guess
-				return false
-			}
-			item.UpdateDelta(-1)
-			p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject,
-				item.Count, item.Item))
-			return true
-		}
-	} else if len(parts) == 3 {
-		// Need to have at least 3 characters to ++ or --
-		if len(parts[0]) < 3 {
+// Event corrects counters when somebody edits or deletes a message that
+// changed one. Only changes the plugin made are undone.
+func (p *CounterPlugin) Event(kind string, message msg.Message) bool {
+	if kind != msg.MessageEdited && kind != msg.MessageDeleted {
+		return false
+	}
+	old, undo := p.applied.remove(message.Target)
+	if !undo && kind == msg.MessageEdited && message.Previous != nil {
+		if _, _, _, ok := parseDelta(message.User.Name, message.Previous.Body); ok {
+			// It may have been counted before the plugin started
+			// remembering, so leave it be.
 			return false
 		}
+	}
 
-		subject := strings.ToLower(nick)
-		itemName := strings.ToLower(parts[0])
+	var redo bool
+	var now change
+	if kind == msg.MessageEdited {
+		now.subject, now.item, now.delta, redo = parseDelta(message.User.Name, message.Body)
+	}
+	if !undo && !redo {
+		return false
+	}
+	if undo && redo && old == now {
+		p.applied.add(message.Target, now)
+		return false
+	}
 
-		if nameParts := strings.SplitN(itemName, ".", 2); len(nameParts) == 2 {
-			subject = nameParts[0]
-			itemName = nameParts[1]
+	if undo {
+		item, err := GetItem(p.DB, old.subject, old.item)
+		if err != nil {
+			log.Printf("Error finding item %s.%s: %s.", old.subject, old.item, err)
+			return false
 		}
-
-		if parts[1] == "+=" {
-			// += those fuckers
-			item, err := GetItem(p.DB, subject, itemName)
-			if err != nil {
-				log.Printf("Error finding item %s.%s: %s.", subject, itemName, err)
-				// Item ain't there, I guess
-				return false
-			}
-			n, _ := strconv.Atoi(parts[2])
-			log.Printf("About to update item by %d: %#v", n, item)
-			item.UpdateDelta(n)
-			p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject,
-				item.Count, item.Item))
-			return true
-		} else if parts[1] == "-=" {
-			// -= those fuckers
-			item, err := GetItem(p.DB, subject, itemName)
-			if err != nil {
-				log.Printf("Error finding item %s.%s: %s.", subject, itemName, err)
-				// Item ain't there, I guess
-				return false
-			}
-			n, _ := strconv.Atoi(parts[2])
-			log.Printf("About to update item by -%d: %#v", n, item)
-			item.UpdateDelta(-n)
-			p.Bot.SendMessage(channel, fmt.Sprintf("%s has %d %s.", subject,
-				item.Count, item.Item))
+		item.UpdateDelta(-old.delta)
+		if !redo {
+			p.Bot.SendMessage(message.Channel, fmt.Sprintf("%s has %d %s.", old.subject,
+				item.Count, item.Item), message)
 			return true
 		}
 	}
 
-	return false
+	item, err := GetItem(p.DB, now.subject, now.item)
+	if err != nil {
+		log.Printf("Error finding item %s.%s: %s.", now.subject, now.item, err)
+		return false
+	}
+	item.UpdateDelta(now.delta)
+	p.applied.add(message.Target, now)
+	p.Bot.SendMessage(message.Channel, fmt.Sprintf("%s has %d %s.", now.subject,
+		item.Count, item.Item), message)
+	return true
 }
 
-// Help responds to help requests. Every plugin must implement a help function.
-func (p *CounterPlugin) Help(channel string, parts []string) {
-	p.Bot.SendMessage(channel, "You can set counters incrementally by using "+
-		"<noun>++ and <noun>--. You can see all of your counters using "+
-		"\"inspect\", erase them with \"clear\", and view single counters with "+
-		"\"count\".")
+// change is how a message changed a counter.
+type change struct {
+	subject, item string
+	delta         int
 }
 
-// Empty event handler because this plugin does not do anything on event recv
-func (p *CounterPlugin) Event(kind string, message msg.Message) bool {
-	return false
+// maxApplied is how many changes applied remembers.
+const maxApplied = 1000
+
+// applied remembers the changes recent messages made, by message ID, so they
+// can be undone if the message is edited or deleted.
+type applied struct {
+	mu      sync.Mutex
+	changes map[string]change
+	ids     []string
+}
+
+func (a *applied) add(id string, c change) {
+	if id == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.changes == nil {
+		a.changes = map[string]change{}
+	}
+	if _, ok := a.changes[id]; !ok {
+		a.ids = append(a.ids, id)
+	}
+	a.changes[id] = c
+	if len(a.ids) > maxApplied {
+		delete(a.changes, a.ids[0])
+		a.ids = a.ids[1:]
+	}
+}
+
+// remove forgets the change the message with id made and returns it.
+func (a *applied) remove(id string) (change, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.changes[id]
+	delete(a.changes, id)
+	return c, ok
+}
+
+// parseDelta works out which counter a message like "thing++" or
+// "nick.thing += 3" changes, and by how much.
+func parseDelta(nick, body string) (subject, itemName string, delta int, ok bool) {
+	parts := strings.Fields(body)
+	subject = strings.ToLower(nick)
+
+	switch {
+	case len(parts) == 1 && len(parts[0]) >= 3:
+		itemName = strings.ToLower(parts[0])[:len(parts[0])-2]
+		switch {
+		case strings.HasSuffix(parts[0], "++"):
+			delta = 1
+		case strings.HasSuffix(parts[0], "--"):
+			delta = -1
+		default:
+			return "", "", 0, false
+		}
+	case len(parts) == 3 && len(parts[0]) >= 3:
+		itemName = strings.ToLower(parts[0])
+		n, _ := strconv.Atoi(parts[2])
+		switch parts[1] {
+		case "+=":
+			delta = n
+		case "-=":
+			delta = -n
+		default:
+			return "", "", 0, false
+		}
+	default:
+		return "", "", 0, false
+	}
+
+	if nameParts := strings.SplitN(itemName, ".", 2); len(nameParts) == 2 {
+		subject = nameParts[0]
+		itemName = nameParts[1]
+	}
+	return subject, itemName, delta, true
 }
 
 // Handler for bot's own messages
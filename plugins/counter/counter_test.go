@@ -232,3 +232,100 @@ func TestRegisterWeb(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.Nil(t, c.RegisterWeb())
 }
+
+func makeEdit(previous, payload string) msg.Message {
+	prev := makeMessage(previous)
+	m := makeMessage(payload)
+	m.Kind = msg.MessageEdited
+	m.Target = "1.1"
+	m.Previous = &prev
+	return m
+}
+
+func makeDelete(previous string) msg.Message {
+	prev := makeMessage(previous)
+	m := makeMessage("")
+	m.Kind = msg.MessageDeleted
+	m.Target = "1.1"
+	m.Previous = &prev
+	return m
+}
+
+func makeCounted(payload string) msg.Message {
+	m := makeMessage(payload)
+	m.ID = "1.1"
+	return m
+}
+
+func TestEditCorrectsCounter(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	c.Message(makeCounted("mcphee++"))
+	assert.True(t, c.Event(msg.MessageEdited, makeEdit("mcphee++", "mcphi++")))
+	item, err := GetItem(mb.DB(), "tester", "mcphee")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Count)
+	item, err = GetItem(mb.DB(), "tester", "mcphi")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, item.Count)
+}
+
+func TestEditRemovesIncrement(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	c.Message(makeCounted("mcphee += 3"))
+	assert.True(t, c.Event(msg.MessageEdited, makeEdit("mcphee += 3", "nevermind")))
+	item, err := GetItem(mb.DB(), "tester", "mcphee")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Count)
+}
+
+func TestEditUnrelated(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	assert.False(t, c.Event(msg.MessageEdited, makeEdit("hello", "hello there")))
+	c.Message(makeCounted("mcphee++"))
+	assert.False(t, c.Event(msg.MessageEdited, makeEdit("mcphee++", "mcphee++ ")))
+	assert.Len(t, mb.Messages, 1)
+}
+
+func TestEditAddsIncrement(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	assert.True(t, c.Event(msg.MessageEdited, makeEdit("hello", "mcphee++")))
+	assert.True(t, c.Event(msg.MessageDeleted, makeDelete("mcphee++")))
+	item, err := GetItem(mb.DB(), "tester", "mcphee")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Count)
+}
+
+func TestDeleteRemovesIncrement(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	c.Message(makeCounted("mcphee++"))
+	assert.True(t, c.Event(msg.MessageDeleted, makeDelete("mcphee++")))
+	assert.False(t, c.Event(msg.MessageDeleted, makeDelete("mcphee++")))
+	item, err := GetItem(mb.DB(), "tester", "mcphee")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Count)
+}
+
+func TestUncountedMessagesAreLeftAlone(t *testing.T) {
+	mb := bot.NewMockBot()
+	c := New(mb)
+	assert.NotNil(t, c)
+	c.Message(makeMessage("mcphee++"))
+	assert.False(t, c.Event(msg.MessageDeleted, makeDelete("mcphee++")))
+	assert.False(t, c.Event(msg.MessageEdited, makeEdit("mcphee++", "mcphi++")))
+	item, err := GetItem(mb.DB(), "tester", "mcphee")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, item.Count)
+	item, err = GetItem(mb.DB(), "tester", "mcphi")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, item.Count)
+}
@@ -59,7 +59,7 @@ func (p *DicePlugin) Message(message msg.Message) bool {
 			}
 
 			if sides < 2 || nDice < 1 || nDice > 20 {
-				p.Bot.SendMessage(channel, "You're a dick.")
+				p.Bot.SendMessage(channel, "You're a dick.", message)
 				return true
 			}
 
@@ -74,7 +74,7 @@ func (p *DicePlugin) Message(message msg.Message) bool {
 				}
 			}
 
-			p.Bot.SendMessage(channel, rolls)
+			p.Bot.SendMessage(channel, rolls, message)
 			return true
 		}
 	}
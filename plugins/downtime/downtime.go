@@ -8,6 +8,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 )
 
 import (
@@ -49,9 +50,9 @@ func (entry idleEntry) saveIdleEntry(db *sqlx.DB) error {
 func getIdleEntryByNick(db *sqlx.DB, nick string) (idleEntry, error) {
 	var id sql.NullInt64
 	var lastSeen sql.NullInt64
-	err := db.QueryRow(`select id, max(lastSeen) from downtime
-		where nick = ?`, nick).Scan(&id, &lastSeen)
-	if err != nil {
+	err := db.QueryRow(`select id, lastSeen from downtime
+		where nick = ? order by lastSeen desc limit 1`, nick).Scan(&id, &lastSeen)
+	if err != nil && err != sql.ErrNoRows {
 		log.Println("Error selecting downtime: ", err)
 		return idleEntry{}, err
 	}
@@ -69,8 +70,8 @@ func getIdleEntryByNick(db *sqlx.DB, nick string) (idleEntry, error) {
 }
 
 func getAllIdleEntries(db *sqlx.DB) (idleEntries, error) {
-	rows, err := db.Query(`select id, nick, max(lastSeen) from downtime
-	group by nick`)
+	rows, err := db.Query(`select id, nick, lastSeen from downtime d
+	where lastSeen = (select max(lastSeen) from downtime where nick = d.nick)`)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +109,7 @@ func New(bot bot.Bot) *DowntimePlugin {
 	}
 
 	if bot.DBVersion() == 1 {
-		_, err := p.db.Exec(`create table if not exists downtime (
+		err := database.CreateTable(p.db, `create table if not exists downtime (
 			id integer primary key,
 			nick string,
 			lastSeen integer
@@ -144,10 +145,10 @@ func (p *DowntimePlugin) Message(message msg.Message) bool {
 		}
 		if !entry.id.Valid {
 			// couldn't find em
-			p.Bot.SendMessage(channel, fmt.Sprintf("Sorry, I don't know %s.", nick))
+			p.Bot.SendMessage(channel, fmt.Sprintf("Sorry, I don't know %s.", nick), message)
 		} else {
 			p.Bot.SendMessage(channel, fmt.Sprintf("%s has been idle for: %s",
-				nick, time.Now().Sub(entry.lastSeen)))
+				nick, time.Now().Sub(entry.lastSeen)), message)
 		}
 		ret = true
 	} else if parts[0] == "idle" && len(parts) == 1 {
@@ -167,7 +168,7 @@ func (p *DowntimePlugin) Message(message msg.Message) bool {
 				tops = fmt.Sprintf("%s%s: %s ", tops, e.nick, time.Now().Sub(e.lastSeen))
 			}
 		}
-		p.Bot.SendMessage(channel, tops)
+		p.Bot.SendMessage(channel, tops, message)
 		ret = true
 
 	}
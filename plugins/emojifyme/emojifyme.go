@@ -89,9 +89,9 @@ func (p *EmojifyMePlugin) Message(message msg.Message) bool {
 			}
 		}
 	}
-	if emojied > 0 && rand.Float64() <= p.Bot.Config().Emojify.Chance*emojied {
+	if emojied > 0 && rand.Float64() <= p.Bot.Config().ForChannel(message.Channel).Emojify.Chance*emojied {
 		modified := strings.Join(tokens, " ")
-		p.Bot.SendMessage(message.Channel, modified)
+		p.Bot.SendMessage(message.Channel, modified, message)
 		return true
 	}
 	return false
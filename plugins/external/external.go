@@ -0,0 +1,234 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package external runs plugins as separate programs, so they can be written
+// in any language and added without rebuilding the bot.
+//
+// A plugin reads requests from its stdin and writes responses to its stdout,
+// one JSON object per line. A request has a method, params and an id; a
+// response has the id of its request and a result or an error. Requests
+// without an id are notifications and get no response.
+//
+// The bot sends these, mirroring bot.Handler:
+//
+//	init    {"name", "nick", "channels"}, a notification on start
+//	message {"message"} → {"handled"}
+//	event   {"kind", "message"} → {"handled"}
+//	reply   {"message", "thread"} → {"handled"}
+//	help    {"channel", "parts"}, a notification
+//
+// A plugin may make these requests of the bot at any time, mirroring bot.Bot:
+//
+//	send      {"channel", "message", "thread"} → {"id"}
+//	action    {"channel", "message", "thread"} → {"id"}
+//	reply     {"channel", "message", "id"} → {"id", "ok"}
+//	react     {"channel", "reaction", "id"} → {"ok"}
+//	edit      {"channel", "message", "id"} → {"ok"}
+//	who       {"channel"} → ["name", ...]
+//	kv.get    {"key"} → {"value", "found"}
+//	kv.set    {"key", "value"} → {}
+//	kv.delete {"key"} → {}
+//
+// The thread is optional, and posts in that thread, like a message's
+// thread_id. Plugins that exit are restarted.
+package external
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/config"
+)
+
+// callTimeout is how long the bot waits for a plugin to handle something.
+// Messages are handled one at a time, so this holds up the whole bot.
+const callTimeout = 3 * time.Second
+
+// restartDelay is how long we first wait to restart a plugin that exited,
+// doubling up to maxRestartDelay while it keeps failing.
+var (
+	restartDelay    = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// ExternalPlugin is a bot.Handler whose work is done by another program.
+type ExternalPlugin struct {
+	Bot    bot.Bot
+	config config.External
+	kv     *bot.KV
+
+	mu   sync.Mutex
+	proc *process
+	// closed is closed once the plugin should stop for good
+	closed chan struct{}
+}
+
+// message is how a msg.Message is sent to plugins.
+type message struct {
+	ID       string    `json:"id,omitempty"`
+	ThreadID string    `json:"thread_id,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Reaction string    `json:"reaction,omitempty"`
+	Channel  string    `json:"channel"`
+	User     string    `json:"user"`
+	Body     string    `json:"body"`
+	Raw      string    `json:"raw"`
+	Command  bool      `json:"command"`
+	Action   bool      `json:"action"`
+	Time     time.Time `json:"time"`
+}
+
+func newMessage(m msg.Message) message {
+	out := message{
+		ID:       m.ID,
+		ThreadID: m.ThreadID,
+		Kind:     m.Kind,
+		Target:   m.Target,
+		Reaction: m.Reaction,
+		Channel:  m.Channel,
+		Body:     m.Body,
+		Raw:      m.Raw,
+		Command:  m.Command,
+		Action:   m.Action,
+		Time:     m.Time,
+	}
+	if m.User != nil {
+		out.User = m.User.Name
+	}
+	return out
+}
+
+type handled struct {
+	Handled bool `json:"handled"`
+}
+
+// New starts the plugin described by c and keeps it running.
+func New(b bot.Bot, c config.External) *ExternalPlugin {
+	p := &ExternalPlugin{
+		Bot:    b,
+		config: c,
+		kv:     b.KV("external/" + c.Name),
+		closed: make(chan struct{}),
+	}
+	go p.supervise()
+	return p
+}
+
+// supervise runs the program, restarting it whenever it exits, until the
+// plugin is closed.
+func (p *ExternalPlugin) supervise() {
+	delay := restartDelay
+	for {
+		started := time.Now()
+		proc, err := start(p.config, p.serve)
+		if err != nil {
+			log.Printf("Could not start %s: %s", p.config.Name, err)
+		} else {
+			p.mu.Lock()
+			if p.isClosed() {
+				p.mu.Unlock()
+				proc.stop()
+				return
+			}
+			p.proc = proc
+			p.mu.Unlock()
+			cfg := p.Bot.Config()
+			proc.notify("init", map[string]interface{}{
+				"name":     p.config.Name,
+				"nick":     cfg.Nick,
+				"channels": cfg.Channels,
+			})
+			<-proc.done
+			p.mu.Lock()
+			p.proc = nil
+			p.mu.Unlock()
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = restartDelay
+		}
+		if p.isClosed() {
+			return
+		}
+		log.Printf("Restarting %s in %s", p.config.Name, delay)
+		select {
+		case <-time.After(delay):
+		case <-p.closed:
+			return
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+func (p *ExternalPlugin) isClosed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the program and keeps it from being restarted.
+func (p *ExternalPlugin) Close() error {
+	p.mu.Lock()
+	if p.isClosed() {
+		p.mu.Unlock()
+		return nil
+	}
+	close(p.closed)
+	proc := p.proc
+	p.mu.Unlock()
+	if proc != nil {
+		proc.stop()
+	}
+	return nil
+}
+
+func (p *ExternalPlugin) process() *process {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.proc
+}
+
+// handle asks the plugin whether it handles something. A plugin that isn't
+// running, or doesn't answer in time, doesn't.
+func (p *ExternalPlugin) handle(method string, params interface{}) bool {
+	proc := p.process()
+	if proc == nil {
+		return false
+	}
+	var h handled
+	if err := proc.call(method, params, &h, callTimeout); err != nil {
+		log.Printf("%s: %s: %s", p.config.Name, method, err)
+		return false
+	}
+	return h.Handled
+}
+
+func (p *ExternalPlugin) Message(m msg.Message) bool {
+	return p.handle("message", map[string]interface{}{"message": newMessage(m)})
+}
+
+func (p *ExternalPlugin) Event(kind string, m msg.Message) bool {
+	return p.handle("event", map[string]interface{}{"kind": kind, "message": newMessage(m)})
+}
+
+func (p *ExternalPlugin) ReplyMessage(m msg.Message, identifier string) bool {
+	return p.handle("reply", map[string]interface{}{"message": newMessage(m), "thread": identifier})
+}
+
+func (p *ExternalPlugin) BotMessage(m msg.Message) bool { return false }
+
+func (p *ExternalPlugin) Help(channel string, parts []string) {
+	if proc := p.process(); proc != nil {
+		proc.notify("help", map[string]interface{}{"channel": channel, "parts": parts})
+	}
+}
+
+func (p *ExternalPlugin) RegisterWeb() *string { return nil }
@@ -0,0 +1,182 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+)
+
+// TestHelperProcess isn't a test, it's the plugin the tests run.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("CATBASE_HELPER_PLUGIN") != "1" {
+		return
+	}
+	in := bufio.NewScanner(os.Stdin)
+	out := json.NewEncoder(os.Stdout)
+	nextID := int64(0)
+	call := func(method string, params interface{}) json.RawMessage {
+		nextID++
+		raw, _ := json.Marshal(params)
+		out.Encode(rpc{ID: nextID, Method: method, Params: raw})
+		for in.Scan() {
+			var r rpc
+			json.Unmarshal(in.Bytes(), &r)
+			if r.Method == "" && r.ID == nextID {
+				return r.Result
+			}
+		}
+		os.Exit(0)
+		return nil
+	}
+
+	for in.Scan() {
+		var r rpc
+		json.Unmarshal(in.Bytes(), &r)
+		var params struct {
+			Message message `json:"message"`
+			Channel string  `json:"channel"`
+		}
+		json.Unmarshal(r.Params, &params)
+		m := params.Message
+		h := false
+		switch {
+		case r.Method == "help":
+			call("send", map[string]string{"channel": params.Channel, "message": "I pong"})
+		case r.Method == "message" && m.Body == "ping":
+			res := call("send", map[string]string{"channel": m.Channel, "message": "pong " + m.User})
+			var s sent
+			json.Unmarshal(res, &s)
+			call("edit", map[string]string{"channel": m.Channel, "message": "PONG " + m.User, "id": s.ID})
+			h = true
+		case r.Method == "message" && m.Body == "count":
+			var v value
+			json.Unmarshal(call("kv.get", map[string]string{"key": "count"}), &v)
+			v.Value += "!"
+			call("kv.set", map[string]string{"key": "count", "value": v.Value})
+			call("send", map[string]string{"channel": m.Channel, "message": v.Value})
+			h = true
+		case r.Method == "message" && m.Body == "crash":
+			os.Exit(1)
+		}
+		if r.ID != 0 {
+			out.Encode(map[string]interface{}{"id": r.ID, "result": handled{h}})
+		}
+	}
+}
+
+func makeMessage(payload string) msg.Message {
+	return msg.Message{
+		User:    &user.User{Name: "tester"},
+		Channel: "test",
+		Body:    payload,
+	}
+}
+
+func makePlugin(t *testing.T) (*ExternalPlugin, *bot.MockBot) {
+	restartDelay = 10 * time.Millisecond
+	mb := bot.NewMockBot()
+	p := New(mb, config.External{
+		Name:    "helper",
+		Command: []string{os.Args[0], "-test.run=TestHelperProcess"},
+		Env:     []string{"CATBASE_HELPER_PLUGIN=1"},
+	})
+	waitRunning(t, p, nil)
+	return p, mb
+}
+
+// waitRunning waits for the plugin to be running a program other than old.
+func waitRunning(t *testing.T, p *ExternalPlugin, old *process) {
+	for i := 0; i < 500; i++ {
+		if proc := p.process(); proc != nil && proc != old {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("plugin never started")
+}
+
+func TestMessages(t *testing.T) {
+	p, mb := makePlugin(t)
+	assert.True(t, p.Message(makeMessage("ping")))
+	assert.False(t, p.Message(makeMessage("hello")))
+	assert.Equal(t, []string{"PONG tester"}, mb.Messages)
+}
+
+func TestKV(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Message(makeMessage("count"))
+	p.Message(makeMessage("count"))
+	assert.Equal(t, []string{"!", "!!"}, mb.Messages)
+}
+
+func TestRestartsAfterCrash(t *testing.T) {
+	p, mb := makePlugin(t)
+	first := p.process()
+	assert.False(t, p.Message(makeMessage("crash")))
+	<-first.done
+	waitRunning(t, p, first)
+	assert.True(t, p.Message(makeMessage("ping")))
+	assert.Equal(t, []string{"PONG tester"}, mb.Messages)
+}
+
+// threadBot records the thread each message is sent to.
+type threadBot struct {
+	*bot.MockBot
+	threads []string
+}
+
+func (b *threadBot) SendMessage(channel, message string, replyTo ...msg.Message) string {
+	thread := ""
+	if len(replyTo) > 0 {
+		thread = replyTo[0].ThreadID
+	}
+	b.threads = append(b.threads, thread)
+	return b.MockBot.SendMessage(channel, message, replyTo...)
+}
+
+func TestSendInThread(t *testing.T) {
+	b := &threadBot{MockBot: bot.NewMockBot()}
+	p := &ExternalPlugin{Bot: b}
+	_, err := p.serve("send", json.RawMessage(`{"channel": "test", "message": "threaded", "thread": "1.1"}`))
+	assert.Nil(t, err)
+	_, err = p.serve("send", json.RawMessage(`{"channel": "test", "message": "flat"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"threaded", "flat"}, b.Messages)
+	assert.Equal(t, []string{"1.1", ""}, b.threads)
+}
+
+func TestHelpAndBadRequests(t *testing.T) {
+	p, mb := makePlugin(t)
+	p.Help("test", []string{"help", "helper"})
+	_, err := p.serve("launch", nil)
+	assert.EqualError(t, err, "unknown method launch")
+	// stopping waits for everything the plugin asked for to be done
+	assert.Nil(t, p.Close())
+	assert.Equal(t, []string{"I pong"}, mb.Messages)
+}
+
+func TestCloseStopsThePlugin(t *testing.T) {
+	p, _ := makePlugin(t)
+	proc := p.process()
+	assert.Nil(t, p.Close())
+	select {
+	case <-proc.done:
+	default:
+		t.Fatal("the plugin is still running")
+	}
+	// it isn't restarted, and closing again is harmless
+	time.Sleep(10 * restartDelay)
+	assert.Nil(t, p.process())
+	assert.False(t, p.Message(makeMessage("ping")))
+	assert.Nil(t, p.Close())
+}
@@ -0,0 +1,80 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package external
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// request holds the params of every request a plugin can make.
+type request struct {
+	Channel  string `json:"channel"`
+	Message  string `json:"message"`
+	Reaction string `json:"reaction"`
+	ID       string `json:"id"`
+	Thread   string `json:"thread"`
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+}
+
+type sent struct {
+	ID string `json:"id"`
+	OK bool   `json:"ok"`
+}
+
+type value struct {
+	Value string `json:"value"`
+	Found bool   `json:"found"`
+}
+
+// replyTo makes a send or action go to the request's thread, if it has one.
+func (r request) replyTo() []msg.Message {
+	if r.Thread == "" {
+		return nil
+	}
+	return []msg.Message{{Channel: r.Channel, ThreadID: r.Thread}}
+}
+
+// serve carries out a request from the plugin.
+func (p *ExternalPlugin) serve(method string, params json.RawMessage) (interface{}, error) {
+	var r request
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, &r); err != nil {
+			return nil, fmt.Errorf("bad params: %s", err)
+		}
+	}
+
+	switch method {
+	case "send":
+		return sent{ID: p.Bot.SendMessage(r.Channel, r.Message, r.replyTo()...), OK: true}, nil
+	case "action":
+		return sent{ID: p.Bot.SendAction(r.Channel, r.Message, r.replyTo()...), OK: true}, nil
+	case "reply":
+		id, ok := p.Bot.ReplyToMessageIdentifier(r.Channel, r.Message, r.ID)
+		return sent{ID: id, OK: ok}, nil
+	case "react":
+		ok := p.Bot.React(r.Channel, r.Reaction, msg.Message{ID: r.ID, Channel: r.Channel})
+		return sent{OK: ok}, nil
+	case "edit":
+		return sent{OK: p.Bot.Edit(r.Channel, r.Message, r.ID)}, nil
+	case "who":
+		names := []string{}
+		for _, u := range p.Bot.Who(r.Channel) {
+			names = append(names, u.Name)
+		}
+		return names, nil
+	case "kv.get":
+		var v value
+		var err error
+		v.Found, err = p.kv.Get(r.Key, &v.Value)
+		return v, err
+	case "kv.set":
+		return struct{}{}, p.kv.Set(r.Key, r.Value)
+	case "kv.delete":
+		return struct{}{}, p.kv.Delete(r.Key)
+	}
+	return nil, fmt.Errorf("unknown method %s", method)
+}
@@ -0,0 +1,230 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/velour/catbase/config"
+)
+
+const (
+	// maxLine is the longest line a plugin may send.
+	maxLine = 1 << 20
+
+	// stopTimeout is how long a plugin has to exit once asked to.
+	stopTimeout = 5 * time.Second
+)
+
+var errExited = errors.New("plugin exited")
+
+// rpc is one line of the protocol. Lines with a method are requests, or
+// notifications if they have no ID; lines without are responses to the
+// request with their ID. Each side numbers its own requests.
+type rpc struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// process is a running plugin.
+type process struct {
+	name string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan rpc
+
+	// done is closed when the program exits
+	done chan struct{}
+}
+
+// start runs a plugin's program. Requests it makes are passed to serve,
+// whose result or error is sent back.
+func start(c config.External, serve func(method string, params json.RawMessage) (interface{}, error)) (*process, error) {
+	if len(c.Command) == 0 {
+		return nil, fmt.Errorf("no command")
+	}
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), c.Env...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &process{
+		name:    c.Name,
+		cmd:     cmd,
+		in:      in,
+		pending: map[int64]chan rpc{},
+		done:    make(chan struct{}),
+	}
+	stderrDone := make(chan struct{})
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			log.Printf("%s: %s", p.name, s.Text())
+		}
+		close(stderrDone)
+	}()
+	go func() {
+		p.read(out, serve)
+		// Wait closes the pipes, so everything must be read first.
+		<-stderrDone
+		if err := cmd.Wait(); err != nil {
+			log.Printf("%s exited: %s", p.name, err)
+		}
+		p.mu.Lock()
+		for id, ch := range p.pending {
+			close(ch)
+			delete(p.pending, id)
+		}
+		p.mu.Unlock()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// read handles lines from the plugin until its output closes.
+func (p *process) read(out io.Reader, serve func(string, json.RawMessage) (interface{}, error)) {
+	s := bufio.NewScanner(out)
+	s.Buffer(make([]byte, 4096), maxLine)
+	for s.Scan() {
+		var r rpc
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			log.Printf("%s sent a bad line: %s", p.name, err)
+			continue
+		}
+		if r.Method == "" {
+			p.mu.Lock()
+			ch, ok := p.pending[r.ID]
+			delete(p.pending, r.ID)
+			p.mu.Unlock()
+			if ok {
+				ch <- r
+			}
+			continue
+		}
+
+		result, err := serve(r.Method, r.Params)
+		if r.ID == 0 {
+			if err != nil {
+				log.Printf("%s: %s: %s", p.name, r.Method, err)
+			}
+			continue
+		}
+		resp := rpc{ID: r.ID}
+		if err != nil {
+			resp.Error = err.Error()
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Result, resp.Error = nil, err.Error()
+		}
+		if err := p.write(resp); err != nil {
+			log.Printf("%s: %s", p.name, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		log.Printf("%s: reading: %s", p.name, err)
+	}
+	// Make sure a program that closed its output without exiting is gone,
+	// so that it can be restarted.
+	p.cmd.Process.Kill()
+}
+
+func (p *process) write(r rpc) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err = p.in.Write(append(line, '\n'))
+	return err
+}
+
+// notify sends a request that expects no response.
+func (p *process) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return p.write(rpc{Method: method, Params: raw})
+}
+
+// call sends a request and waits up to timeout for its result, which is
+// decoded into result.
+func (p *process) call(method string, params, result interface{}, timeout time.Duration) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ch := make(chan rpc, 1)
+	p.mu.Lock()
+	p.nextID++
+	id := p.nextID
+	p.pending[id] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	if err := p.write(rpc{ID: id, Method: method, Params: raw}); err != nil {
+		return err
+	}
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			return errExited
+		}
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+		if result == nil || len(r.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(r.Result, result)
+	case <-time.After(timeout):
+		return fmt.Errorf("%s timed out after %s", method, timeout)
+	}
+}
+
+// stop asks the program to exit by closing its input, and kills it if it
+// hasn't within stopTimeout.
+func (p *process) stop() {
+	p.in.Close()
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
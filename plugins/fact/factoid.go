@@ -16,6 +16,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 )
 
 // The factoid plugin provides a learning system to the bot so that it can
@@ -77,7 +78,7 @@ func (a *alias) save(db *sqlx.DB) error {
 	if err != nil {
 		return fmt.Errorf("there is no fact at that destination")
 	}
-	q = `insert or replace into factoid_alias (fact, next) values (?, ?)`
+	q = database.Upsert(db, "factoid_alias", []string{"fact", "next"})
 	_, err = db.Exec(q, a.Fact, a.Next)
 	if err != nil {
 		return err
@@ -112,7 +113,7 @@ func (f *factoid) save(db *sqlx.DB) error {
 		f.created = time.Now()
 		f.accessed = time.Now()
 		// insert
-		res, err := db.Exec(`insert into factoid (
+		id, err := database.InsertID(db, `insert into factoid (
 			fact,
 			tidbit,
 			verb,
@@ -132,7 +133,6 @@ func (f *factoid) save(db *sqlx.DB) error {
 		if err != nil {
 			return err
 		}
-		id, err := res.LastInsertId()
 		// hackhackhack?
 		f.id.Int64 = id
 		f.id.Valid = true
@@ -161,8 +161,8 @@ func getFacts(db *sqlx.DB, fact string, tidbit string) ([]*factoid, error) {
 			accessed,
 			count
 		from factoid
-		where fact like ?
-		and tidbit like ?;`
+		where fact ` + database.Like(db) + ` ?
+		and tidbit ` + database.Like(db) + ` ?;`
 	rows, err := db.Query(query,
 		"%"+fact+"%", "%"+tidbit+"%")
 	if err != nil {
@@ -236,7 +236,7 @@ func getSingleFact(db *sqlx.DB, fact string) (*factoid, error) {
 			accessed,
 			count
 		from factoid
-		where fact like ?
+		where fact `+database.Like(db)+` ?
 		order by random() limit 1;`,
 		fact).Scan(
 		&f.id,
@@ -276,7 +276,7 @@ func New(botInst bot.Bot) *Factoid {
 		db: botInst.DB(),
 	}
 
-	if _, err := p.db.Exec(`create table if not exists factoid (
+	if err := database.CreateTable(p.db, `create table if not exists factoid (
 			id integer primary key,
 			fact string,
 			tidbit string,
@@ -289,7 +289,7 @@ func New(botInst bot.Bot) *Factoid {
 		log.Fatal(err)
 	}
 
-	if _, err := p.db.Exec(`create table if not exists factoid_alias (
+	if err := database.CreateTable(p.db, `create table if not exists factoid_alias (
 			fact string,
 			next string,
 			primary key (fact, next)
@@ -397,11 +397,11 @@ func (p *Factoid) sayFact(message msg.Message, fact factoid) {
 		}
 
 		if fact.Verb == "action" {
-			p.Bot.SendAction(message.Channel, msg)
+			p.Bot.SendAction(message.Channel, msg, message)
 		} else if fact.Verb == "reply" {
-			p.Bot.SendMessage(message.Channel, msg)
+			p.Bot.SendMessage(message.Channel, msg, message)
 		} else {
-			p.Bot.SendMessage(message.Channel, full)
+			p.Bot.SendMessage(message.Channel, full, message)
 		}
 	}
 
@@ -420,7 +420,7 @@ func (p *Factoid) sayFact(message msg.Message, fact factoid) {
 // trigger checks the message for its fitness to be a factoid and then hauls
 // the message off to sayFact for processing if it is in fact a trigger
 func (p *Factoid) trigger(message msg.Message) bool {
-	minLen := p.Bot.Config().Factoid.MinLen
+	minLen := p.Bot.Config().ForChannel(message.Channel).Factoid.MinLen
 	if len(message.Body) > minLen || message.Command || message.Body == "..." {
 		if ok, fact := p.findTrigger(message.Body); ok {
 			p.sayFact(message, *fact)
@@ -447,7 +447,7 @@ func (p *Factoid) tellThemWhatThatWas(message msg.Message) bool {
 		msg = fmt.Sprintf("That was (#%d) '%s <%s> %s'",
 			fact.id.Int64, fact.Fact, fact.Verb, fact.Tidbit)
 	}
-	p.Bot.SendMessage(message.Channel, msg)
+	p.Bot.SendMessage(message.Channel, msg, message)
 	return true
 }
 
@@ -465,21 +465,21 @@ func (p *Factoid) learnAction(message msg.Message, action string) bool {
 	action = strings.TrimSpace(action)
 
 	if len(trigger) == 0 || len(fact) == 0 || len(action) == 0 {
-		p.Bot.SendMessage(message.Channel, "I don't want to learn that.")
+		p.Bot.SendMessage(message.Channel, "I don't want to learn that.", message)
 		return true
 	}
 
 	if len(strings.Split(fact, "$and")) > 4 {
-		p.Bot.SendMessage(message.Channel, "You can't use more than 4 $and operators.")
+		p.Bot.SendMessage(message.Channel, "You can't use more than 4 $and operators.", message)
 		return true
 	}
 
 	strippedaction := strings.Replace(strings.Replace(action, "<", "", 1), ">", "", 1)
 
 	if p.learnFact(message, trigger, strippedaction, fact) {
-		p.Bot.SendMessage(message.Channel, fmt.Sprintf("Okay, %s.", message.User.Name))
+		p.Bot.SendMessage(message.Channel, fmt.Sprintf("Okay, %s.", message.User.Name), message)
 	} else {
-		p.Bot.SendMessage(message.Channel, "I already know that.")
+		p.Bot.SendMessage(message.Channel, "I already know that.", message)
 	}
 
 	return true
@@ -499,7 +499,7 @@ func changeOperator(body string) string {
 // an admin, it may be deleted
 func (p *Factoid) forgetLastFact(message msg.Message) bool {
 	if p.LastFact == nil {
-		p.Bot.SendMessage(message.Channel, "I refuse.")
+		p.Bot.SendMessage(message.Channel, "I refuse.", message)
 		return true
 	}
 
@@ -509,7 +509,7 @@ func (p *Factoid) forgetLastFact(message msg.Message) bool {
 	}
 	fmt.Printf("Forgot #%d: %s %s %s\n", p.LastFact.id.Int64, p.LastFact.Fact,
 		p.LastFact.Verb, p.LastFact.Tidbit)
-	p.Bot.SendAction(message.Channel, "hits himself over the head with a skillet")
+	p.Bot.SendAction(message.Channel, "hits himself over the head with a skillet", message)
 	p.LastFact = nil
 
 	return true
@@ -529,7 +529,7 @@ func (p *Factoid) changeFact(message msg.Message) bool {
 	if len(parts) == 4 {
 		// replacement
 		if parts[0] != "s" {
-			p.Bot.SendMessage(message.Channel, "Nah.")
+			p.Bot.SendMessage(message.Channel, "Nah.", message)
 		}
 		find := parts[1]
 		replace := parts[2]
@@ -544,10 +544,10 @@ func (p *Factoid) changeFact(message msg.Message) bool {
 		}
 		// make the changes
 		msg := fmt.Sprintf("Changing %d facts.", len(result))
-		p.Bot.SendMessage(message.Channel, msg)
+		p.Bot.SendMessage(message.Channel, msg, message)
 		reg, err := regexp.Compile(find)
 		if err != nil {
-			p.Bot.SendMessage(message.Channel, "I don't really want to.")
+			p.Bot.SendMessage(message.Channel, "I don't really want to.", message)
 			return false
 		}
 		for _, fact := range result {
@@ -564,12 +564,12 @@ func (p *Factoid) changeFact(message msg.Message) bool {
 		result, err := getFacts(p.db, trigger, parts[1])
 		if err != nil {
 			log.Println("Error getting facts: ", trigger, err)
-			p.Bot.SendMessage(message.Channel, "bzzzt")
+			p.Bot.SendMessage(message.Channel, "bzzzt", message)
 			return true
 		}
 		count := len(result)
 		if count == 0 {
-			p.Bot.SendMessage(message.Channel, "I didn't find any facts like that.")
+			p.Bot.SendMessage(message.Channel, "I didn't find any facts like that.", message)
 			return true
 		}
 		if parts[2] == "g" && len(result) > 4 {
@@ -589,9 +589,9 @@ func (p *Factoid) changeFact(message msg.Message) bool {
 		if count > 4 {
 			msg = fmt.Sprintf("%s | ...and %d others", msg, count)
 		}
-		p.Bot.SendMessage(message.Channel, msg)
+		p.Bot.SendMessage(message.Channel, msg, message)
 	} else {
-		p.Bot.SendMessage(message.Channel, "I don't know what you mean.")
+		p.Bot.SendMessage(message.Channel, "I don't know what you mean.", message)
 	}
 	return true
 }
@@ -615,14 +615,14 @@ func (p *Factoid) Message(message msg.Message) bool {
 		m := strings.TrimPrefix(message.Body, "alias ")
 		parts := strings.SplitN(m, "->", 2)
 		if len(parts) != 2 {
-			p.Bot.SendMessage(message.Channel, "If you want to alias something, use: `alias this -> that`")
+			p.Bot.SendMessage(message.Channel, "If you want to alias something, use: `alias this -> that`", message)
 			return true
 		}
 		a := aliasFromStrings(strings.TrimSpace(parts[1]), strings.TrimSpace(parts[0]))
 		if err := a.save(p.db); err != nil {
-			p.Bot.SendMessage(message.Channel, err.Error())
+			p.Bot.SendMessage(message.Channel, err.Error(), message)
 		} else {
-			p.Bot.SendAction(message.Channel, "learns a new synonym")
+			p.Bot.SendAction(message.Channel, "learns a new synonym", message)
 		}
 		return true
 	}
@@ -654,7 +654,7 @@ func (p *Factoid) Message(message msg.Message) bool {
 	}
 
 	// We didn't find anything, panic!
-	p.Bot.SendMessage(message.Channel, p.NotFound[rand.Intn(len(p.NotFound))])
+	p.Bot.SendMessage(message.Channel, p.NotFound[rand.Intn(len(p.NotFound))], message)
 	return true
 }
 
@@ -681,11 +681,13 @@ func (p *Factoid) randomFact() *factoid {
 
 // factTimer spits out a fact at a given interval and with given probability
 func (p *Factoid) factTimer(channel string) {
-	duration := time.Duration(p.Bot.Config().Factoid.QuoteTime) * time.Minute
 	myLastMsg := time.Now()
 	for {
 		time.Sleep(time.Duration(5) * time.Second) // why 5?
 
+		cfg := p.Bot.Config().ForChannel(channel)
+		duration := time.Duration(cfg.Factoid.QuoteTime) * time.Minute
+
 		lastmsg, err := p.Bot.LastMessage(channel)
 		if err != nil {
 			// Probably no previous message to time off of
@@ -695,7 +697,7 @@ func (p *Factoid) factTimer(channel string) {
 		tdelta := time.Since(lastmsg.Time)
 		earlier := time.Since(myLastMsg) > tdelta
 		chance := rand.Float64()
-		success := chance < p.Bot.Config().Factoid.QuoteChance
+		success := chance < cfg.Factoid.QuoteChance
 
 		if success && tdelta > duration && earlier {
 			fact := p.randomFact()
@@ -11,6 +11,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 )
 
 // This is a skeleton plugin to serve as an example and quick copy/paste for new
@@ -40,7 +41,7 @@ func (p *RememberPlugin) Message(message msg.Message) bool {
 
 	if strings.ToLower(message.Body) == "quote" && message.Command {
 		q := p.randQuote()
-		p.Bot.SendMessage(message.Channel, q)
+		p.Bot.SendMessage(message.Channel, q, message)
 
 		// is it evil not to remember that the user said quote?
 		return true
@@ -87,7 +88,7 @@ func (p *RememberPlugin) Message(message msg.Message) bool {
 				}
 				if err := fact.save(p.db); err != nil {
 					log.Println("ERROR!!!!:", err)
-					p.Bot.SendMessage(message.Channel, "Tell somebody I'm broke.")
+					p.Bot.SendMessage(message.Channel, "Tell somebody I'm broke.", message)
 				}
 
 				log.Println("Remembering factoid:", msg)
@@ -95,14 +96,14 @@ func (p *RememberPlugin) Message(message msg.Message) bool {
 				// sorry, not creative with names so we're reusing msg
 				msg = fmt.Sprintf("Okay, %s, remembering '%s'.",
 					message.User.Name, msg)
-				p.Bot.SendMessage(message.Channel, msg)
+				p.Bot.SendMessage(message.Channel, msg, message)
 				p.recordMsg(message)
 				return true
 
 			}
 		}
 
-		p.Bot.SendMessage(message.Channel, "Sorry, I don't know that phrase.")
+		p.Bot.SendMessage(message.Channel, "Sorry, I don't know that phrase.", message)
 		p.recordMsg(message)
 		return true
 	}
@@ -129,7 +130,7 @@ func (p *RememberPlugin) randQuote() string {
 	var f factoid
 	var tmpCreated int64
 	var tmpAccessed int64
-	err := p.db.QueryRow(`select * from factoid where fact like '%quotes'
+	err := p.db.QueryRow(`select * from factoid where fact `+database.Like(p.db)+` '%quotes'
 		order by random() limit 1;`).Scan(
 		&f.id,
 		&f.Fact,
@@ -13,6 +13,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/database"
 )
 
 // This is a first plugin to serve as an example and quick copy/paste for new plugins.
@@ -49,7 +50,7 @@ func (fe *FirstEntry) save(db *sqlx.DB) error {
 // NewFirstPlugin creates a new FirstPlugin with the Plugin interface
 func New(b bot.Bot) *FirstPlugin {
 	if b.DBVersion() == 1 {
-		_, err := b.DB().Exec(`create table if not exists first (
+		err := database.CreateTable(b.DB(), `create table if not exists first (
 			id integer primary key,
 			day integer,
 			time integer,
@@ -84,8 +85,8 @@ func getLastFirst(db *sqlx.DB) (*FirstEntry, error) {
 	var nick sql.NullString
 
 	err := db.QueryRow(`select
-		id, max(day), time, body, nick from first
-		limit 1;
+		id, day, time, body, nick from first
+		order by day desc limit 1;
 	`).Scan(
 		&id,
 		&day,
@@ -198,7 +199,7 @@ func (p *FirstPlugin) announceFirst(message msg.Message) {
 	c := message.Channel
 	if p.First != nil {
 		p.Bot.SendMessage(c, fmt.Sprintf("%s had first at %s with the message: \"%s\"",
-			p.First.nick, p.First.time.Format(time.Kitchen), p.First.body))
+			p.First.nick, p.First.time.Format(time.Kitchen), p.First.body), message)
 	}
 }
 
@@ -14,6 +14,7 @@ import (
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
 )
 
 type InventoryPlugin struct {
@@ -47,7 +48,7 @@ func New(bot bot.Bot) *InventoryPlugin {
 	bot.RegisterFilter("$item", p.itemFilter)
 	bot.RegisterFilter("$giveitem", p.giveItemFilter)
 
-	_, err = p.DB.Exec(`create table if not exists inventory (
+	err = database.CreateTable(p.DB, `create table if not exists inventory (
 			item string primary key
 		);`)
 
@@ -85,7 +86,7 @@ func (p *InventoryPlugin) Message(message msg.Message) bool {
 				log.Printf("I think I have more than 0 items: %+v, len(items)=%d", items, len(items))
 				say = fmt.Sprintf("I'm currently holding %s", strings.Join(items, ", "))
 			}
-			p.bot.SendMessage(message.Channel, say)
+			p.bot.SendMessage(message.Channel, say, message)
 			return true
 		}
 
@@ -196,11 +197,11 @@ func (p *InventoryPlugin) remove(i string) {
 
 func (p *InventoryPlugin) addItem(m msg.Message, i string) bool {
 	if p.exists(i) {
-		p.bot.SendMessage(m.Channel, fmt.Sprintf("I already have %s.", i))
+		p.bot.SendMessage(m.Channel, fmt.Sprintf("I already have %s.", i), m)
 		return true
 	}
 	var removed string
-	if p.count() > p.config.Inventory.Max {
+	if p.count() > p.config.Current().Inventory.Max {
 		removed = p.removeRandom()
 	}
 	_, err := p.Exec(`INSERT INTO inventory (item) values (?)`, i)
@@ -208,9 +209,9 @@ func (p *InventoryPlugin) addItem(m msg.Message, i string) bool {
 		log.Printf("Error inserting new inventory item: %s", err)
 	}
 	if removed != "" {
-		p.bot.SendAction(m.Channel, fmt.Sprintf("dropped %s and took %s from %s", removed, i, m.User.Name))
+		p.bot.SendAction(m.Channel, fmt.Sprintf("dropped %s and took %s from %s", removed, i, m.User.Name), m)
 	} else {
-		p.bot.SendAction(m.Channel, fmt.Sprintf("takes %s from %s", i, m.User.Name))
+		p.bot.SendAction(m.Channel, fmt.Sprintf("takes %s from %s", i, m.User.Name), m)
 	}
 	return true
 }
@@ -42,19 +42,20 @@ func (p *LeftpadPlugin) Message(message msg.Message) bool {
 		padchar := parts[1]
 		length, err := strconv.Atoi(parts[2])
 		if err != nil {
-			p.bot.SendMessage(message.Channel, "Invalid padding number")
+			p.bot.SendMessage(message.Channel, "Invalid padding number", message)
 			return true
 		}
-		if length > p.config.LeftPad.MaxLen && p.config.LeftPad.MaxLen > 0 {
-			msg := fmt.Sprintf("%s would kill me if I did that.", p.config.LeftPad.Who)
-			p.bot.SendMessage(message.Channel, msg)
+		cfg := p.config.Current()
+		if length > cfg.LeftPad.MaxLen && cfg.LeftPad.MaxLen > 0 {
+			msg := fmt.Sprintf("%s would kill me if I did that.", cfg.LeftPad.Who)
+			p.bot.SendMessage(message.Channel, msg, message)
 			return true
 		}
 		text := strings.Join(parts[3:], " ")
 
 		res := leftpad.LeftPad(text, length, padchar)
 
-		p.bot.SendMessage(message.Channel, res)
+		p.bot.SendMessage(message.Channel, res, message)
 		return true
 	}
 
@@ -26,24 +26,25 @@ func New(bot bot.Bot) *ReactionPlugin {
 }
 
 func (p *ReactionPlugin) Message(message msg.Message) bool {
+	cfg := p.Config.ForChannel(message.Channel)
 	harrass := false
-	for _, nick := range p.Config.Reaction.HarrassList {
+	for _, nick := range cfg.Reaction.HarrassList {
 		if message.User.Name == nick {
 			harrass = true
 			break
 		}
 	}
 
-	chance := p.Config.Reaction.GeneralChance
+	chance := cfg.Reaction.GeneralChance
 	negativeWeight := 1
 	if harrass {
-		chance = p.Config.Reaction.HarrassChance
-		negativeWeight = p.Config.Reaction.NegativeHarrassmentMultiplier
+		chance = cfg.Reaction.HarrassChance
+		negativeWeight = cfg.Reaction.NegativeHarrassmentMultiplier
 	}
 
 	if rand.Float64() < chance {
-		numPositiveReactions := len(p.Config.Reaction.PositiveReactions)
-		numNegativeReactions := len(p.Config.Reaction.NegativeReactions)
+		numPositiveReactions := len(cfg.Reaction.PositiveReactions)
+		numNegativeReactions := len(cfg.Reaction.NegativeReactions)
 
 		maxIndex := numPositiveReactions + numNegativeReactions * negativeWeight
 
@@ -52,11 +53,11 @@ func (p *ReactionPlugin) Message(message msg.Message) bool {
 		reaction := ""
 
 		if index < numPositiveReactions {
-			reaction = p.Config.Reaction.PositiveReactions[index]
+			reaction = cfg.Reaction.PositiveReactions[index]
 		} else {
 			index -= numPositiveReactions
 			index %= numNegativeReactions
-			reaction = p.Config.Reaction.NegativeReactions[index]
+			reaction = cfg.Reaction.NegativeReactions[index]
 		}
 
 		p.Bot.React(message.Channel, reaction, message)
@@ -14,7 +14,9 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
 	"github.com/velour/catbase/config"
+	"github.com/velour/catbase/database"
 )
 
 const (
@@ -41,7 +43,7 @@ type Reminder struct {
 func New(bot bot.Bot) *ReminderPlugin {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	if bot.DBVersion() == 1 {
-		if _, err := bot.DB().Exec(`create table if not exists reminders (
+		if err := database.CreateTable(bot.DB(), `create table if not exists reminders (
 			id integer primary key,
 			fromWho string,
 			toWho string,
@@ -79,21 +81,24 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 	parts := strings.Fields(message.Body)
 
 	if len(parts) >= 5 {
-		if strings.ToLower(parts[0]) == "remind" {
+		if cmd := strings.ToLower(parts[0]); cmd == "remind" || cmd == "dm" {
 			who := parts[1]
+			target := user.User{Name: who}
 			if who == "me" {
 				who = from
+				target = *message.User
 			}
 
 			dur, err := time.ParseDuration(parts[3])
 			if err != nil {
-				p.Bot.SendMessage(channel, "Easy cowboy, not sure I can parse that duration.")
+				p.Bot.SendMessage(channel, "Easy cowboy, not sure I can parse that duration.", message)
 				return true
 			}
 
 			operator := strings.ToLower(parts[2])
 
 			doConfirm := true
+			var reminders []*Reminder
 
 			if operator == "in" {
 				//one off reminder
@@ -101,13 +106,12 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 				when := time.Now().UTC().Add(dur)
 				what := strings.Join(parts[4:], " ")
 
-				p.addReminder(&Reminder{
-					id:      -1,
-					from:    from,
-					who:     who,
-					what:    what,
-					when:    when,
-					channel: channel,
+				reminders = append(reminders, &Reminder{
+					id:   -1,
+					from: from,
+					who:  who,
+					what: what,
+					when: when,
 				})
 
 			} else if operator == "every" && strings.ToLower(parts[4]) == "for" {
@@ -115,7 +119,7 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 				//remind who every dur for dur2 blah
 				dur2, err := time.ParseDuration(parts[5])
 				if err != nil {
-					p.Bot.SendMessage(channel, "Easy cowboy, not sure I can parse that duration.")
+					p.Bot.SendMessage(channel, "Easy cowboy, not sure I can parse that duration.", message)
 					return true
 				}
 
@@ -124,31 +128,52 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 				what := strings.Join(parts[6:], " ")
 
 				for i := 0; when.Before(endTime); i++ {
-					if i >= p.config.Reminder.MaxBatchAdd {
-						p.Bot.SendMessage(channel, "Easy cowboy, that's a lot of reminders. I'll add some of them.")
+					if i >= p.config.Current().Reminder.MaxBatchAdd {
 						doConfirm = false
 						break
 					}
 
-					p.addReminder(&Reminder{
-						id:      int64(-1),
-						from:    from,
-						who:     who,
-						what:    what,
-						when:    when,
-						channel: channel,
+					reminders = append(reminders, &Reminder{
+						id:   int64(-1),
+						from: from,
+						who:  who,
+						what: what,
+						when: when,
 					})
 
 					when = when.Add(dur)
 				}
 			} else {
-				p.Bot.SendMessage(channel, "Easy cowboy, not sure I comprehend what you're asking.")
+				p.Bot.SendMessage(channel, "Easy cowboy, not sure I comprehend what you're asking.", message)
 				return true
 			}
 
+			// Reminders sent by DM are delivered to the DM channel
+			// instead of the one they were asked for in, which is
+			// only opened once the request is known to be good.
+			reminderChannel := channel
+			if cmd == "dm" && len(reminders) > 0 {
+				dm, err := p.Bot.OpenDM(target)
+				if err != nil {
+					log.Printf("Error opening DM with %s: %s", who, err)
+					p.Bot.SendMessage(channel, fmt.Sprintf("Sorry, I can't DM %s.", who), message)
+					return true
+				}
+				reminderChannel = dm
+			}
+
+			for _, r := range reminders {
+				r.channel = reminderChannel
+				p.addReminder(r)
+			}
+
+			if !doConfirm {
+				p.Bot.SendMessage(channel, "Easy cowboy, that's a lot of reminders. I'll add some of them.", message)
+			}
+
 			if doConfirm {
 				response := fmt.Sprintf("Sure %s, I'll remind %s.", from, who)
-				p.Bot.SendMessage(channel, response)
+				p.Bot.SendMessage(channel, response, message)
 			}
 
 			p.queueUpNextReminder()
@@ -158,22 +183,22 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 	} else if len(parts) == 2 && strings.ToLower(parts[0]) == "list" && strings.ToLower(parts[1]) == "reminders" {
 		response, err := p.getAllRemindersFormatted(channel)
 		if err != nil {
-			p.Bot.SendMessage(channel, "listing failed.")
+			p.Bot.SendMessage(channel, "listing failed.", message)
 		} else {
-			p.Bot.SendMessage(channel, response)
+			p.Bot.SendMessage(channel, response, message)
 		}
 		return true
 	} else if len(parts) == 3 && strings.ToLower(parts[0]) == "cancel" && strings.ToLower(parts[1]) == "reminder" {
 		id, err := strconv.ParseInt(parts[2], 10, 64)
 		if err != nil {
-			p.Bot.SendMessage(channel, fmt.Sprintf("couldn't parse id: %s", parts[2]))
+			p.Bot.SendMessage(channel, fmt.Sprintf("couldn't parse id: %s", parts[2]), message)
 
 		} else {
 			err := p.deleteReminder(id)
 			if err == nil {
-				p.Bot.SendMessage(channel, fmt.Sprintf("successfully canceled reminder: %s", parts[2]))
+				p.Bot.SendMessage(channel, fmt.Sprintf("successfully canceled reminder: %s", parts[2]), message)
 			} else {
-				p.Bot.SendMessage(channel, fmt.Sprintf("failed to find and cancel reminder: %s", parts[2]))
+				p.Bot.SendMessage(channel, fmt.Sprintf("failed to find and cancel reminder: %s", parts[2]), message)
 			}
 		}
 		return true
@@ -183,7 +208,7 @@ func (p *ReminderPlugin) Message(message msg.Message) bool {
 }
 
 func (p *ReminderPlugin) Help(channel string, parts []string) {
-	p.Bot.SendMessage(channel, "Pester someone with a reminder. Try \"remind <user> in <duration> message\".\n\nUnsure about duration syntax? Check https://golang.org/pkg/time/#ParseDuration")
+	p.Bot.SendMessage(channel, "Pester someone with a reminder. Try \"remind <user> in <duration> message\", or \"dm <user> in <duration> message\" to remind them privately.\n\nUnsure about duration syntax? Check https://golang.org/pkg/time/#ParseDuration")
 }
 
 func (p *ReminderPlugin) Event(kind string, message msg.Message) bool {
@@ -309,7 +334,8 @@ func reminderer(p *ReminderPlugin) {
 				reminder.from = "you"
 			}
 
-			message := fmt.Sprintf("Hey %s, %s wanted you to be reminded: %s", reminder.who, reminder.from, reminder.what)
+			who := p.Bot.Mention(user.User{Name: reminder.who})
+			message := fmt.Sprintf("Hey %s, %s wanted you to be reminded: %s", who, reminder.from, reminder.what)
 			p.Bot.SendMessage(reminder.channel, message)
 
 			if err:= p.deleteReminder(reminder.id); err != nil {
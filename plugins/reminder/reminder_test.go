@@ -176,3 +176,42 @@ func TestRegisterWeb(t *testing.T) {
 	assert.NotNil(t, c)
 	assert.Nil(t, c.RegisterWeb())
 }
+
+type dmBot struct {
+	*bot.MockBot
+	dms []user.User
+}
+
+func (b *dmBot) OpenDM(u user.User) (string, error) {
+	b.dms = append(b.dms, u)
+	return "dm-" + u.Name, nil
+}
+
+func TestReminderDM(t *testing.T) {
+	mb := &dmBot{MockBot: bot.NewMockBot()}
+	c := New(mb)
+	assert.NotNil(t, c)
+	m := makeMessage("!dm me in 1h don't fail this test")
+	m.User.ID = "U1"
+	res := c.Message(m)
+	assert.True(t, res)
+	assert.Equal(t, []user.User{{ID: "U1", Name: "tester"}}, mb.dms)
+	r := c.getNextReminder()
+	if assert.NotNil(t, r) {
+		assert.Equal(t, "dm-tester", r.channel)
+		assert.Equal(t, "tester", r.who)
+	}
+}
+
+func TestReminderDMBadRequest(t *testing.T) {
+	mb := &dmBot{MockBot: bot.NewMockBot()}
+	c := New(mb)
+	assert.NotNil(t, c)
+	res := c.Message(makeMessage("!dm bob in xyz don't fail this test"))
+	assert.True(t, res)
+	res = c.Message(makeMessage("!dm bob at 1h don't fail this test"))
+	assert.True(t, res)
+	assert.Empty(t, mb.dms)
+	assert.Len(t, mb.Messages, 2)
+	assert.Nil(t, c.getNextReminder())
+}
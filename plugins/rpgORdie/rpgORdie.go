@@ -8,12 +8,14 @@ import (
 	"github.com/velour/catbase/bot/msg"
 )
 
-const (
-	DUDE    = ":lion_face:"
-	BOULDER = ":full_moon:"
-	HOLE    = ":new_moon:"
-	EMPTY   = ":white_large_square:"
+var (
+	DUDE    = msg.Emoji("lion_face")
+	BOULDER = msg.Emoji("full_moon")
+	HOLE    = msg.Emoji("new_moon")
+	EMPTY   = msg.Emoji("white_large_square")
+)
 
+const (
 	OK      = iota
 	INVALID = iota
 	WIN     = iota
@@ -39,7 +41,7 @@ func NewRandomBoard() *board {
 	for i := 0; i < boardSize; i++ {
 		b.state[i] = make([]string, boardSize)
 		for j := 0; j < boardSize; j++ {
-			b.state[i][j] = ":white_large_square:"
+			b.state[i][j] = EMPTY
 		}
 	}
 
@@ -107,7 +109,7 @@ func New(b bot.Bot) *RPGPlugin {
 func (p *RPGPlugin) Message(message msg.Message) bool {
 	if strings.ToLower(message.Body) == "start rpg" {
 		b := NewRandomBoard()
-		ts := p.Bot.SendMessage(message.Channel, b.toMessageString())
+		ts := p.Bot.SendMessage(message.Channel, b.toMessageString(), message)
 		p.listenFor[ts] = b
 		p.Bot.ReplyToMessageIdentifier(message.Channel, "Over here.", ts)
 		return true
@@ -2,6 +2,7 @@ package rss
 
 import (
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
@@ -13,37 +14,37 @@ import (
 
 type RSSPlugin struct {
 	Bot       bot.Bot
-	cache     map[string]*cacheItem
+	cache     *bot.KV
 	shelfLife time.Duration
 	maxLines  int
 }
 
 type cacheItem struct {
-	key         string
-	data        []string
-	currentLine int
-	expiration  time.Time
+	Key         string
+	Data        []string
+	CurrentLine int
+	Expiration  time.Time
 }
 
 func (c *cacheItem) getCurrentPage(maxLines int) string {
-	if len(c.data) <= maxLines {
-		return strings.Join(c.data, "\n")
+	if len(c.Data) <= maxLines {
+		return strings.Join(c.Data, "\n")
 	}
 
-	start := c.currentLine
+	start := c.CurrentLine
 	end := start + maxLines
-	if end > len(c.data) {
-		end = len(c.data)
+	if end > len(c.Data) {
+		end = len(c.Data)
 	}
 
-	page := strings.Join(c.data[start:end], "\n")
+	page := strings.Join(c.Data[start:end], "\n")
 
 	if end - start == maxLines {
-		c.currentLine = end
+		c.CurrentLine = end
 	} else {
-		c.currentLine = maxLines-(end-start)
+		c.CurrentLine = maxLines-(end-start)
 		page += "\n"
-		page += strings.Join(c.data[0:c.currentLine], "\n")
+		page += strings.Join(c.Data[0:c.CurrentLine], "\n")
 	}
 
 	return page
@@ -52,7 +53,7 @@ func (c *cacheItem) getCurrentPage(maxLines int) string {
 func New(bot bot.Bot) *RSSPlugin {
 	return &RSSPlugin{
 		Bot:       bot,
-		cache:     map[string]*cacheItem{},
+		cache:     bot.KV("rss"),
 		shelfLife: time.Minute * 20,
 		maxLines:  5,
 	}
@@ -63,32 +64,36 @@ func (p *RSSPlugin) Message(message msg.Message) bool {
 	numTokens := len(tokens)
 
 	if numTokens == 2 && strings.ToLower(tokens[0]) == "rss" {
-		if item, ok := p.cache[strings.ToLower(tokens[1])]; ok && time.Now().Before(item.expiration) {
-			p.Bot.SendMessage(message.Channel, item.getCurrentPage(p.maxLines))
-			return true
-		} else {
+		key := strings.ToLower(tokens[1])
+		item := &cacheItem{}
+		if ok, err := p.cache.Get(key, item); err != nil || !ok {
+			if err != nil {
+				log.Printf("Could not get cached feed %s: %s", key, err)
+			}
 			fp := gofeed.NewParser()
 			feed, err := fp.ParseURL(tokens[1])
 			if err != nil {
-				p.Bot.SendMessage(message.Channel, fmt.Sprintf("RSS error: %s", err.Error()))
+				p.Bot.SendMessage(message.Channel, fmt.Sprintf("RSS error: %s", err.Error()), message)
 				return true
 			}
-			item := &cacheItem{
-				key:         strings.ToLower(tokens[1]),
-				data:        []string{feed.Title},
-				expiration:  time.Now().Add(p.shelfLife),
-				currentLine: 0,
+			item = &cacheItem{
+				Key:         key,
+				Data:        []string{feed.Title},
+				Expiration:  time.Now().Add(p.shelfLife),
+				CurrentLine: 0,
 			}
 
 			for _, feedItem := range feed.Items {
-				item.data = append(item.data, feedItem.Title)
+				item.Data = append(item.Data, feedItem.Title)
 			}
+		}
 
-			p.cache[strings.ToLower(tokens[1])] = item
-
-			p.Bot.SendMessage(message.Channel, item.getCurrentPage(p.maxLines))
-			return true
+		p.Bot.SendMessage(message.Channel, item.getCurrentPage(p.maxLines), message)
+		// the page moved on, save where to but keep when it expires
+		if err := p.cache.SetTTL(key, item, item.Expiration.Sub(time.Now())); err != nil {
+			log.Printf("Could not cache feed %s: %s", key, err)
 		}
+		return true
 	}
 
 	return false
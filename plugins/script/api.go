@@ -0,0 +1,153 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package script
+
+import (
+	"log"
+	"strings"
+
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// api builds the bot table a script is given:
+//
+//	bot.nick                          the bot's name
+//	bot.command(name, fn(msg, args))  runs fn for the command name; it
+//	                                  handles the message unless it returns
+//	                                  false
+//	bot.on_message(fn(msg))           runs fn for every message; returning
+//	                                  true handles it
+//	bot.on_event(fn(kind, msg))       runs fn for every event
+//	bot.send(channel, text[, thread]) returns the new message's id, posted
+//	                                  in the thread if there's one, like
+//	                                  a message's thread_id
+//	bot.action(channel, text[, thread])
+//	                                  the same, as an action
+//	bot.reply(channel, text, id)      returns the new message's id
+//	bot.react(channel, reaction, id)  returns whether it worked
+//	bot.filter(msg, text)             fills in variables like $nick
+//	bot.get(key), bot.set(key, value), bot.delete(key)
+//	                                  the script's own stored values
+//
+// Messages are tables with id, thread_id, channel, user, body, raw, command,
+// action, time (in Unix seconds), kind, target and reaction.
+func (p *ScriptPlugin) api(s *script) *lua.LTable {
+	L := s.L
+	t := L.NewTable()
+	t.RawSetString("nick", lua.LString(p.Bot.Config().Nick))
+
+	fns := map[string]lua.LGFunction{
+		"command": func(L *lua.LState) int {
+			s.commands[strings.ToLower(L.CheckString(1))] = L.CheckFunction(2)
+			return 0
+		},
+		"on_message": func(L *lua.LState) int {
+			s.onMessage = append(s.onMessage, L.CheckFunction(1))
+			return 0
+		},
+		"on_event": func(L *lua.LState) int {
+			s.onEvent = append(s.onEvent, L.CheckFunction(1))
+			return 0
+		},
+		"send": func(L *lua.LState) int {
+			channel := L.CheckString(1)
+			L.Push(lua.LString(p.Bot.SendMessage(channel, L.CheckString(2), inThread(channel, L.OptString(3, ""))...)))
+			return 1
+		},
+		"action": func(L *lua.LState) int {
+			channel := L.CheckString(1)
+			L.Push(lua.LString(p.Bot.SendAction(channel, L.CheckString(2), inThread(channel, L.OptString(3, ""))...)))
+			return 1
+		},
+		"reply": func(L *lua.LState) int {
+			id, _ := p.Bot.ReplyToMessageIdentifier(L.CheckString(1), L.CheckString(2), L.CheckString(3))
+			L.Push(lua.LString(id))
+			return 1
+		},
+		"react": func(L *lua.LState) int {
+			channel := L.CheckString(1)
+			ok := p.Bot.React(channel, L.CheckString(2), msg.Message{ID: L.CheckString(3), Channel: channel})
+			L.Push(lua.LBool(ok))
+			return 1
+		},
+		"filter": func(L *lua.LState) int {
+			m := L.CheckTable(1)
+			message := msg.Message{
+				User:    &user.User{Name: lua.LVAsString(m.RawGetString("user"))},
+				Channel: lua.LVAsString(m.RawGetString("channel")),
+			}
+			L.Push(lua.LString(p.Bot.Filter(message, L.CheckString(2))))
+			return 1
+		},
+		"get": func(L *lua.LState) int {
+			var value string
+			ok, err := s.kv.Get(L.CheckString(1), &value)
+			if err != nil {
+				log.Printf("script %s: get: %s", s.name, err)
+			}
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(lua.LString(value))
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			if err := s.kv.Set(L.CheckString(1), L.CheckString(2)); err != nil {
+				L.RaiseError("set: %s", err)
+			}
+			return 0
+		},
+		"delete": func(L *lua.LState) int {
+			if err := s.kv.Delete(L.CheckString(1)); err != nil {
+				L.RaiseError("delete: %s", err)
+			}
+			return 0
+		},
+	}
+	for name, fn := range fns {
+		t.RawSetString(name, L.NewFunction(fn))
+	}
+
+	// print goes to the log rather than the bot's stdout
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		parts := []string{}
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		log.Printf("script %s: %s", s.name, strings.Join(parts, "\t"))
+		return 0
+	}))
+	return t
+}
+
+// inThread is the replyTo that makes SendMessage post in thread, if there is
+// one.
+func inThread(channel, thread string) []msg.Message {
+	if thread == "" {
+		return nil
+	}
+	return []msg.Message{{Channel: channel, ThreadID: thread}}
+}
+
+// messageTable converts a message for a script.
+func messageTable(L *lua.LState, message msg.Message) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("id", lua.LString(message.ID))
+	t.RawSetString("thread_id", lua.LString(message.ThreadID))
+	t.RawSetString("channel", lua.LString(message.Channel))
+	if message.User != nil {
+		t.RawSetString("user", lua.LString(message.User.Name))
+	}
+	t.RawSetString("body", lua.LString(message.Body))
+	t.RawSetString("raw", lua.LString(message.Raw))
+	t.RawSetString("command", lua.LBool(message.Command))
+	t.RawSetString("action", lua.LBool(message.Action))
+	t.RawSetString("time", lua.LNumber(message.Time.Unix()))
+	t.RawSetString("kind", lua.LString(message.Kind))
+	t.RawSetString("target", lua.LString(message.Target))
+	t.RawSetString("reaction", lua.LString(message.Reaction))
+	return t
+}
@@ -0,0 +1,273 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package script runs Lua scripts as plugins. Each .lua file in the scripts
+// directory is a script, and the API it gets is described in api.go.
+package script
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// callTimeout bounds how long a script may run each time it is called.
+const callTimeout = time.Second
+
+type ScriptPlugin struct {
+	Bot bot.Bot
+
+	mu      sync.RWMutex
+	scripts []*script
+}
+
+// script is one loaded Lua script. Lua states aren't safe for concurrent use,
+// so each is only used with mu held.
+type script struct {
+	name string
+	kv   *bot.KV
+
+	mu        sync.Mutex
+	L         *lua.LState
+	commands  map[string]*lua.LFunction
+	onMessage []*lua.LFunction
+	onEvent   []*lua.LFunction
+}
+
+// New creates a ScriptPlugin and loads the scripts.
+func New(b bot.Bot) *ScriptPlugin {
+	p := &ScriptPlugin{Bot: b}
+	if _, errs := p.load(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Println(err)
+		}
+	}
+	return p
+}
+
+func (p *ScriptPlugin) dir() string {
+	if d := p.Bot.Config().Scripts.Dir; d != "" {
+		return d
+	}
+	return "scripts"
+}
+
+// load (re)loads every script, replacing those running. Scripts that fail to
+// load are left out and their errors returned.
+func (p *ScriptPlugin) load() (int, []error) {
+	files, err := filepath.Glob(filepath.Join(p.dir(), "*.lua"))
+	if err != nil {
+		return 0, []error{err}
+	}
+	sort.Strings(files)
+
+	var errs []error
+	scripts := []*script{}
+	for _, f := range files {
+		s, err := p.loadScript(f)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", filepath.Base(f), err))
+			continue
+		}
+		scripts = append(scripts, s)
+	}
+
+	p.mu.Lock()
+	old := p.scripts
+	p.scripts = scripts
+	p.mu.Unlock()
+	for _, s := range old {
+		s.mu.Lock()
+		s.L.Close()
+		s.mu.Unlock()
+	}
+	log.Printf("Loaded %d scripts from %s", len(scripts), p.dir())
+	return len(scripts), errs
+}
+
+func (p *ScriptPlugin) loadScript(file string) (*script, error) {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(file), ".lua")
+	s := &script{
+		name:     name,
+		kv:       p.Bot.KV("script/" + name),
+		L:        sandbox(),
+		commands: map[string]*lua.LFunction{},
+	}
+	s.L.SetGlobal("bot", p.api(s))
+
+	fn, err := s.L.LoadString(string(src))
+	if err != nil {
+		s.L.Close()
+		return nil, err
+	}
+	if _, err := s.call(fn); err != nil {
+		s.L.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sandbox creates a Lua state with only the libraries that can't reach
+// outside of it.
+func sandbox() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// call runs a Lua function with a time limit and returns its first result.
+// The caller must hold s.mu, except while loading.
+func (s *script) call(fn *lua.LFunction, args ...lua.LValue) (lua.LValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+	if err := s.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		return lua.LNil, err
+	}
+	ret := s.L.Get(-1)
+	s.L.Pop(1)
+	return ret, nil
+}
+
+func (p *ScriptPlugin) loaded() []*script {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.scripts
+}
+
+func (p *ScriptPlugin) Message(message msg.Message) bool {
+	if message.Command && strings.ToLower(strings.TrimSpace(message.Body)) == "reload scripts" {
+		p.reload(message)
+		return true
+	}
+
+	fields := strings.Fields(message.Body)
+	for _, s := range p.loaded() {
+		if message.Command && len(fields) > 0 && s.command(message, fields[0]) {
+			return true
+		}
+		if s.message(message) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ScriptPlugin) reload(message msg.Message) {
+	if !p.Bot.CheckAdmin(message.User.Name) {
+		p.Bot.SendMessage(message.Channel, "You're not the boss of me.", message)
+		return
+	}
+	n, errs := p.load()
+	reply := fmt.Sprintf("Loaded %d scripts.", n)
+	for _, err := range errs {
+		reply += "\n" + err.Error()
+	}
+	p.Bot.SendMessage(message.Channel, reply, message)
+}
+
+// command runs the script's command of that name, if it has one. Commands
+// handle the message unless they return false.
+func (s *script) command(message msg.Message, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn, ok := s.commands[strings.ToLower(name)]
+	if !ok {
+		return false
+	}
+	args := strings.TrimSpace(strings.TrimPrefix(message.Body, name))
+	ret, err := s.call(fn, messageTable(s.L, message), lua.LString(args))
+	if err != nil {
+		log.Printf("script %s: command %s: %s", s.name, name, err)
+		return true
+	}
+	return ret != lua.LFalse
+}
+
+// message offers the message to the script's message handlers, which handle
+// it by returning true.
+func (s *script) message(message msg.Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, fn := range s.onMessage {
+		ret, err := s.call(fn, messageTable(s.L, message))
+		if err != nil {
+			log.Printf("script %s: on_message: %s", s.name, err)
+			continue
+		}
+		if lua.LVAsBool(ret) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ScriptPlugin) Event(kind string, message msg.Message) bool {
+	for _, s := range p.loaded() {
+		s.mu.Lock()
+		for _, fn := range s.onEvent {
+			ret, err := s.call(fn, lua.LString(kind), messageTable(s.L, message))
+			if err != nil {
+				log.Printf("script %s: on_event: %s", s.name, err)
+				continue
+			}
+			if lua.LVAsBool(ret) {
+				s.mu.Unlock()
+				return true
+			}
+		}
+		s.mu.Unlock()
+	}
+	return false
+}
+
+func (p *ScriptPlugin) ReplyMessage(message msg.Message, identifier string) bool { return false }
+func (p *ScriptPlugin) BotMessage(message msg.Message) bool                      { return false }
+
+func (p *ScriptPlugin) Help(channel string, parts []string) {
+	commands := []string{}
+	for _, s := range p.loaded() {
+		s.mu.Lock()
+		for name := range s.commands {
+			commands = append(commands, name)
+		}
+		s.mu.Unlock()
+	}
+	sort.Strings(commands)
+	text := "Lua scripts from " + p.dir() + ", reloaded by an admin with 'reload scripts'."
+	if len(commands) > 0 {
+		text += " Commands: " + strings.Join(commands, ", ")
+	}
+	p.Bot.SendMessage(channel, text)
+}
+
+func (p *ScriptPlugin) RegisterWeb() *string { return nil }
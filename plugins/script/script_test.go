@@ -0,0 +1,150 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package script
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+)
+
+// adminBot makes everyone an admin.
+type adminBot struct {
+	*bot.MockBot
+}
+
+func (b adminBot) CheckAdmin(string) bool { return true }
+
+func makeMessage(payload string) msg.Message {
+	isCmd := strings.HasPrefix(payload, "!")
+	if isCmd {
+		payload = payload[1:]
+	}
+	return msg.Message{
+		User:    &user.User{Name: "tester"},
+		Channel: "test",
+		Body:    payload,
+		Command: isCmd,
+	}
+}
+
+func makePlugin(t *testing.T, scripts map[string]string) (*ScriptPlugin, *bot.MockBot, string) {
+	dir, err := ioutil.TempDir("", "scripts")
+	assert.Nil(t, err)
+	for name, src := range scripts {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644))
+	}
+	mb := bot.NewMockBot()
+	mb.Cfg.Scripts.Dir = dir
+	p := New(adminBot{mb})
+	assert.NotNil(t, p)
+	return p, mb, dir
+}
+
+const greet = `
+bot.command("greet", function(m, args)
+	bot.send(m.channel, "hello " .. args .. " from " .. m.user)
+end)
+bot.command("maybe", function(m, args)
+	return false
+end)
+bot.on_message(function(m)
+	if m.body:find("lua") then
+		bot.action(m.channel, "loves lua")
+		return true
+	end
+end)
+`
+
+// threadBot records the thread each message is sent to.
+type threadBot struct {
+	adminBot
+	threads []string
+}
+
+func (b *threadBot) SendMessage(channel, message string, replyTo ...msg.Message) string {
+	thread := ""
+	if len(replyTo) > 0 {
+		thread = replyTo[0].ThreadID
+	}
+	b.threads = append(b.threads, thread)
+	return b.MockBot.SendMessage(channel, message, replyTo...)
+}
+
+func TestSendInThread(t *testing.T) {
+	_, mb, dir := makePlugin(t, map[string]string{"echo.lua": `
+bot.command("echo", function(m, args)
+	bot.send(m.channel, args, m.thread_id)
+end)`})
+	defer os.RemoveAll(dir)
+	b := &threadBot{adminBot: adminBot{mb}}
+	p := New(b)
+
+	m := makeMessage("!echo threaded")
+	m.ThreadID = "1.1"
+	assert.True(t, p.Message(m))
+	assert.True(t, p.Message(makeMessage("!echo flat")))
+	assert.Equal(t, []string{"threaded", "flat"}, mb.Messages)
+	assert.Equal(t, []string{"1.1", ""}, b.threads)
+}
+
+func TestCommandsAndMessages(t *testing.T) {
+	p, mb, dir := makePlugin(t, map[string]string{"greet.lua": greet})
+	defer os.RemoveAll(dir)
+
+	assert.True(t, p.Message(makeMessage("!greet world")))
+	assert.False(t, p.Message(makeMessage("greet world")))
+	assert.False(t, p.Message(makeMessage("!maybe")))
+	assert.True(t, p.Message(makeMessage("I like lua")))
+	assert.False(t, p.Message(makeMessage("I like go")))
+	assert.Equal(t, []string{"hello world from tester"}, mb.Messages)
+	assert.Equal(t, []string{"loves lua"}, mb.Actions)
+}
+
+func TestKV(t *testing.T) {
+	p, mb, dir := makePlugin(t, map[string]string{"count.lua": `
+bot.command("count", function(m)
+	local n = tonumber(bot.get("n") or "0") + 1
+	bot.set("n", tostring(n))
+	bot.send(m.channel, tostring(n))
+end)
+`})
+	defer os.RemoveAll(dir)
+
+	p.Message(makeMessage("!count"))
+	p.Message(makeMessage("!count"))
+	assert.Equal(t, []string{"1", "2"}, mb.Messages)
+}
+
+func TestSandbox(t *testing.T) {
+	p, mb, dir := makePlugin(t, map[string]string{
+		"io.lua":   `io.open("/etc/passwd")`,
+		"os.lua":   `os.exit(1)`,
+		"loop.lua": `while true do end`,
+		"ok.lua":   `bot.command("ok", function(m) bot.send(m.channel, type(dofile)) end)`,
+	})
+	defer os.RemoveAll(dir)
+
+	assert.Len(t, p.loaded(), 1)
+	p.Message(makeMessage("!ok"))
+	assert.Equal(t, []string{"nil"}, mb.Messages)
+}
+
+func TestReload(t *testing.T) {
+	p, mb, dir := makePlugin(t, nil)
+	defer os.RemoveAll(dir)
+
+	assert.False(t, p.Message(makeMessage("!greet world")))
+	ioutil.WriteFile(filepath.Join(dir, "greet.lua"), []byte(greet), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.lua"), []byte(`this is not lua`), 0644)
+	assert.True(t, p.Message(makeMessage("!reload scripts")))
+	assert.Contains(t, mb.Messages[0], "Loaded 1 scripts.\nbroken.lua:")
+	assert.True(t, p.Message(makeMessage("!greet world")))
+}
@@ -12,9 +12,9 @@ import (
 	"github.com/velour/catbase/bot/msg"
 )
 
-const (
-	BOULDER  = ":full_moon:"
-	MOUNTAIN = ":new_moon:"
+var (
+	BOULDER  = msg.Emoji("full_moon")
+	MOUNTAIN = msg.Emoji("new_moon")
 )
 
 type SisyphusPlugin struct {
@@ -154,7 +154,7 @@ func (g *game) toMessageString() string {
 		if i == g.current {
 			out = out + BOULDER
 		} else if i == g.current+1 {
-			out = out + ":" + g.who + ":"
+			out = out + msg.Emoji(g.who)
 		}
 		out = out + "\n"
 	}
@@ -218,7 +218,7 @@ func (p *SisyphusPlugin) ReplyMessage(message msg.Message, identifier string) bo
 				} else {
 					p.Bot.ReplyToMessageIdentifier(message.Channel, "you lose", identifier)
 					msg := fmt.Sprintf("%s just lost the sisyphus game after %s", g.who, time.Now().Sub(g.start))
-					p.Bot.SendMessage(message.Channel, msg)
+					p.Bot.SendMessage(message.Channel, msg, message)
 					g.endGame()
 				}
 			} else {
@@ -0,0 +1,262 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package stats
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// The buckets of the collectors New adds, besides the four above.
+const (
+	WordBucket             = "word"
+	LinkBucket             = "link"
+	EmojiBucket            = "emoji"
+	CommandBucket          = "command"
+	ReactionGivenBucket    = "reaction_given"
+	ReactionReceivedBucket = "reaction_received"
+	LengthBucket           = "length"
+)
+
+// BotKind is the kind collectors see for the bot's own messages. Messages
+// from everyone else have no kind, and events have the event's.
+const BotKind = "BOT"
+
+// A Collector counts things in a message or event, by key. The counts are
+// added to the day's totals in the bucket the collector was added under.
+type Collector func(kind string, message msg.Message) map[string]int
+
+type collector struct {
+	bucket  string
+	collect Collector
+}
+
+// AddCollector counts what c finds in everything the bot sees from now on,
+// in bucket.
+func (p *StatsPlugin) AddCollector(bucket string, c Collector) {
+	p.collectors = append(p.collectors, collector{bucket, c})
+}
+
+// buckets are the buckets stats are kept in, in the order they were added.
+func (p *StatsPlugin) buckets() []string {
+	buckets := []string{}
+	for _, c := range p.collectors {
+		buckets = append(buckets, c.bucket)
+	}
+	return append(buckets, CommandBucket)
+}
+
+func (p *StatsPlugin) addCollectors() {
+	p.AddCollector(UserBucket, p.collectUser)
+	p.AddCollector(HourBucket, p.collectHour)
+	p.AddCollector(ChannelBucket, p.collectChannel)
+	p.AddCollector(SightingBucket, p.collectSightings)
+	p.AddCollector(WordBucket, p.collectWords)
+	p.AddCollector(LinkBucket, collectLinks)
+	p.AddCollector(EmojiBucket, collectEmoji)
+	p.AddCollector(ReactionGivenBucket, collectReactionsGiven)
+	p.AddCollector(ReactionReceivedBucket, p.authors.collectReactionsReceived)
+	p.AddCollector(LengthBucket, collectLength)
+}
+
+func (p *StatsPlugin) collectUser(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	return map[string]int{message.User.Name: 1}
+}
+
+func (p *StatsPlugin) collectHour(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	return map[string]int{strconv.Itoa(time.Now().Hour()): 1}
+}
+
+func (p *StatsPlugin) collectChannel(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	return map[string]int{message.Channel: 1}
+}
+
+func (p *StatsPlugin) collectSightings(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, name := range p.bot.Config().Stats.Sightings {
+		if strings.Contains(message.Body, name+" sighting") {
+			counts[name]++
+		}
+	}
+	return counts
+}
+
+// stopwords are words too common to count.
+var stopwords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a about after all also am an and any are
+		as at back be because been but by can could did do does don't for from
+		get go got had has have he her him his how i i'm if in into is it it's
+		its just know like me more my no not now of on one only or other our
+		out so some than that that's the their them then there they this to
+		too up us was we were what when which who will with would you your`) {
+		stopwords[w] = true
+	}
+}
+
+// link finds links in message bodies.
+var link = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// shortcode finds emoji like :tea:.
+var shortcode = regexp.MustCompile(`:([a-z0-9_+\-]+):`)
+
+// collectWords counts the words people say, leaving out links, emoji and
+// stopwords, the built in ones and Stats.Stopwords.
+func (p *StatsPlugin) collectWords(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	extra := map[string]bool{}
+	for _, w := range p.bot.Config().Stats.Stopwords {
+		extra[strings.ToLower(w)] = true
+	}
+	body := link.ReplaceAllString(strings.ToLower(message.Body), " ")
+	body = shortcode.ReplaceAllString(body, " ")
+	counts := map[string]int{}
+	for _, w := range strings.FieldsFunc(body, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	}) {
+		w = strings.Trim(w, "'")
+		if len([]rune(w)) < 2 || stopwords[w] || extra[w] || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		counts[w]++
+	}
+	return counts
+}
+
+// collectLinks counts the sites people link to, without any www.
+func collectLinks(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, l := range link.FindAllString(message.Body, -1) {
+		u, err := url.Parse(strings.TrimRight(l, ".,;:!?)'"))
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		counts[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")]++
+	}
+	return counts
+}
+
+// collectEmoji counts emoji in messages, by shortcode if they're written
+// that way, and reactions.
+func collectEmoji(kind string, message msg.Message) map[string]int {
+	counts := map[string]int{}
+	switch kind {
+	case msg.ReactionAdded:
+		counts[strings.Trim(message.Reaction, ":")]++
+	case "":
+		for _, m := range shortcode.FindAllStringSubmatch(message.Body, -1) {
+			counts[m[1]]++
+		}
+		for _, r := range message.Body {
+			if isEmoji(r) {
+				counts[string(r)]++
+			}
+		}
+	}
+	return counts
+}
+
+func isEmoji(r rune) bool {
+	return (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF)
+}
+
+func collectReactionsGiven(kind string, message msg.Message) map[string]int {
+	if kind != msg.ReactionAdded {
+		return nil
+	}
+	return map[string]int{message.User.Name: 1}
+}
+
+// maxAuthors is how many messages authors remembers who wrote.
+const maxAuthors = 1000
+
+// authors remembers who wrote recent messages, so reactions to them can be
+// counted for their authors.
+type authors struct {
+	mu    sync.Mutex
+	names map[string]string
+	ids   []string
+}
+
+func (a *authors) add(message msg.Message) {
+	if message.ID == "" || message.User == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.names == nil {
+		a.names = map[string]string{}
+	}
+	if _, ok := a.names[message.ID]; !ok {
+		a.ids = append(a.ids, message.ID)
+	}
+	a.names[message.ID] = message.User.Name
+	if len(a.ids) > maxAuthors {
+		delete(a.names, a.ids[0])
+		a.ids = a.ids[1:]
+	}
+}
+
+func (a *authors) collectReactionsReceived(kind string, message msg.Message) map[string]int {
+	if kind != msg.ReactionAdded {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if name, ok := a.names[message.Target]; ok {
+		return map[string]int{name: 1}
+	}
+	return nil
+}
+
+// lengths are the ranges collectLength sorts messages into, by their
+// smallest length in characters.
+var lengths = []struct {
+	min int
+	key string
+}{
+	{200, "200+"},
+	{100, "100-199"},
+	{50, "50-99"},
+	{10, "10-49"},
+	{0, "0-9"},
+}
+
+// collectLength counts how long messages are.
+func collectLength(kind string, message msg.Message) map[string]int {
+	if kind != "" {
+		return nil
+	}
+	n := len([]rune(message.Body))
+	for _, l := range lengths {
+		if n >= l.min {
+			return map[string]int{l.key: 1}
+		}
+	}
+	return nil
+}
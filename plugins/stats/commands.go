@@ -0,0 +1,165 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package stats
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+const statsHelp = "stats me [period], stats top [period], busiest hour [period] and " +
+	"sightings of <name> [period] tell you who's been talking and when. " +
+	"The period is today, week, month, year or ever, and a week if you don't say."
+
+// periods are the spans of days chat commands ask about, by name.
+var periods = map[string]struct {
+	days  int
+	label string
+}{
+	"today": {1, "today"},
+	"week":  {7, "this week"},
+	"month": {30, "this month"},
+	"year":  {365, "this year"},
+	"ever":  {0, "ever"},
+	"all":   {0, "ever"},
+}
+
+// periodRange is the days a period covers, ending today, and how to say it.
+// Zero days is all of them.
+func periodRange(period string) (from, to, label string, ok bool) {
+	p, ok := periods[period]
+	if !ok {
+		return "", "", "", false
+	}
+	to = mkDay()
+	if p.days > 0 {
+		from = time.Now().AddDate(0, 0, 1-p.days).Format(DayFormat)
+	}
+	return from, to, p.label, true
+}
+
+// handleCommand answers questions about the stats:
+//
+//	stats me [period]
+//	stats top [period]
+//	busiest hour [period]
+//	sightings of <name> [period]
+func (p *StatsPlugin) handleCommand(message msg.Message) bool {
+	parts := strings.Fields(strings.ToLower(message.Body))
+	if len(parts) < 2 {
+		return false
+	}
+	cmd, args := parts[0]+" "+parts[1], parts[2:]
+	if cmd != "stats me" && cmd != "stats top" && cmd != "busiest hour" && cmd != "sightings of" {
+		return false
+	}
+
+	period := "week"
+	if len(args) > 0 {
+		if _, ok := periods[args[len(args)-1]]; ok {
+			period, args = args[len(args)-1], args[:len(args)-1]
+		}
+	}
+	from, to, label, _ := periodRange(period)
+	if (cmd == "sightings of") != (len(args) > 0) {
+		p.bot.SendMessage(message.Channel, statsHelp, message)
+		return true
+	}
+	if p.store == nil {
+		p.bot.SendMessage(message.Channel, "I'm not keeping stats right now.", message)
+		return true
+	}
+
+	var reply string
+	var err error
+	switch cmd {
+	case "stats me":
+		reply, err = p.statsMe(message.User.Name, from, to, label)
+	case "stats top":
+		reply, err = p.statsTop(from, to, label)
+	case "busiest hour":
+		reply, err = p.busiestHour(from, to, label)
+	case "sightings of":
+		reply, err = p.sightingsOf(strings.Join(args, " "), from, to, label)
+	}
+	if err != nil {
+		log.Printf("Error querying stats: %s", err)
+		reply = "I lost count, sorry."
+	}
+	p.bot.SendMessage(message.Channel, reply, message)
+	return true
+}
+
+func (p *StatsPlugin) statsMe(nick, from, to, label string) (string, error) {
+	days, err := p.store.daily(UserBucket, nick, from, to)
+	if err != nil {
+		return "", err
+	}
+	total := value(0)
+	for _, d := range days {
+		total = total.add(d.Value)
+	}
+	return fmt.Sprintf("%s, you've said %s %s.", nick, times(total, "thing"), label), nil
+}
+
+func (p *StatsPlugin) statsTop(from, to, label string) (string, error) {
+	top, err := p.store.top(UserBucket, from, to, 5)
+	if err != nil {
+		return "", err
+	}
+	if len(top) == 0 {
+		return fmt.Sprintf("Nobody's said anything %s.", label), nil
+	}
+	talkers := []string{}
+	for _, t := range top {
+		talkers = append(talkers, fmt.Sprintf("%s (%d)", t.Key, t.Value))
+	}
+	return fmt.Sprintf("Top talkers %s: %s", label, strings.Join(talkers, ", ")), nil
+}
+
+func (p *StatsPlugin) busiestHour(from, to, label string) (string, error) {
+	top, err := p.store.top(HourBucket, from, to, 1)
+	if err != nil {
+		return "", err
+	}
+	if len(top) == 0 {
+		return fmt.Sprintf("It's been quiet %s.", label), nil
+	}
+	hour, _ := strconv.Atoi(top[0].Key)
+	return fmt.Sprintf("The busiest hour %s is %02d:00, with %s.",
+		label, hour, times(top[0].Value, "message")), nil
+}
+
+func (p *StatsPlugin) sightingsOf(name, from, to, label string) (string, error) {
+	key := ""
+	for _, s := range p.bot.Config().Stats.Sightings {
+		if strings.EqualFold(s, name) {
+			key = s
+		}
+	}
+	if key == "" {
+		return fmt.Sprintf("I'm not on the lookout for %s.", name), nil
+	}
+	days, err := p.store.daily(SightingBucket, key, from, to)
+	if err != nil {
+		return "", err
+	}
+	total := value(0)
+	for _, d := range days {
+		total = total.add(d.Value)
+	}
+	return fmt.Sprintf("%s has been sighted %s %s.", key, times(total, "time"), label), nil
+}
+
+// times says how many of thing there are, as in "1 thing" and "2 things".
+func times(v value, thing string) string {
+	if v == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", v, thing)
+}
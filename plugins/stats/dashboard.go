@@ -0,0 +1,184 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package stats
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var dashboardIndex = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Stats</title>
+		<link rel="stylesheet" href="http://yui.yahooapis.com/pure/0.1.0/pure-min.css">
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<style>
+			.bar { background: #0078e7; height: 1em; }
+			.chart td { padding: 0.2em 0.5em; }
+			.chart td.graph { width: 60%; }
+		</style>
+	</head>
+	<body style="padding: 1em;">
+	<form class="pure-form" method="GET">
+		<input type="date" name="from" value="{{.From}}">
+		<input type="date" name="to" value="{{.To}}">
+		<button type="submit" class="pure-button pure-button-primary">Show</button>
+		<a class="pure-button" href="?period=today">Today</a>
+		<a class="pure-button" href="?period=week">Week</a>
+		<a class="pure-button" href="?period=month">Month</a>
+		<a class="pure-button" href="?period=year">Year</a>
+		<a class="pure-button" href="?period=ever">Ever</a>
+	</form>
+	{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+	{{range .Charts}}
+	<h2>{{.Title}}</h2>
+	<p>
+		{{if $.Period}}
+		<a href="/stats/export?bucket={{.Bucket}}&period={{$.Period}}">CSV</a>
+		<a href="/stats/export?bucket={{.Bucket}}&period={{$.Period}}&format=json">JSON</a>
+		{{else}}
+		<a href="/stats/export?bucket={{.Bucket}}&from={{$.From}}&to={{$.To}}">CSV</a>
+		<a href="/stats/export?bucket={{.Bucket}}&from={{$.From}}&to={{$.To}}&format=json">JSON</a>
+		{{end}}
+	</p>
+	{{if .Bars}}
+	<table class="chart">
+		{{range .Bars}}
+		<tr>
+			<td>{{.Label}}</td>
+			<td class="graph"><div class="bar" style="width: {{.Percent}}%;"></div></td>
+			<td>{{.Value}}</td>
+		</tr>
+		{{end}}
+	</table>
+	{{else}}
+	<p>Nothing yet.</p>
+	{{end}}
+	{{end}}
+	</body>
+</html>
+`
+
+// bucketTitles head the dashboard's charts of the buckets New collects.
+var bucketTitles = map[string]string{
+	UserBucket:             "Users",
+	HourBucket:             "Hours",
+	ChannelBucket:          "Channels",
+	SightingBucket:         "Sightings",
+	WordBucket:             "Words",
+	LinkBucket:             "Links",
+	EmojiBucket:            "Emoji",
+	CommandBucket:          "Commands",
+	ReactionGivenBucket:    "Reactions given",
+	ReactionReceivedBucket: "Reactions received",
+	LengthBucket:           "Message lengths",
+}
+
+// chart is a bar chart on the dashboard.
+type chart struct {
+	Title  string
+	Bucket string
+	Bars   []bar
+}
+
+type bar struct {
+	Label   string
+	Value   value
+	Percent int
+}
+
+// mkChart draws totals, sized against the biggest.
+func mkChart(title string, totals []keyTotal, label func(string) string) chart {
+	c := chart{Title: title}
+	max := value(0)
+	for _, t := range totals {
+		if t.Value > max {
+			max = t.Value
+		}
+	}
+	for _, t := range totals {
+		b := bar{Label: label(t.Key), Value: t.Value}
+		if max > 0 {
+			b.Percent = int(100 * t.Value / max)
+		}
+		c.Bars = append(c.Bars, b)
+	}
+	return c
+}
+
+// hourChart draws every hour of the day in order, busy or not.
+func hourChart(totals []keyTotal) chart {
+	byHour := make([]keyTotal, 24)
+	for h := range byHour {
+		byHour[h].Key = strconv.Itoa(h)
+	}
+	for _, t := range totals {
+		if h, err := strconv.Atoi(t.Key); err == nil && h >= 0 && h < 24 {
+			byHour[h].Value = t.Value
+		}
+	}
+	return mkChart("Hours", byHour, func(k string) string {
+		h, _ := strconv.Atoi(k)
+		return fmt.Sprintf("%02d:00", h)
+	})
+}
+
+// serveDashboard shows charts of the channels, users and hours over the days
+// from and to, or a period like in the chat commands, or the last week.
+func (p *StatsPlugin) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	context := make(map[string]interface{})
+	from, to, err := requestRange(r)
+	if err != nil {
+		from, to, _ = dayRange("", "")
+	} else {
+		// the export links ask for the period too, since it may have no
+		// first day
+		context["Period"] = r.FormValue("period")
+	}
+	context["From"], context["To"] = from, to
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		context["Error"] = err.Error()
+	} else if p.store == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		context["Error"] = "Stats aren't being kept."
+	} else {
+		same := func(k string) string { return k }
+		charts := []chart{}
+		for _, bucket := range p.buckets() {
+			n := 20
+			if bucket == HourBucket {
+				n = 0
+			}
+			totals, err := p.store.top(bucket, from, to, n)
+			if err != nil {
+				log.Printf("Error querying stats: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				context["Error"] = "Error querying stats"
+				break
+			}
+			title, ok := bucketTitles[bucket]
+			if !ok {
+				title = bucket
+			}
+			c := mkChart(title, totals, same)
+			if bucket == HourBucket {
+				c = hourChart(totals)
+			}
+			c.Bucket = bucket
+			charts = append(charts, c)
+		}
+		context["Charts"] = charts
+	}
+
+	t, err := template.New("dashboardIndex").Parse(dashboardIndex)
+	if err != nil {
+		log.Println(err)
+	}
+	t.Execute(w, context)
+}
@@ -4,11 +4,11 @@
 package stats
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -25,23 +25,51 @@ const (
 	HourBucket     = "hour"
 	UserBucket     = "user"
 	SightingBucket = "sighting"
+	ChannelBucket  = "channel"
 )
 
+// defaultFlushSeconds is how often stats are written if the config doesn't say.
+const defaultFlushSeconds = 10
+
 type StatsPlugin struct {
 	bot    bot.Bot
 	config *config.Config
+	// store is nil if the stats DB couldn't be opened
+	store      *store
+	collectors []collector
+	authors    *authors
 }
 
 // New creates a new StatsPlugin with the Plugin interface
 func New(bot bot.Bot) *StatsPlugin {
 	p := StatsPlugin{
-		bot:    bot,
-		config: bot.Config(),
+		bot:     bot,
+		config:  bot.Config(),
+		authors: &authors{},
+	}
+	p.addCollectors()
+	secs := p.config.Stats.FlushSeconds
+	if secs <= 0 {
+		secs = defaultFlushSeconds
+	}
+	s, err := openStore(p.config.Stats.DBPath, time.Duration(secs)*time.Second)
+	if err != nil {
+		log.Printf("Not keeping stats: %s", err)
+	} else {
+		p.store = s
 	}
 	return &p
 
 }
 
+// Close writes the stats that are waiting to be and closes the stats DB.
+func (p *StatsPlugin) Close() error {
+	if p.store == nil {
+		return nil
+	}
+	return p.store.Close()
+}
+
 type stat struct {
 	// date formatted: DayFormat
 	day string
@@ -107,37 +135,20 @@ func openDB(path string) (*bolt.DB, error) {
 
 // statFromDB takes a location specification and returns the data at that path
 // Expected a string representation of the date formatted: DayFormat
-func statFromDB(path, day, bucket, key string) (stat, error) {
-	db, err := openDB(path)
-	if err != nil {
-		return stat{}, err
-	}
-	defer db.Close()
-	buk := []byte(bucket)
-	k := []byte(key)
-
-	tx, err := db.Begin(true)
-	if err != nil {
-		log.Println("statFromDB: Error beginning the Tx")
-		return stat{}, err
-	}
-	defer tx.Rollback()
-
-	d, err := tx.CreateBucketIfNotExists([]byte(day))
-	if err != nil {
-		log.Println("statFromDB: Error creating the bucket")
-		return stat{}, err
-	}
-	b, err := d.CreateBucketIfNotExists(buk)
+func statFromDB(db *bolt.DB, day, bucket, key string) (stat, error) {
+	var v []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		d := tx.Bucket([]byte(day))
+		if d == nil {
+			return nil
+		}
+		if b := d.Bucket([]byte(bucket)); b != nil {
+			v = b.Get([]byte(key))
+		}
+		return nil
+	})
 	if err != nil {
-		log.Println("statFromDB: Error creating the bucket")
-		return stat{}, err
-	}
-
-	v := b.Get(k)
-
-	if err := tx.Commit(); err != nil {
-		log.Println("statFromDB: Error commiting the Tx")
+		log.Println("statFromDB: Error reading the DB")
 		return stat{}, err
 	}
 
@@ -145,19 +156,18 @@ func statFromDB(path, day, bucket, key string) (stat, error) {
 		return stat{day, bucket, key, 0}, nil
 	}
 
-	return mkStat(day, buk, k, v)
+	return mkStat(day, []byte(bucket), []byte(key), v)
 }
 
-// toDB takes a stat and records it, adding to the value in the DB if necessary
-func (s stats) toDB(path string) error {
-	db, err := openDB(path)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	for _, stat := range s {
-		err = db.Update(func(tx *bolt.Tx) error {
+// toDB takes stats and records them in one transaction, adding to the values
+// in the DB if necessary
+func (s stats) toDB(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, stat := range s {
+			if stat.key == "" {
+				log.Println("Keys should not be empty")
+				continue
+			}
 			d, err := tx.CreateBucketIfNotExists([]byte(stat.day))
 			if err != nil {
 				log.Println("toDB: Error creating bucket")
@@ -183,97 +193,199 @@ func (s stats) toDB(path string) error {
 			if err != nil {
 				return err
 			}
-			if stat.key == "" {
-				log.Println("Keys should not be empty")
-				return nil
+			if err := b.Put([]byte(stat.key), v); err != nil {
+				return err
 			}
-			log.Printf("Putting value in: '%s' %b, %+v", stat.key, []byte(stat.key), stat)
-			err = b.Put([]byte(stat.key), v)
-			return err
-		})
-		if err != nil {
-			return err
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
-func (p *StatsPlugin) record(message msg.Message) {
-	statGenerators := []func(msg.Message) stats{
-		p.mkUserStat,
-		p.mkHourStat,
-		p.mkChannelStat,
-		p.mkSightingStat,
+// record adds up what the collectors find in a message or event.
+func (p *StatsPlugin) record(kind string, message msg.Message) {
+	if p.store == nil {
+		return
 	}
-
+	day := mkDay()
 	allStats := stats{}
-
-	for _, mk := range statGenerators {
-		stats := mk(message)
-		if stats != nil {
-			allStats = append(allStats, stats...)
+	for _, c := range p.collectors {
+		for key, n := range c.collect(kind, message) {
+			allStats = append(allStats, stat{day, c.bucket, key, value(n)})
 		}
 	}
-
-	allStats.toDB(p.bot.Config().Stats.DBPath)
+	p.store.add(allStats)
 }
 
 func (p *StatsPlugin) Message(message msg.Message) bool {
-	p.record(message)
+	p.authors.add(message)
+	p.record("", message)
+	if message.Command {
+		return p.handleCommand(message)
+	}
 	return false
 }
 
 func (p *StatsPlugin) Event(e string, message msg.Message) bool {
-	p.record(message)
+	p.record(e, message)
 	return false
 }
 
 func (p *StatsPlugin) BotMessage(message msg.Message) bool {
-	p.record(message)
+	p.record(BotKind, message)
 	return false
 }
 
-func (p *StatsPlugin) Help(e string, m []string) {
+// Handled counts the commands each plugin answers.
+func (p *StatsPlugin) Handled(name string, message msg.Message) {
+	if message.Command && p.store != nil {
+		p.store.add(stats{stat{mkDay(), CommandBucket, name, 1}})
+	}
+}
+
+func (p *StatsPlugin) Help(channel string, parts []string) {
+	p.bot.SendMessage(channel, statsHelp)
 }
 
+// serveQuery answers questions about the stats with JSON. Given a bucket it
+// returns the keys with the biggest totals, top of them if that's given, and
+// given a key as well it returns the key's value on each day. The days are
+// from and to, formatted with DayFormat, or a period like in the chat
+// commands, or the last week.
+//
+//	/stats/query?bucket=user&top=10&period=ever
+//	/stats/query?bucket=user&key=tester&from=2018-01-01&to=2018-01-31
 func (p *StatsPlugin) serveQuery(w http.ResponseWriter, r *http.Request) {
-	f, err := os.Open(p.bot.Config().Stats.DBPath)
-	defer f.Close()
+	if p.store == nil {
+		http.Error(w, "Stats aren't being kept", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := requestRange(r)
 	if err != nil {
-		log.Printf("Error opening DB for web service: %s", err)
-		fmt.Fprintf(w, "Error opening DB")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		http.Error(w, "Which bucket? One of "+strings.Join(p.buckets(), ", "), http.StatusBadRequest)
 		return
 	}
-	http.ServeContent(w, r, "stats.db", time.Now(), f)
-}
 
-func (p *StatsPlugin) RegisterWeb() *string {
-	http.HandleFunc("/stats", p.serveQuery)
-	tmp := "/stats"
-	return &tmp
+	var result interface{}
+	if key := q.Get("key"); key != "" {
+		result, err = p.store.daily(bucket, key, from, to)
+	} else {
+		n := 0
+		if top := q.Get("top"); top != "" {
+			if n, err = strconv.Atoi(top); err != nil {
+				http.Error(w, "top should be a number", http.StatusBadRequest)
+				return
+			}
+		}
+		result, err = p.store.top(bucket, from, to, n)
+	}
+	if err != nil {
+		log.Printf("Error querying stats: %s", err)
+		http.Error(w, "Error querying stats", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
-func (p *StatsPlugin) mkUserStat(message msg.Message) stats {
-	return stats{stat{mkDay(), UserBucket, message.User.Name, 1}}
+// serveExport downloads everything in a bucket over the days from and to,
+// or a period, or the last week, as CSV or, with format=json, JSON.
+//
+//	/stats/export?bucket=word&from=2018-01-01&to=2018-01-31&format=csv
+//	/stats/export?bucket=word&period=ever
+func (p *StatsPlugin) serveExport(w http.ResponseWriter, r *http.Request) {
+	if p.store == nil {
+		http.Error(w, "Stats aren't being kept", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	from, to, err := requestRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bucket, format := q.Get("bucket"), q.Get("format")
+	if bucket == "" {
+		http.Error(w, "Which bucket? One of "+strings.Join(p.buckets(), ", "), http.StatusBadRequest)
+		return
+	}
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		http.Error(w, "format should be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := p.store.rows(bucket, from, to)
+	if err != nil {
+		log.Printf("Error exporting stats: %s", err)
+		http.Error(w, "Error exporting stats", http.StatusInternalServerError)
+		return
+	}
+	days := from + "-" + to
+	if from == "" {
+		days = "until-" + to
+	}
+	name := fmt.Sprintf("stats-%s-%s.%s", bucket, days, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"day", "key", "value"})
+	for _, r := range rows {
+		cw.Write([]string{r.Day, r.Key, strconv.Itoa(int(r.Value))})
+	}
+	cw.Flush()
 }
 
-func (p *StatsPlugin) mkHourStat(message msg.Message) stats {
-	hr := time.Now().Hour()
-	return stats{stat{mkDay(), HourBucket, strconv.Itoa(hr), 1}}
+// requestRange is the days a web request asks about: a period, or from and
+// to as dayRange takes them.
+func requestRange(r *http.Request) (string, string, error) {
+	period := r.FormValue("period")
+	if period == "" {
+		return dayRange(r.FormValue("from"), r.FormValue("to"))
+	}
+	from, to, _, ok := periodRange(period)
+	if !ok {
+		return "", "", fmt.Errorf("period should be today, week, month, year or ever")
+	}
+	return from, to, nil
 }
 
-func (p *StatsPlugin) mkSightingStat(message msg.Message) stats {
-	stats := stats{}
-	for _, name := range p.bot.Config().Stats.Sightings {
-		if strings.Contains(message.Body, name+" sighting") {
-			stats = append(stats, stat{mkDay(), SightingBucket, name, 1})
-		}
+// dayRange checks the days a query is for, which default to the last week.
+func dayRange(from, to string) (string, string, error) {
+	if to == "" {
+		to = mkDay()
+	}
+	end, err := time.Parse(DayFormat, to)
+	if err != nil {
+		return "", "", fmt.Errorf("to should look like %s", DayFormat)
 	}
-	return stats
+	if from == "" {
+		from = end.AddDate(0, 0, -6).Format(DayFormat)
+	}
+	if _, err := time.Parse(DayFormat, from); err != nil {
+		return "", "", fmt.Errorf("from should look like %s", DayFormat)
+	}
+	return from, to, nil
 }
 
-func (p *StatsPlugin) mkChannelStat(message msg.Message) stats {
-	return stats{stat{mkDay(), "channel", message.Channel, 1}}
+func (p *StatsPlugin) RegisterWeb() *string {
+	http.HandleFunc("/stats", p.serveDashboard)
+	http.HandleFunc("/stats/query", p.serveQuery)
+	http.HandleFunc("/stats/export", p.serveExport)
+	tmp := "/stats"
+	return &tmp
 }
 
 func (p *StatsPlugin) ReplyMessage(message msg.Message, identifier string) bool { return false }
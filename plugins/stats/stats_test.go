@@ -2,10 +2,15 @@ package stats
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
@@ -43,10 +48,21 @@ func rmDB(t *testing.T) {
 	}
 }
 
+func openTestDB(t *testing.T) *bolt.DB {
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
 func TestWithDB(t *testing.T) {
 	rmDB(t)
 
 	t.Run("TestDBReadWrite", func(t *testing.T) {
+		db := openTestDB(t)
+		defer db.Close()
+
 		day := mkDay()
 		bucket := "testBucket"
 		key := "testKey"
@@ -58,10 +74,10 @@ func TestWithDB(t *testing.T) {
 			1,
 		}}
 
-		err := expected.toDB(dbPath)
+		err := expected.toDB(db)
 		assert.Nil(t, err)
 
-		actual, err := statFromDB(dbPath, day, bucket, key)
+		actual, err := statFromDB(db, day, bucket, key)
 		assert.Nil(t, err)
 
 		assert.Equal(t, actual, expected[0])
@@ -71,6 +87,9 @@ func TestWithDB(t *testing.T) {
 	rmDB(t)
 
 	t.Run("TestDBAddStatInLoop", func(t *testing.T) {
+		db := openTestDB(t)
+		defer db.Close()
+
 		day := mkDay()
 		bucket := "testBucket"
 		key := "testKey"
@@ -84,11 +103,11 @@ func TestWithDB(t *testing.T) {
 		}}
 
 		for i := 0; i < 5; i++ {
-			err := statPack.toDB(dbPath)
+			err := statPack.toDB(db)
 			assert.Nil(t, err)
 		}
 
-		actual, err := statFromDB(dbPath, day, bucket, key)
+		actual, err := statFromDB(db, day, bucket, key)
 		assert.Nil(t, err)
 
 		assert.Equal(t, actual.val, expected)
@@ -97,6 +116,9 @@ func TestWithDB(t *testing.T) {
 	rmDB(t)
 
 	t.Run("TestDBAddStats", func(t *testing.T) {
+		db := openTestDB(t)
+		defer db.Close()
+
 		day := mkDay()
 		bucket := "testBucket"
 		key := "testKey"
@@ -112,10 +134,10 @@ func TestWithDB(t *testing.T) {
 			})
 		}
 
-		err := statPack.toDB(dbPath)
+		err := statPack.toDB(db)
 		assert.Nil(t, err)
 
-		actual, err := statFromDB(dbPath, day, bucket, key)
+		actual, err := statFromDB(db, day, bucket, key)
 		assert.Nil(t, err)
 
 		assert.Equal(t, actual.val, expected)
@@ -144,6 +166,7 @@ func testUserCounter(t *testing.T, count int) {
 	mb.Cfg.Stats.DBPath = dbPath
 	s := New(mb)
 	assert.NotNil(t, s)
+	defer s.store.Close()
 
 	for i := 0; i < count; i++ {
 		s.Message(makeMessage("test"))
@@ -152,7 +175,8 @@ func testUserCounter(t *testing.T, count int) {
 	_, err := os.Stat(dbPath)
 	assert.Nil(t, err)
 
-	stat, err := statFromDB(mb.Config().Stats.DBPath, day, "user", "tester")
+	assert.Nil(t, s.store.flush())
+	stat, err := statFromDB(s.store.db, day, "user", "tester")
 	assert.Nil(t, err)
 	actual := stat.val
 	assert.Equal(t, actual, expected)
@@ -170,6 +194,7 @@ func TestMessages(t *testing.T) {
 		mb.Cfg.Stats.DBPath = dbPath
 		s := New(mb)
 		assert.NotNil(t, s)
+		defer s.store.Close()
 
 		for i := 0; i < count; i++ {
 			s.Message(makeMessage("test"))
@@ -178,7 +203,8 @@ func TestMessages(t *testing.T) {
 		_, err := os.Stat(dbPath)
 		assert.Nil(t, err)
 
-		stat, err := statFromDB(mb.Config().Stats.DBPath, day, "user", "tester")
+		assert.Nil(t, s.store.flush())
+		stat, err := statFromDB(s.store.db, day, "user", "tester")
 		assert.Nil(t, err)
 		actual := stat.val
 		assert.Equal(t, actual, expected)
@@ -194,6 +220,7 @@ func TestMessages(t *testing.T) {
 		mb.Cfg.Stats.DBPath = dbPath
 		s := New(mb)
 		assert.NotNil(t, s)
+		defer s.store.Close()
 
 		for i := 0; i < count; i++ {
 			s.Message(makeMessage("test"))
@@ -202,7 +229,8 @@ func TestMessages(t *testing.T) {
 		_, err := os.Stat(dbPath)
 		assert.Nil(t, err)
 
-		stat, err := statFromDB(mb.Config().Stats.DBPath, day, "user", "tester")
+		assert.Nil(t, s.store.flush())
+		stat, err := statFromDB(s.store.db, day, "user", "tester")
 		assert.Nil(t, err)
 		actual := stat.val
 		assert.Equal(t, actual, expected)
@@ -218,6 +246,7 @@ func TestMessages(t *testing.T) {
 		mb.Cfg.Stats.DBPath = dbPath
 		s := New(mb)
 		assert.NotNil(t, s)
+		defer s.store.Close()
 
 		for i := 0; i < count; i++ {
 			s.Message(makeMessage("test"))
@@ -226,7 +255,8 @@ func TestMessages(t *testing.T) {
 		_, err := os.Stat(dbPath)
 		assert.Nil(t, err)
 
-		stat, err := statFromDB(mb.Config().Stats.DBPath, day, "channel", "test")
+		assert.Nil(t, s.store.flush())
+		stat, err := statFromDB(s.store.db, day, "channel", "test")
 		assert.Nil(t, err)
 		actual := stat.val
 		assert.Equal(t, actual, expected)
@@ -245,6 +275,7 @@ func TestMessages(t *testing.T) {
 
 		s := New(mb)
 		assert.NotNil(t, s)
+		defer s.store.Close()
 
 		for i := 0; i < count; i++ {
 			s.Message(makeMessage("user sighting"))
@@ -253,7 +284,8 @@ func TestMessages(t *testing.T) {
 		_, err := os.Stat(dbPath)
 		assert.Nil(t, err)
 
-		stat, err := statFromDB(mb.Config().Stats.DBPath, day, "sighting", "user")
+		assert.Nil(t, s.store.flush())
+		stat, err := statFromDB(s.store.db, day, "sighting", "user")
 		assert.Nil(t, err)
 		actual := stat.val
 		assert.Equal(t, actual, expected)
@@ -272,6 +304,7 @@ func TestMessages(t *testing.T) {
 
 		s := New(mb)
 		assert.NotNil(t, s)
+		defer s.store.Close()
 
 		for i := 0; i < count; i++ {
 			s.Message(makeMessage("user sighting"))
@@ -280,7 +313,8 @@ func TestMessages(t *testing.T) {
 		_, err := os.Stat(dbPath)
 		assert.Nil(t, err)
 
-		stat, err := statFromDB(mb.Config().Stats.DBPath, day, "sighting", "user")
+		assert.Nil(t, s.store.flush())
+		stat, err := statFromDB(s.store.db, day, "sighting", "user")
 		assert.Nil(t, err)
 		actual := stat.val
 		assert.Equal(t, actual, expected)
@@ -288,3 +322,265 @@ func TestMessages(t *testing.T) {
 
 	rmDB(t)
 }
+
+func TestCloseWritesStats(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.DBPath = dbPath
+	mb.Cfg.Stats.FlushSeconds = 3600
+	s := New(mb)
+	s.Message(makeMessage("test"))
+	s.Message(makeMessage("test"))
+	assert.Nil(t, s.Close())
+
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stat, err := statFromDB(db, mkDay(), UserBucket, "tester")
+	assert.Nil(t, err)
+	assert.Equal(t, value(2), stat.val)
+}
+
+func TestQueries(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	s, err := openStore(dbPath, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.add(stats{
+		{"2018-01-01", UserBucket, "alice", 3},
+		{"2018-01-01", UserBucket, "bob", 1},
+		{"2018-01-02", UserBucket, "bob", 4},
+		{"2018-01-03", UserBucket, "carol", 1},
+		{"2018-01-09", UserBucket, "alice", 10},
+	})
+	s.add(stats{{"2018-01-02", UserBucket, "bob", 1}})
+
+	top, err := s.top(UserBucket, "2018-01-01", "2018-01-03", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []keyTotal{{"bob", 6}, {"alice", 3}, {"carol", 1}}, top)
+
+	top, err = s.top(UserBucket, "2018-01-01", "2018-01-31", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []keyTotal{{"alice", 13}}, top)
+
+	days, err := s.daily(UserBucket, "bob", "2018-01-01", "2018-01-31")
+	assert.Nil(t, err)
+	assert.Equal(t, []dayTotal{{"2018-01-01", 1}, {"2018-01-02", 5}}, days)
+
+	top, err = s.top(HourBucket, "2018-01-01", "2018-01-31", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, top)
+}
+
+func TestServeQuery(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.DBPath = dbPath
+	p := New(mb)
+	defer p.store.Close()
+	p.Message(makeMessage("test"))
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		p.serveQuery(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get("/stats/query?bucket=user")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `[{"key":"tester","value":1}]`, strings.TrimSpace(body))
+
+	code, body = get("/stats/query?bucket=channel&key=test&from=" + mkDay())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `[{"day":"`+mkDay()+`","value":1}]`, strings.TrimSpace(body))
+
+	code, body = get("/stats/query")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "word")
+	code, _ = get("/stats/query?bucket=user&from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/stats/query?bucket=user&top=lots")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestCommands(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.DBPath = dbPath
+	mb.Cfg.Stats.Sightings = []string{"Bigfoot"}
+	p := New(mb)
+	defer p.store.Close()
+	yesterday := time.Now().AddDate(0, 0, -1).Format(DayFormat)
+	p.store.add(stats{
+		{yesterday, UserBucket, "alice", 3},
+		{yesterday, HourBucket, "13", 20},
+		{"2000-01-01", UserBucket, "tester", 100},
+	})
+
+	assert.False(t, p.Message(makeMessage("Bigfoot sighting")))
+	for _, cmd := range []string{
+		"!stats me",
+		"!stats me ever",
+		"!stats top today",
+		"!stats top",
+		"!busiest hour",
+		"!sightings of bigfoot week",
+		"!sightings of nessie",
+		"!stats me and my friends",
+	} {
+		assert.True(t, p.Message(makeMessage(cmd)), cmd)
+	}
+	assert.False(t, p.Message(makeMessage("!stats")))
+	assert.False(t, p.Message(makeMessage("stats me")))
+	assert.Equal(t, []string{
+		"tester, you've said 2 things this week.",
+		"tester, you've said 103 things ever.",
+		"Top talkers today: tester (4)",
+		"Top talkers this week: tester (5), alice (3)",
+		"The busiest hour this week is 13:00, with 20 messages.",
+		"Bigfoot has been sighted 1 time this week.",
+		"I'm not on the lookout for nessie.",
+		statsHelp,
+	}, mb.Messages)
+}
+
+func TestDashboard(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.DBPath = dbPath
+	p := New(mb)
+	defer p.store.Close()
+	p.Message(makeMessage("test"))
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		p.serveDashboard(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get("/stats")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "tester")
+	assert.Contains(t, body, "<h2>Hours</h2>")
+	assert.Contains(t, body, "23:00")
+
+	code, body = get("/stats?period=ever")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "tester")
+	assert.Contains(t, body, "bucket=user&period=ever")
+
+	code, body = get("/stats?from=2000-01-01&to=2000-01-02")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "tester")
+
+	code, _ = get("/stats?period=fortnight")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestCollectors(t *testing.T) {
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.Stopwords = []string{"Cats"}
+	p := &StatsPlugin{bot: mb, authors: &authors{}}
+	m := makeMessage("The cats and the DOGS said hi, see https://www.Example.com/x and http://go.dev. :tea: 🍵 🍵")
+
+	assert.Equal(t, map[string]int{"dogs": 1, "said": 1, "hi": 1, "see": 1}, p.collectWords("", m))
+	assert.Equal(t, map[string]int{"example.com": 1, "go.dev": 1}, collectLinks("", m))
+	assert.Equal(t, map[string]int{"tea": 1, "🍵": 2}, collectEmoji("", m))
+	assert.Equal(t, map[string]int{"50-99": 1}, collectLength("", m))
+	assert.Nil(t, p.collectWords(BotKind, m))
+	assert.Equal(t, map[string]int{"0-9": 1}, collectLength("", makeMessage("")))
+
+	m.ID = "1"
+	p.authors.add(m)
+	reaction := msg.Message{
+		User:     &user.User{Name: "alice"},
+		Kind:     msg.ReactionAdded,
+		Target:   "1",
+		Reaction: "joy",
+	}
+	assert.Equal(t, map[string]int{"alice": 1}, collectReactionsGiven(reaction.Kind, reaction))
+	assert.Equal(t, map[string]int{"tester": 1}, p.authors.collectReactionsReceived(reaction.Kind, reaction))
+	assert.Equal(t, map[string]int{"joy": 1}, collectEmoji(reaction.Kind, reaction))
+	reaction.Target = "2"
+	assert.Nil(t, p.authors.collectReactionsReceived(reaction.Kind, reaction))
+	assert.Nil(t, collectReactionsGiven(msg.ReactionRemoved, reaction))
+
+	// only what people say counts for them, their channels and the hour
+	for _, kind := range []string{BotKind, msg.ReactionAdded} {
+		assert.Nil(t, p.collectUser(kind, reaction))
+		assert.Nil(t, p.collectHour(kind, reaction))
+		assert.Nil(t, p.collectChannel(kind, reaction))
+		assert.Nil(t, p.collectSightings(kind, reaction))
+	}
+}
+
+func TestAuthorsForget(t *testing.T) {
+	a := &authors{}
+	for i := 0; i < maxAuthors+10; i++ {
+		m := makeMessage("hi")
+		m.ID = strconv.Itoa(i)
+		a.add(m)
+	}
+	assert.Len(t, a.names, maxAuthors)
+	assert.Len(t, a.ids, maxAuthors)
+	_, ok := a.names["0"]
+	assert.False(t, ok)
+}
+
+func TestAddCollectorAndExport(t *testing.T) {
+	rmDB(t)
+	defer rmDB(t)
+	mb := bot.NewMockBot()
+	mb.Cfg.Stats.DBPath = dbPath
+	p := New(mb)
+	defer p.store.Close()
+	p.AddCollector("shouting", func(kind string, message msg.Message) map[string]int {
+		if message.Body != strings.ToUpper(message.Body) {
+			return nil
+		}
+		return map[string]int{message.User.Name: 1}
+	})
+	p.Message(makeMessage("HELLO"))
+	p.Message(makeMessage("hello"))
+	p.Handled("beers", makeMessage("!beers"))
+	p.Handled("beers", makeMessage("beers++"))
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		p.serveExport(w, httptest.NewRequest("GET", url, nil))
+		return w.Code, w.Body.String()
+	}
+	code, body := get("/stats/export?bucket=shouting")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "day,key,value\n"+mkDay()+",tester,1\n", body)
+
+	code, body = get("/stats/export?bucket=command&format=json")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `[{"day":"`+mkDay()+`","key":"beers","value":1}]`, strings.TrimSpace(body))
+
+	p.store.add(stats{{"2000-01-01", "shouting", "tester", 2}})
+	code, body = get("/stats/export?bucket=shouting&period=ever")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "day,key,value\n2000-01-01,tester,2\n"+mkDay()+",tester,1\n", body)
+	code, _ = get("/stats/export?bucket=shouting&period=fortnight")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = get("/stats/export")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "shouting")
+	code, _ = get("/stats/export?bucket=user&format=xml")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	w := httptest.NewRecorder()
+	p.serveDashboard(w, httptest.NewRequest("GET", "/stats", nil))
+	assert.Contains(t, w.Body.String(), "<h2>shouting</h2>")
+	assert.Contains(t, w.Body.String(), "<h2>Commands</h2>")
+}
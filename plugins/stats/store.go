@@ -0,0 +1,225 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package stats
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// store is the stats database, open for as long as the bot runs. Stats are
+// added up in memory and written in a batch every flush interval, so a busy
+// channel costs one write now and then instead of one per message.
+type store struct {
+	db *bolt.DB
+
+	mu      sync.Mutex
+	pending map[statKey]value
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// statKey is where a stat lives in the database.
+type statKey struct {
+	day, bucket, key string
+}
+
+// openStore opens the database at path and starts writing stats to it every
+// interval.
+func openStore(path string, interval time.Duration) (*store, error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{
+		db:      db,
+		pending: map[statKey]value{},
+		closed:  make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run(interval)
+	return s, nil
+}
+
+func (s *store) run(interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := s.flush(); err != nil {
+				log.Printf("Couldn't write stats: %s", err)
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// add adds stats to the next batch.
+func (s *store) add(st stats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stat := range st {
+		if stat.key == "" {
+			log.Println("Keys should not be empty")
+			continue
+		}
+		k := statKey{stat.day, stat.bucket, stat.key}
+		s.pending[k] = s.pending[k].add(stat.val)
+	}
+}
+
+// flush writes the batch. If that fails the stats are kept for next time.
+func (s *store) flush() error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = map[statKey]value{}
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	st := stats{}
+	for k, v := range batch {
+		st = append(st, stat{k.day, k.bucket, k.key, v})
+	}
+	if err := st.toDB(s.db); err != nil {
+		s.add(st)
+		return err
+	}
+	return nil
+}
+
+// Close writes what's left and closes the database.
+func (s *store) Close() error {
+	close(s.closed)
+	s.wg.Wait()
+	if err := s.flush(); err != nil {
+		log.Printf("Couldn't write stats: %s", err)
+	}
+	return s.db.Close()
+}
+
+// keyTotal is what a key in a bucket adds up to.
+type keyTotal struct {
+	Key   string `json:"key"`
+	Value value  `json:"value"`
+}
+
+// dayTotal is a key's value on a day.
+type dayTotal struct {
+	Day   string `json:"day"`
+	Value value  `json:"value"`
+}
+
+// forDays calls f with each day's bucket from the first day to the last,
+// both formatted with DayFormat and included.
+func (s *store) forDays(from, to string, f func(day string, b *bolt.Bucket) error) error {
+	if err := s.flush(); err != nil {
+		log.Printf("Couldn't write stats: %s", err)
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Cursor()
+		for k, _ := c.Seek([]byte(from)); k != nil && string(k) <= to; k, _ = c.Next() {
+			if b := tx.Bucket(k); b != nil {
+				if err := f(string(k), b); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// top adds up each key in bucket over the days and returns the n biggest,
+// or all of them if n isn't positive.
+func (s *store) top(bucket, from, to string, n int) ([]keyTotal, error) {
+	sums := map[string]value{}
+	err := s.forDays(from, to, func(day string, d *bolt.Bucket) error {
+		b := d.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			val, err := valueFromBytes(v)
+			if err != nil {
+				return err
+			}
+			sums[string(k)] = sums[string(k)].add(val)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	totals := []keyTotal{}
+	for k, v := range sums {
+		totals = append(totals, keyTotal{k, v})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Value != totals[j].Value {
+			return totals[i].Value > totals[j].Value
+		}
+		return totals[i].Key < totals[j].Key
+	})
+	if n > 0 && len(totals) > n {
+		totals = totals[:n]
+	}
+	return totals, nil
+}
+
+// daily returns key's value in bucket on each of the days that has one.
+func (s *store) daily(bucket, key, from, to string) ([]dayTotal, error) {
+	days := []dayTotal{}
+	err := s.forDays(from, to, func(day string, d *bolt.Bucket) error {
+		b := d.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		val, err := valueFromBytes(v)
+		if err != nil {
+			return err
+		}
+		days = append(days, dayTotal{day, val})
+		return nil
+	})
+	return days, err
+}
+
+// row is a key's value in a bucket on a day.
+type row struct {
+	Day   string `json:"day"`
+	Key   string `json:"key"`
+	Value value  `json:"value"`
+}
+
+// rows returns everything in bucket over the days, by day and then key.
+func (s *store) rows(bucket, from, to string) ([]row, error) {
+	rows := []row{}
+	err := s.forDays(from, to, func(day string, d *bolt.Bucket) error {
+		b := d.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			val, err := valueFromBytes(v)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row{day, string(k), val})
+			return nil
+		})
+	})
+	return rows, err
+}
@@ -13,7 +13,7 @@ import (
 )
 
 var goatse []string = []string{
-	"```* g o a t s e x * g o a t s e x * g o a t s e x *",
+	"* g o a t s e x * g o a t s e x * g o a t s e x *",
 	"g                                               g",
 	"o /     \\             \\            /    \\       o",
 	"a|       |             \\          |      |      a",
@@ -37,13 +37,12 @@ var goatse []string = []string{
 	"s   |         / /      \\__/\\___/    |          |s",
 	"e  |           /        |    |       |         |e",
 	"x  |          |         |    |       |         |x",
-	"* g o a t s e x * g o a t s e x * g o a t s e x *```",
+	"* g o a t s e x * g o a t s e x * g o a t s e x *",
 }
 
 type TalkerPlugin struct {
 	Bot          bot.Bot
 	enforceNicks bool
-	sayings      []string
 }
 
 func New(bot bot.Bot) *TalkerPlugin {
@@ -51,7 +50,6 @@ func New(bot bot.Bot) *TalkerPlugin {
 	return &TalkerPlugin{
 		Bot:          bot,
 		enforceNicks: bot.Config().EnforceNicks,
-		sayings:      bot.Config().WelcomeMsgs,
 	}
 }
 
@@ -63,7 +61,7 @@ func (p *TalkerPlugin) Message(message msg.Message) bool {
 	// TODO: This ought to be space split afterwards to remove any punctuation
 	if message.Command && strings.HasPrefix(lowermessage, "say") {
 		msg := strings.TrimSpace(body[3:])
-		p.Bot.SendMessage(channel, msg)
+		p.Bot.SendMessage(channel, msg, message)
 		return true
 	}
 
@@ -79,14 +77,16 @@ func (p *TalkerPlugin) Message(message msg.Message) bool {
 			line = strings.Replace(line, "{nick}", nick, 1)
 			output += line + "\n"
 		}
-		p.Bot.SendMessage(channel, output)
+		rich := msg.Snippet("goatse.txt", output)
+		rich.ThreadID = message.ThreadID
+		p.Bot.SendRich(channel, rich)
 		return true
 	}
 
 	if p.enforceNicks && len(message.User.Name) != 9 {
 		msg := fmt.Sprintf("Hey %s, we really like to have 9 character nicks because we're crazy OCD and stuff.",
 			message.User.Name)
-		p.Bot.SendMessage(message.Channel, msg)
+		p.Bot.SendMessage(message.Channel, msg, message)
 		return true
 	}
 
@@ -100,10 +100,11 @@ func (p *TalkerPlugin) Help(channel string, parts []string) {
 // Empty event handler because this plugin does not do anything on event recv
 func (p *TalkerPlugin) Event(kind string, message msg.Message) bool {
 	if kind == "JOIN" && strings.ToLower(message.User.Name) != strings.ToLower(p.Bot.Config().Nick) {
-		if len(p.sayings) == 0 {
+		sayings := p.Bot.Config().ForChannel(message.Channel).WelcomeMsgs
+		if len(sayings) == 0 {
 			return false
 		}
-		msg := fmt.Sprintf(p.sayings[rand.Intn(len(p.sayings))], message.User.Name)
+		msg := fmt.Sprintf(sayings[rand.Intn(len(sayings))], message.User.Name)
 		p.Bot.SendMessage(message.Channel, msg)
 		return true
 	}
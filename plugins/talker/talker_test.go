@@ -88,7 +88,7 @@ func TestNineChars(t *testing.T) {
 func TestWelcome(t *testing.T) {
 	mb := bot.NewMockBot()
 	c := New(mb)
-	c.sayings = []string{"Hi"}
+	mb.Cfg.WelcomeMsgs = []string{"Hi"}
 	assert.NotNil(t, c)
 	res := c.Event("JOIN", makeMessage("hello there"))
 	assert.Len(t, mb.Messages, 1)
@@ -99,7 +99,7 @@ func TestWelcome(t *testing.T) {
 func TestNoSayings(t *testing.T) {
 	mb := bot.NewMockBot()
 	c := New(mb)
-	c.sayings = []string{}
+	mb.Cfg.WelcomeMsgs = []string{}
 	assert.NotNil(t, c)
 	res := c.Event("JOIN", makeMessage("hello there"))
 	assert.Len(t, mb.Messages, 0)
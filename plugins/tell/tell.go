@@ -9,15 +9,14 @@ import (
 	"github.com/velour/catbase/bot/msg"
 )
 
-type delayedMsg string
-
 type TellPlugin struct {
-	b     bot.Bot
-	users map[string][]string
+	b bot.Bot
+	// users holds the messages waiting for each user
+	users *bot.KV
 }
 
 func New(b bot.Bot) *TellPlugin {
-	return &TellPlugin{b, make(map[string][]string)}
+	return &TellPlugin{b, b.KV("tell")}
 }
 
 func (t *TellPlugin) Message(message msg.Message) bool {
@@ -26,16 +25,28 @@ func (t *TellPlugin) Message(message msg.Message) bool {
 		target := parts[1]
 		newMessage := strings.Join(parts[2:], " ")
 		newMessage = fmt.Sprintf("Hey, %s. %s said: %s", target, message.User.Name, newMessage)
-		t.users[target] = append(t.users[target], newMessage)
-		t.b.SendMessage(message.Channel, fmt.Sprintf("Okay. I'll tell %s.", target))
+		var pending []string
+		if _, err := t.users.Get(target, &pending); err != nil {
+			log.Printf("Could not get pending tells for %s: %s", target, err)
+		}
+		if err := t.users.Set(target, append(pending, newMessage)); err != nil {
+			log.Printf("Could not save tell for %s: %s", target, err)
+			t.b.SendMessage(message.Channel, "I'm broke and can't remember that.", message)
+			return true
+		}
+		t.b.SendMessage(message.Channel, fmt.Sprintf("Okay. I'll tell %s.", target), message)
 		return true
 	}
-	log.Printf("current pending tells: %+v\nuser is: %s", t.users, message.User.Name)
-	if msg, ok := t.users[message.User.Name]; ok {
-		for _, m := range msg {
-			t.b.SendMessage(message.Channel, string(m))
+	var pending []string
+	if ok, err := t.users.Get(message.User.Name, &pending); err != nil {
+		log.Printf("Could not get pending tells for %s: %s", message.User.Name, err)
+	} else if ok && len(pending) > 0 {
+		for _, m := range pending {
+			t.b.SendMessage(message.Channel, m, message)
+		}
+		if err := t.users.Delete(message.User.Name); err != nil {
+			log.Printf("Could not clear pending tells for %s: %s", message.User.Name, err)
 		}
-		t.users[message.User.Name] = []string{}
 		return true
 	}
 	return false
@@ -20,6 +20,9 @@ type TwitchPlugin struct {
 	Bot        bot.Bot
 	config     *config.Config
 	twitchList map[string]*Twitcher
+	// games remembers what each twitcher was last seen streaming, so that
+	// a restart doesn't announce them again
+	games *bot.KV
 }
 
 type Twitcher struct {
@@ -56,15 +59,20 @@ func New(bot bot.Bot) *TwitchPlugin {
 		Bot:        bot,
 		config:     bot.Config(),
 		twitchList: map[string]*Twitcher{},
+		games:      bot.KV("twitch"),
 	}
 
 	for _, users := range p.config.Twitch.Users {
 		for _, twitcherName := range users {
 			if _, ok := p.twitchList[twitcherName]; !ok {
-				p.twitchList[twitcherName] = &Twitcher{
+				t := &Twitcher{
 					name: twitcherName,
 					game: "",
 				}
+				if _, err := p.games.Get(twitcherName, &t.game); err != nil {
+					log.Printf("Could not get last game for %s: %s", twitcherName, err)
+				}
+				p.twitchList[twitcherName] = t
 			}
 		}
 	}
@@ -123,7 +131,7 @@ func (p *TwitchPlugin) Message(message msg.Message) bool {
 		if _, ok := p.config.Twitch.Users[channel]; ok {
 			for _, twitcherName := range p.config.Twitch.Users[channel] {
 				if _, ok = p.twitchList[twitcherName]; ok {
-					p.checkTwitch(channel, p.twitchList[twitcherName], true)
+					p.checkTwitch(channel, p.twitchList[twitcherName], true, message)
 				}
 			}
 		}
@@ -188,7 +196,9 @@ errCase:
 	return []byte{}, false
 }
 
-func (p *TwitchPlugin) checkTwitch(channel string, twitcher *Twitcher, alwaysPrintStatus bool) {
+// checkTwitch announces when twitcher starts or stops streaming, or says what
+// they're doing if alwaysPrintStatus, in answer to replyTo.
+func (p *TwitchPlugin) checkTwitch(channel string, twitcher *Twitcher, alwaysPrintStatus bool, replyTo ...msg.Message) {
 	baseURL, err := url.Parse("https://api.twitch.tv/helix/streams")
 	if err != nil {
 		log.Println("Error parsing twitch stream URL")
@@ -222,20 +232,30 @@ func (p *TwitchPlugin) checkTwitch(channel string, twitcher *Twitcher, alwaysPri
 	}
 	if alwaysPrintStatus {
 		if game == "" {
-			p.Bot.SendMessage(channel, twitcher.name+" is not streaming.")
+			p.Bot.SendMessage(channel, twitcher.name+" is not streaming.", replyTo...)
 		} else {
-			p.Bot.SendMessage(channel, twitcher.name+" is streaming "+game+" at "+twitcher.URL())
+			p.Bot.SendMessage(channel, twitcher.name+" is streaming "+game+" at "+twitcher.URL(), replyTo...)
 		}
 	} else if game == "" {
 		if twitcher.game != "" {
 			p.Bot.SendMessage(channel, twitcher.name+" just stopped streaming.")
 		}
-		twitcher.game = ""
+		p.setGame(twitcher, "")
 	} else {
 		if twitcher.game != game {
 			p.Bot.SendMessage(channel, twitcher.name+" just started streaming "+game+" at "+twitcher.URL())
 		}
-		twitcher.game = game
+		p.setGame(twitcher, game)
+	}
+}
+
+func (p *TwitchPlugin) setGame(twitcher *Twitcher, game string) {
+	if twitcher.game == game {
+		return
+	}
+	twitcher.game = game
+	if err := p.games.Set(twitcher.name, game); err != nil {
+		log.Printf("Could not save game for %s: %s", twitcher.name, err)
 	}
 }
 
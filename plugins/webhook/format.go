@@ -0,0 +1,167 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// maxCommits is how many commits of a push we list.
+const maxCommits = 5
+
+// funcs are available to webhook templates.
+var funcs = template.FuncMap{
+	"bold":   msg.Bold,
+	"italic": msg.Italic,
+	"code":   msg.Code,
+	"link":   msg.Link,
+	"firstLine": func(s string) string {
+		return strings.SplitN(s, "\n", 2)[0]
+	},
+	"upper": strings.ToUpper,
+}
+
+// formatters turn the decoded JSON payloads of the built in formats into
+// messages.
+var formatters = map[string]func(interface{}) (string, error){
+	"text":    formatJSONText,
+	"alert":   formatAlert,
+	"gitpush": formatGitPush,
+}
+
+// formatText posts a plain text payload as it is.
+func formatText(payload []byte) string {
+	var data interface{}
+	if err := json.Unmarshal(payload, &data); err == nil {
+		if s, err := formatJSONText(data); err == nil {
+			return s
+		}
+	}
+	return strings.TrimSpace(string(payload))
+}
+
+// formatJSONText takes the text field of a {"text": "..."} payload.
+func formatJSONText(data interface{}) (string, error) {
+	if s := str(data, "text"); s != "" {
+		return s, nil
+	}
+	return "", fmt.Errorf("payload has no text")
+}
+
+// formatAlert understands Alertmanager's payload, a list of alerts, and a
+// single alert with title, message, severity, status and url fields.
+func formatAlert(data interface{}) (string, error) {
+	if alerts, ok := get(data, "alerts").([]interface{}); ok {
+		lines := []string{}
+		for _, a := range alerts {
+			title := str(a, "labels", "alertname")
+			desc := str(a, "annotations", "summary")
+			if desc == "" {
+				desc = str(a, "annotations", "description")
+			}
+			lines = append(lines, alertLine(str(a, "status"), str(a, "labels", "severity"),
+				title, desc, str(a, "generatorURL")))
+		}
+		if len(lines) == 0 {
+			return "", fmt.Errorf("payload has no alerts")
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	title := str(data, "title")
+	if title == "" {
+		return "", fmt.Errorf("alert has no title")
+	}
+	return alertLine(str(data, "status"), str(data, "severity"), title,
+		str(data, "message"), str(data, "url")), nil
+}
+
+func alertLine(status, severity, title, desc, url string) string {
+	prefix := "🚨"
+	if status == "resolved" || status == "ok" {
+		prefix = "✅"
+	}
+	line := prefix + " "
+	if severity != "" {
+		line += "[" + strings.ToUpper(severity) + "] "
+	}
+	if url != "" {
+		line += msg.Link(title, url)
+	} else {
+		line += msg.Bold(title)
+	}
+	if desc != "" {
+		line += ": " + desc
+	}
+	return line
+}
+
+// formatGitPush understands the push events of GitHub, Gitea and GitLab.
+func formatGitPush(data interface{}) (string, error) {
+	commits, _ := get(data, "commits").([]interface{})
+	repo := str(data, "repository", "full_name")
+	if repo == "" {
+		repo = str(data, "project", "path_with_namespace")
+	}
+	if repo == "" {
+		repo = str(data, "repository", "name")
+	}
+	pusher := str(data, "pusher", "name")
+	if pusher == "" {
+		pusher = str(data, "user_name")
+	}
+	ref := str(data, "ref")
+	if repo == "" || ref == "" {
+		return "", fmt.Errorf("payload is not a push")
+	}
+	if len(commits) == 0 {
+		return "", nil
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+
+	s := "commits"
+	if len(commits) == 1 {
+		s = "commit"
+	}
+	head := fmt.Sprintf("%s pushed %d %s to %s/%s", pusher, len(commits), s, msg.Bold(repo), branch)
+	if compare := str(data, "compare"); compare != "" {
+		head += " " + msg.Link("", compare)
+	}
+	lines := []string{head}
+	for i, c := range commits {
+		if i == maxCommits {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(commits)-maxCommits))
+			break
+		}
+		id := str(c, "id")
+		if len(id) > 7 {
+			id = id[:7]
+		}
+		firstLine := strings.SplitN(str(c, "message"), "\n", 2)[0]
+		lines = append(lines, fmt.Sprintf("%s %s — %s", msg.Code(id), firstLine, str(c, "author", "name")))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// get follows a path of object keys into decoded JSON.
+func get(data interface{}, path ...string) interface{} {
+	for _, k := range path {
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		data = obj[k]
+	}
+	return data
+}
+
+// str is get for strings, returning empty for anything else.
+func str(data interface{}, path ...string) string {
+	s, _ := get(data, path...).(string)
+	return s
+}
@@ -0,0 +1,191 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/config"
+)
+
+// outgoingTimeout bounds how long we wait on an outgoing webhook.
+const outgoingTimeout = 10 * time.Second
+
+type outgoing struct {
+	config.OutgoingWebhook
+	match *regexp.Regexp
+}
+
+// payload is what outgoing webhooks are sent.
+type payload struct {
+	Hook     string    `json:"hook"`
+	ID       string    `json:"id,omitempty"`
+	ThreadID string    `json:"thread_id,omitempty"`
+	Channel  string    `json:"channel"`
+	User     string    `json:"user"`
+	Body     string    `json:"body"`
+	Raw      string    `json:"raw"`
+	Command  bool      `json:"command"`
+	Action   bool      `json:"action"`
+	Time     time.Time `json:"time"`
+	// Groups are the submatches of the hook's Match
+	Groups []string `json:"groups,omitempty"`
+}
+
+func newOutgoing(c config.OutgoingWebhook) (*outgoing, error) {
+	o := &outgoing{OutgoingWebhook: c}
+	if c.URL == "" {
+		return nil, fmt.Errorf("no URL")
+	}
+	if c.Match != "" {
+		re, err := regexp.Compile(c.Match)
+		if err != nil {
+			return nil, err
+		}
+		o.match = re
+	}
+	return o, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns the payload for a message if the hook wants it.
+func (o *outgoing) matches(message msg.Message) (payload, bool) {
+	if message.User == nil ||
+		(o.Command && !message.Command) ||
+		(len(o.Channels) > 0 && !contains(o.Channels, message.Channel)) ||
+		(len(o.Users) > 0 && !contains(o.Users, message.User.Name)) {
+		return payload{}, false
+	}
+	var groups []string
+	if o.match != nil {
+		groups = o.match.FindStringSubmatch(message.Body)
+		if groups == nil {
+			return payload{}, false
+		}
+	}
+	return payload{
+		Hook:     o.Name,
+		ID:       message.ID,
+		ThreadID: message.ThreadID,
+		Channel:  message.Channel,
+		User:     message.User.Name,
+		Body:     message.Body,
+		Raw:      message.Raw,
+		Command:  message.Command,
+		Action:   message.Action,
+		Time:     message.Time,
+		Groups:   groups,
+	}, true
+}
+
+// forward posts a matched message to the hook and answers with its response
+// if the hook replies.
+func (p *WebhookPlugin) forward(o *outgoing, pl payload) {
+	body, err := json.Marshal(pl)
+	if err != nil {
+		log.Printf("Outgoing webhook %s: %s", o.Name, err)
+		return
+	}
+	req, err := http.NewRequest("POST", o.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Outgoing webhook %s: %s", o.Name, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.Token != "" {
+		req.Header.Set("X-Catbase-Token", o.Token)
+	}
+	resp, err := p.http.Do(req)
+	if err != nil {
+		log.Printf("Outgoing webhook %s: %s", o.Name, err)
+		return
+	}
+	defer resp.Body.Close()
+	text, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPayload))
+	if err != nil {
+		log.Printf("Outgoing webhook %s: reading response: %s", o.Name, err)
+		return
+	}
+	if resp.StatusCode >= 300 {
+		log.Printf("Outgoing webhook %s: %s: %s", o.Name, resp.Status, text)
+		return
+	}
+	if !o.Reply {
+		return
+	}
+	reply := formatText(text)
+	if reply == "" {
+		return
+	}
+	if pl.ThreadID != "" {
+		if _, ok := p.Bot.ReplyToMessageIdentifier(pl.Channel, reply, pl.ThreadID); ok {
+			return
+		}
+	}
+	p.Bot.SendMessage(pl.Channel, reply)
+}
+
+// Message forwards messages to the outgoing webhooks they match. Hooks that
+// reply handle the message, so that it acts like any other command.
+func (p *WebhookPlugin) Message(message msg.Message) bool {
+	handled := false
+	for _, o := range p.outgoing {
+		pl, ok := o.matches(message)
+		if !ok {
+			continue
+		}
+		handled = handled || o.Reply
+		p.wg.Add(1)
+		go func(o *outgoing) {
+			defer p.wg.Done()
+			p.forward(o, pl)
+		}(o)
+	}
+	return handled
+}
+
+// Close waits for the outgoing webhook requests in flight, for as long as
+// they could take, so that their replies aren't lost when the bot exits.
+func (p *WebhookPlugin) Close() error {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(p.closeTimeout):
+		return fmt.Errorf("gave up waiting for outgoing webhooks after %s", p.closeTimeout)
+	}
+}
+
+// outgoingHelp lists the outgoing webhooks by name.
+func (p *WebhookPlugin) outgoingHelp() string {
+	names := []string{}
+	for _, o := range p.outgoing {
+		names = append(names, o.Name)
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return " Messages are also sent to: " + strings.Join(names, ", ") + "."
+}
@@ -0,0 +1,165 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+// Package webhook lets other systems post into chat over HTTP, and sends them
+// messages from chat.
+package webhook
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/config"
+)
+
+// maxPayload bounds the size of a request body we will read.
+const maxPayload = 1 << 20
+
+type WebhookPlugin struct {
+	Bot      bot.Bot
+	hooks    map[string]*hook
+	outgoing []*outgoing
+
+	http *http.Client
+	// wg tracks outgoing webhook requests in flight
+	wg sync.WaitGroup
+	// closeTimeout is how long Close waits for them
+	closeTimeout time.Duration
+}
+
+type hook struct {
+	config.Webhook
+	tmpl *template.Template
+}
+
+// New creates a WebhookPlugin serving the configured webhooks. Hooks that are
+// misconfigured are logged and left out.
+func New(b bot.Bot) *WebhookPlugin {
+	p := &WebhookPlugin{
+		Bot:   b,
+		hooks: map[string]*hook{},
+		http:  &http.Client{Timeout: outgoingTimeout},
+
+		closeTimeout: outgoingTimeout,
+	}
+	for _, c := range b.Config().Webhooks {
+		h := &hook{Webhook: c}
+		if c.Name == "" || c.Token == "" || len(c.Channels) == 0 {
+			log.Printf("Webhook %q needs a name, a token and channels", c.Name)
+			continue
+		}
+		if c.Template != "" {
+			tmpl, err := template.New(c.Name).Funcs(funcs).Parse(c.Template)
+			if err != nil {
+				log.Printf("Webhook %s has a bad template: %s", c.Name, err)
+				continue
+			}
+			h.tmpl = tmpl
+		} else if _, ok := formatters[h.format()]; !ok {
+			log.Printf("Webhook %s has an unknown format: %s", c.Name, c.Format)
+			continue
+		}
+		p.hooks[c.Name] = h
+	}
+	for _, c := range b.Config().OutgoingWebhooks {
+		o, err := newOutgoing(c)
+		if err != nil {
+			log.Printf("Outgoing webhook %s is misconfigured: %s", c.Name, err)
+			continue
+		}
+		p.outgoing = append(p.outgoing, o)
+	}
+	return p
+}
+
+func (h *hook) format() string {
+	if h.Format == "" {
+		return "text"
+	}
+	return h.Format
+}
+
+// render turns a payload into the message to post.
+func (h *hook) render(payload []byte) (string, error) {
+	var data interface{}
+	if h.tmpl != nil || h.format() != "text" {
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return "", fmt.Errorf("payload is not JSON: %s", err)
+		}
+	}
+	if h.tmpl != nil {
+		var buf bytes.Buffer
+		if err := h.tmpl.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+	if data == nil {
+		return formatText(payload), nil
+	}
+	return formatters[h.format()](data)
+}
+
+func (p *WebhookPlugin) serveHook(w http.ResponseWriter, r *http.Request) {
+	h, ok := p.hooks[strings.TrimPrefix(r.URL.Path, "/webhook/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "webhooks only accept POST", http.StatusMethodNotAllowed)
+		return
+	}
+	token := r.Header.Get("X-Catbase-Token")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.Token)) != 1 {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	message, err := h.render(payload)
+	if err != nil {
+		log.Printf("Webhook %s: %s", h.Name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if message == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for _, ch := range h.Channels {
+		p.Bot.SendMessage(ch, message)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *WebhookPlugin) Event(kind string, message msg.Message) bool { return false }
+func (p *WebhookPlugin) ReplyMessage(msg.Message, string) bool       { return false }
+func (p *WebhookPlugin) BotMessage(message msg.Message) bool         { return false }
+
+func (p *WebhookPlugin) Help(channel string, parts []string) {
+	p.Bot.SendMessage(channel, "Webhooks post into chat from elsewhere. POST to /webhook/<name> with the hook's token."+p.outgoingHelp())
+}
+
+func (p *WebhookPlugin) RegisterWeb() *string {
+	http.HandleFunc("/webhook/", p.serveHook)
+	return nil
+}
@@ -0,0 +1,171 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+)
+
+func makePlugin(t *testing.T, hooks ...config.Webhook) (*WebhookPlugin, *bot.MockBot) {
+	mb := bot.NewMockBot()
+	mb.Cfg.Webhooks = hooks
+	p := New(mb)
+	assert.NotNil(t, p)
+	return p, mb
+}
+
+func post(p *WebhookPlugin, url, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("X-Catbase-Token", token)
+	}
+	w := httptest.NewRecorder()
+	p.serveHook(w, r)
+	return w
+}
+
+func TestText(t *testing.T) {
+	p, mb := makePlugin(t, config.Webhook{Name: "cron", Token: "s3cret", Channels: []string{"#a", "#b"}})
+	assert.Equal(t, http.StatusNoContent, post(p, "/webhook/cron", "s3cret", "backup done\n").Code)
+	assert.Equal(t, http.StatusNoContent, post(p, "/webhook/cron?token=s3cret", "", `{"text":"json too"}`).Code)
+	assert.Equal(t, []string{"backup done", "backup done", "json too", "json too"}, mb.Messages)
+}
+
+func TestRejects(t *testing.T) {
+	p, mb := makePlugin(t,
+		config.Webhook{Name: "cron", Token: "s3cret", Channels: []string{"#a"}},
+		config.Webhook{Name: "notoken", Channels: []string{"#a"}},
+		config.Webhook{Name: "alerts", Token: "t", Channels: []string{"#a"}, Format: "alert"},
+	)
+	assert.Equal(t, http.StatusUnauthorized, post(p, "/webhook/cron", "wrong", "hi").Code)
+	assert.Equal(t, http.StatusNotFound, post(p, "/webhook/nope", "s3cret", "hi").Code)
+	assert.Equal(t, http.StatusNotFound, post(p, "/webhook/notoken", "", "hi").Code)
+	assert.Equal(t, http.StatusBadRequest, post(p, "/webhook/alerts", "t", "not json").Code)
+
+	r := httptest.NewRequest("GET", "/webhook/cron?token=s3cret", nil)
+	w := httptest.NewRecorder()
+	p.serveHook(w, r)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, mb.Messages)
+}
+
+func TestTemplate(t *testing.T) {
+	p, mb := makePlugin(t, config.Webhook{
+		Name: "ci", Token: "t", Channels: []string{"#ci"},
+		Template: `{{bold .build.name}} {{.build.status}} {{firstLine .build.log}}`,
+	})
+	post(p, "/webhook/ci", "t", `{"build":{"name":"catbase","status":"passed","log":"ok\nmore"}}`)
+	assert.Equal(t, []string{msg.Bold("catbase") + " passed ok"}, mb.Messages)
+}
+
+func TestAlert(t *testing.T) {
+	p, mb := makePlugin(t, config.Webhook{Name: "a", Token: "t", Channels: []string{"#ops"}, Format: "alert"})
+	post(p, "/webhook/a", "t", `{"alerts":[
+		{"status":"firing","labels":{"alertname":"DiskFull","severity":"critical"},"annotations":{"summary":"/ is 99% full"}},
+		{"status":"resolved","labels":{"alertname":"HighLoad"},"annotations":{"description":"load is fine"}}]}`)
+	post(p, "/webhook/a", "t", `{"title":"Site down","message":"503s","severity":"warning","url":"http://status"}`)
+	assert.Len(t, mb.Messages, 2)
+	assert.Equal(t, "🚨 [CRITICAL] DiskFull: / is 99% full\n✅ HighLoad: load is fine", msg.Strip(mb.Messages[0]))
+	assert.Equal(t, "🚨 [WARNING] "+msg.Link("Site down", "http://status")+": 503s", mb.Messages[1])
+}
+
+func TestGitPush(t *testing.T) {
+	p, mb := makePlugin(t, config.Webhook{Name: "git", Token: "t", Channels: []string{"#dev"}, Format: "gitpush"})
+	post(p, "/webhook/git", "t", `{"ref":"refs/heads/master","repository":{"full_name":"velour/catbase"},
+		"pusher":{"name":"chrissexton"},"commits":[
+		{"id":"0123456789abcdef","message":"Fix the thing\n\nLong story","author":{"name":"Chris"}}]}`)
+	// pushes without commits, like deleting a branch, say nothing
+	post(p, "/webhook/git", "t", `{"ref":"refs/heads/old","repository":{"full_name":"velour/catbase"},"commits":[]}`)
+	assert.Len(t, mb.Messages, 1)
+	assert.Equal(t, "chrissexton pushed 1 commit to velour/catbase/master\n0123456 Fix the thing — Chris",
+		msg.Strip(mb.Messages[0]))
+}
+
+func TestOutgoing(t *testing.T) {
+	var got []payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pl payload
+		json.NewDecoder(r.Body).Decode(&pl)
+		got = append(got, pl)
+		assert.Equal(t, "tok", r.Header.Get("X-Catbase-Token"))
+		fmt.Fprintf(w, `{"text":"deployed %s"}`, pl.Groups[1])
+	}))
+	defer srv.Close()
+
+	mb := bot.NewMockBot()
+	mb.Cfg.OutgoingWebhooks = []config.OutgoingWebhook{{
+		Name: "deploy", URL: srv.URL, Token: "tok",
+		Match: `^deploy (\w+)$`, Channels: []string{"#ops"}, Command: true, Reply: true,
+	}}
+	p := New(mb)
+
+	message := msg.Message{User: &user.User{Name: "tester"}, Channel: "#ops", Body: "deploy catbase", Command: true}
+	assert.True(t, p.Message(message))
+	message.Channel = "#elsewhere"
+	assert.False(t, p.Message(message))
+	message.Channel, message.Command = "#ops", false
+	assert.False(t, p.Message(message))
+	p.wg.Wait()
+
+	assert.Len(t, got, 1)
+	assert.Equal(t, "deploy", got[0].Hook)
+	assert.Equal(t, "tester", got[0].User)
+	assert.Equal(t, []string{"deployed catbase"}, mb.Messages)
+}
+
+func TestOutgoingWithoutReply(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, "ignored")
+	}))
+	defer srv.Close()
+
+	mb := bot.NewMockBot()
+	mb.Cfg.OutgoingWebhooks = []config.OutgoingWebhook{
+		{Name: "log", URL: srv.URL, Users: []string{"tester"}},
+		{Name: "broken", Match: `(`},
+	}
+	p := New(mb)
+	assert.Len(t, p.outgoing, 1)
+
+	assert.False(t, p.Message(msg.Message{User: &user.User{Name: "tester"}, Body: "anything"}))
+	assert.False(t, p.Message(msg.Message{User: &user.User{Name: "other"}, Body: "anything"}))
+	p.wg.Wait()
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, mb.Messages)
+}
+
+func TestCloseWaitsForOutgoing(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, `{"text":"done"}`)
+	}))
+	defer srv.Close()
+
+	mb := bot.NewMockBot()
+	mb.Cfg.OutgoingWebhooks = []config.OutgoingWebhook{{Name: "slow", URL: srv.URL, Reply: true}}
+	p := New(mb)
+	p.closeTimeout = 50 * time.Millisecond
+
+	assert.True(t, p.Message(msg.Message{User: &user.User{Name: "tester"}, Body: "anything"}))
+	assert.NotNil(t, p.Close())
+
+	close(release)
+	p.closeTimeout = 5 * time.Second
+	assert.Nil(t, p.Close())
+	assert.Equal(t, []string{"done"}, mb.Messages)
+}
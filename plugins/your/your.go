@@ -30,18 +30,19 @@ func New(bot bot.Bot) *YourPlugin {
 // This function returns true if the plugin responds in a meaningful way to the users message.
 // Otherwise, the function returns false and the bot continues execution of other plugins.
 func (p *YourPlugin) Message(message msg.Message) bool {
-	if len(message.Body) > p.config.Your.MaxLength {
+	cfg := p.config.ForChannel(message.Channel)
+	if len(message.Body) > cfg.Your.MaxLength {
 		return false
 	}
 	msg := message.Body
-	for _, replacement := range p.config.Your.Replacements {
+	for _, replacement := range cfg.Your.Replacements {
 		if rand.Float64() < replacement.Frequency {
 			r := strings.NewReplacer(replacement.This, replacement.That)
 			msg = r.Replace(msg)
 		}
 	}
 	if msg != message.Body {
-		p.bot.SendMessage(message.Channel, msg)
+		p.bot.SendMessage(message.Channel, msg, message)
 		return true
 	}
 	return false
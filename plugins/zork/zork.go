@@ -71,11 +71,9 @@ func (p *ZorkPlugin) runZork(ch string) error {
 			return 0, nil, nil
 		})
 		for s.Scan() {
-			// Remove > and quote the whole thing.
-			m := strings.Replace(s.Text(), ">", "", -1)
-			m = strings.Replace(m, "\n", "\n>", -1)
-			m = ">" + m + "\n"
-			p.bot.SendMessage(ch, m)
+			// Remove the prompt and show the rest fixed-width.
+			m := strings.Trim(strings.Replace(s.Text(), ">", "", -1), "\n")
+			p.bot.SendRich(ch, msg.Section(msg.CodeBlock(m)))
 		}
 	}()
 	go func() {
@@ -104,7 +102,7 @@ func (p *ZorkPlugin) Message(message msg.Message) bool {
 	defer p.Unlock()
 	if p.zorks[ch] == nil {
 		if err := p.runZork(ch); err != nil {
-			p.bot.SendMessage(ch, "failed to run zork: "+err.Error())
+			p.bot.SendMessage(ch, "failed to run zork: "+err.Error(), message)
 			return true
 		}
 	}
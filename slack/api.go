@@ -0,0 +1,152 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// defaultAPIURL is where the Slack Web API lives unless the config says
+// otherwise.
+const defaultAPIURL = "https://slack.com/api/"
+
+type slackUserInfoResp struct {
+	User struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+type slackChannel struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	IsChannel  bool   `json:"is_channel"`
+	IsArchived bool   `json:"is_archived"`
+	IsMember   bool   `json:"is_member"`
+}
+
+// cursorPage holds the part of a paginated response that points at the next
+// page.
+type cursorPage struct {
+	ResponseMetadata struct {
+		NextCursor string `json:"next_cursor"`
+	} `json:"response_metadata"`
+}
+
+// api calls a Web API method using the bot token and decodes the response into
+// out, which may be nil.
+func (s *Slack) api(method string, params url.Values, out interface{}) error {
+	return s.client.call(s.config.Slack.Token, method, params, out)
+}
+
+// paginate calls a cursor paginated method until Slack runs out of pages,
+// handing each raw page to f.
+func (s *Slack) paginate(method string, params url.Values, f func(page []byte) error) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	for {
+		var page json.RawMessage
+		if err := s.api(method, params, &page); err != nil {
+			return err
+		}
+		if err := f(page); err != nil {
+			return err
+		}
+		var c cursorPage
+		if err := json.Unmarshal(page, &c); err != nil {
+			return err
+		}
+		if c.ResponseMetadata.NextCursor == "" {
+			return nil
+		}
+		params.Set("cursor", c.ResponseMetadata.NextCursor)
+	}
+}
+
+// getAllChannels returns info for all channels the bot is a member of
+func (s *Slack) getAllChannels() ([]slackChannel, error) {
+	params := url.Values{
+		"types":            {"public_channel,private_channel"},
+		"exclude_archived": {"true"},
+		"limit":            {"200"},
+	}
+	var chs []slackChannel
+	err := s.paginate("conversations.list", params, func(page []byte) error {
+		var resp struct {
+			Channels []slackChannel `json:"channels"`
+		}
+		if err := json.Unmarshal(page, &resp); err != nil {
+			return err
+		}
+		for _, ch := range resp.Channels {
+			if ch.IsMember {
+				chs = append(chs, ch)
+			}
+		}
+		return nil
+	})
+	return chs, err
+}
+
+// getMembers returns the user IDs of everybody in a conversation
+func (s *Slack) getMembers(channel string) ([]string, error) {
+	params := url.Values{
+		"channel": {channel},
+		"limit":   {"200"},
+	}
+	var members []string
+	err := s.paginate("conversations.members", params, func(page []byte) error {
+		var resp struct {
+			Members []string `json:"members"`
+		}
+		if err := json.Unmarshal(page, &resp); err != nil {
+			return err
+		}
+		members = append(members, resp.Members...)
+		return nil
+	})
+	return members, err
+}
+
+// getAllUsers returns the ID and name of everybody in the workspace
+func (s *Slack) getAllUsers() (map[string]string, error) {
+	params := url.Values{"limit": {"200"}}
+	users := map[string]string{}
+	err := s.paginate("users.list", params, func(page []byte) error {
+		var resp struct {
+			Members []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"members"`
+		}
+		if err := json.Unmarshal(page, &resp); err != nil {
+			return err
+		}
+		for _, u := range resp.Members {
+			users[u.ID] = u.Name
+		}
+		return nil
+	})
+	return users, err
+}
+
+// markChannelAsRead marks a conversation read up to its latest message
+func (s *Slack) markChannelAsRead(channel string) error {
+	var history struct {
+		Messages []struct {
+			Ts string `json:"ts"`
+		} `json:"messages"`
+	}
+	err := s.api("conversations.history",
+		url.Values{"channel": {channel}, "limit": {"1"}}, &history)
+	if err != nil {
+		return err
+	}
+	if len(history.Messages) == 0 {
+		return nil
+	}
+	return s.api("conversations.mark",
+		url.Values{"channel": {channel}, "ts": {history.Messages[0].Ts}}, nil)
+}
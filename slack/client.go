@@ -0,0 +1,297 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// requestTimeout bounds every Web API request, including reading the body.
+	requestTimeout = 30 * time.Second
+
+	// maxRetries is how many times a rate limited call is retried before we
+	// give up and return a RateLimitError.
+	maxRetries = 3
+
+	// defaultRetryAfter is used when Slack rate limits us without saying for
+	// how long.
+	defaultRetryAfter = 5 * time.Second
+)
+
+// APIError is a response from Slack that wasn't ok.
+type APIError struct {
+	Method string
+	// Code is Slack's error string, e.g. "channel_not_found"
+	Code string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.Method, e.Code)
+}
+
+// HTTPError is a response with an unexpected HTTP status.
+type HTTPError struct {
+	Method     string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("slack %s: HTTP status %d", e.Method, e.StatusCode)
+}
+
+// RateLimitError is returned once a call has been rate limited more times than
+// we're willing to wait for.
+type RateLimitError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("slack %s: rate limited, retry after %s", e.Method, e.RetryAfter)
+}
+
+// Slack groups Web API methods into tiers by how often they may be called.
+// See https://api.slack.com/docs/rate-limits
+const (
+	tier1 = iota + 1
+	tier2
+	tier3
+	tier4
+	// tierPost is chat.postMessage, which is limited per channel instead.
+	tierPost
+)
+
+// tierLimits gives the calls per minute and burst size for each tier.
+var tierLimits = map[int]struct{ perMinute, burst float64 }{
+	tier1:    {1, 3},
+	tier2:    {20, 20},
+	tier3:    {50, 50},
+	tier4:    {100, 100},
+	tierPost: {60, 3},
+}
+
+var methodTiers = map[string]int{
+	"apps.connections.open": tier1,
+	"auth.test":             tier4,
+	"chat.meMessage":        tier3,
+	"chat.postMessage":      tierPost,
+	"chat.update":           tier3,
+	"conversations.history": tier3,
+	"conversations.list":    tier2,
+	"conversations.mark":    tier3,
+	"conversations.members": tier4,
+	"emoji.list":            tier2,
+	"files.upload":          tier2,
+	"reactions.add":         tier3,
+	"conversations.open":    tier3,
+	"users.info":            tier4,
+	"users.list":            tier2,
+}
+
+// limiter is a token bucket. Callers that find it empty borrow against future
+// tokens and are told how long to wait for theirs.
+type limiter struct {
+	mu       sync.Mutex
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter(perMinute, burst float64) *limiter {
+	return &limiter{
+		rate:     perMinute / 60,
+		capacity: burst,
+		tokens:   burst,
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.capacity {
+			l.tokens = l.capacity
+		}
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// client makes rate limited Web API calls. It is shared by every endpoint the
+// connector uses.
+type client struct {
+	base string
+	http *http.Client
+
+	mu       sync.Mutex
+	limiters map[string]*limiter
+
+	// sleep is time.Sleep, replaced in tests
+	sleep func(time.Duration)
+}
+
+func newClient(base string) *client {
+	return &client{
+		base:     base,
+		http:     &http.Client{Timeout: requestTimeout},
+		limiters: make(map[string]*limiter),
+		sleep:    time.Sleep,
+	}
+}
+
+// limiterFor returns the bucket a call counts against.
+func (c *client) limiterFor(method string, params url.Values) *limiter {
+	tier, ok := methodTiers[method]
+	if !ok {
+		tier = tier3
+	}
+	key := strconv.Itoa(tier)
+	if tier == tierPost {
+		key += ":" + params.Get("channel")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.limiters[key]
+	if !ok {
+		limit := tierLimits[tier]
+		l = newLimiter(limit.perMinute, limit.burst)
+		c.limiters[key] = l
+	}
+	return l
+}
+
+// call invokes a Web API method and decodes the response into out, which may
+// be nil. It waits for the method's rate limit and retries when Slack asks us
+// to back off.
+func (c *client) call(token, method string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	body := []byte(params.Encode())
+	return c.send(token, method, params, "application/x-www-form-urlencoded", body, out)
+}
+
+// upload invokes a Web API method that takes a file, sending params alongside
+// it as a multipart form.
+func (c *client) upload(token, method string, params url.Values, filename string, data []byte, out interface{}) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, vs := range params {
+		for _, v := range vs {
+			w.WriteField(k, v)
+		}
+	}
+	fw, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	fw.Write(data)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.send(token, method, params, w.FormDataContentType(), body.Bytes(), out)
+}
+
+func (c *client) send(token, method string, params url.Values, contentType string, body []byte, out interface{}) error {
+	err := c.callWithRetry(token, method, params, contentType, body, out)
+	if err != nil {
+		log.Printf("slack: %s failed: %s", method, err)
+	}
+	return err
+}
+
+func (c *client) callWithRetry(token, method string, params url.Values, contentType string, body []byte, out interface{}) error {
+	l := c.limiterFor(method, params)
+	for attempt := 0; ; attempt++ {
+		if wait := l.reserve(time.Now()); wait > 0 {
+			c.sleep(wait)
+		}
+
+		retryAfter, err := c.do(token, method, contentType, body, out)
+		if retryAfter == 0 {
+			return err
+		}
+		if attempt >= maxRetries {
+			return &RateLimitError{Method: method, RetryAfter: retryAfter}
+		}
+		log.Printf("slack: %s rate limited, retrying in %s", method, retryAfter)
+		c.sleep(retryAfter)
+	}
+}
+
+// do makes a single request. A non-zero duration means we were rate limited
+// and should try again after it.
+func (c *client) do(token, method, contentType string, body []byte, out interface{}) (time.Duration, error) {
+	req, err := http.NewRequest("POST", c.base+method, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("reading body: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return retryAfter(resp.Header), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, &HTTPError{Method: method, StatusCode: resp.StatusCode}
+	}
+
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		return 0, fmt.Errorf("decoding response: %s", err)
+	}
+	if status.Error == "ratelimited" {
+		return retryAfter(resp.Header), nil
+	}
+	if !status.OK {
+		return 0, &APIError{Method: method, Code: status.Error}
+	}
+	if out == nil {
+		return 0, nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return 0, fmt.Errorf("decoding response: %s", err)
+	}
+	return 0, nil
+}
+
+// retryAfter reads how long Slack wants us to wait.
+func retryAfter(h http.Header) time.Duration {
+	secs, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || secs <= 0 {
+		return defaultRetryAfter
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,86 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHonorsRetryAfter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "ts": "1.2"})
+	}))
+	defer srv.Close()
+
+	c := newClient(srv.URL + "/")
+	var slept []time.Duration
+	c.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	var out struct {
+		Ts string `json:"ts"`
+	}
+	err := c.call("xoxb", "auth.test", nil, &out)
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2", out.Ts)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, []time.Duration{7 * time.Second}, slept)
+}
+
+func TestClientGivesUpWhenRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "ratelimited"})
+	}))
+	defer srv.Close()
+
+	c := newClient(srv.URL + "/")
+	c.sleep = func(time.Duration) {}
+
+	err := c.call("xoxb", "auth.test", nil, nil)
+	rl, ok := err.(*RateLimitError)
+	if assert.True(t, ok, "expected a RateLimitError, got %v", err) {
+		assert.Equal(t, defaultRetryAfter, rl.RetryAfter)
+	}
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+	}))
+	defer srv.Close()
+
+	c := newClient(srv.URL + "/")
+	err := c.call("xoxb", "auth.test", nil, nil)
+	assert.Equal(t, &APIError{Method: "auth.test", Code: "invalid_auth"}, err)
+	assert.True(t, isFatal(err))
+
+	err = c.call("xoxb", "broken", nil, nil)
+	assert.Equal(t, &HTTPError{Method: "broken", StatusCode: http.StatusInternalServerError}, err)
+	assert.False(t, isFatal(err))
+}
+
+func TestLimiterReserve(t *testing.T) {
+	l := newLimiter(60, 2)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), l.reserve(now))
+	assert.Equal(t, time.Duration(0), l.reserve(now))
+	assert.Equal(t, time.Second, l.reserve(now))
+	// Two seconds later one token has been repaid and one more earned.
+	assert.Equal(t, time.Duration(0), l.reserve(now.Add(2*time.Second)))
+}
@@ -0,0 +1,126 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRequestAge is how old a signed Events API request may be before we treat
+// it as a replay.
+const maxRequestAge = 5 * time.Minute
+
+// serveEvents receives Events API deliveries on the bot's HTTP server and
+// hands them to Serve.
+func (s *Slack) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if err := s.verifyRequest(r.Header, body, time.Now()); err != nil {
+		log.Printf("Rejecting Slack event request: %s", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var cb eventCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch cb.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, cb.Challenge)
+	case "event_callback":
+		if !s.seen.first(cb.EventID) {
+			log.Printf("Ignoring Slack event %s, retry %s", cb.EventID, r.Header.Get("X-Slack-Retry-Num"))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// Slack wants an answer within a few seconds, so let Serve do the work.
+		select {
+		case s.events <- cb.Event:
+		default:
+			log.Println("Slack event queue is full, dropping event")
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		log.Printf("Unhandled Slack callback type: '%s'", cb.Type)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// maxSeenEvents is how many event IDs seenEvents remembers.
+const maxSeenEvents = 1000
+
+// seenEvents remembers the IDs of recent events. Slack delivers an event
+// again if it doesn't hear back in time, and the second delivery must not
+// run commands a second time.
+type seenEvents struct {
+	mu  sync.Mutex
+	ids map[string]bool
+	// order is the IDs oldest first, to forget them in turn
+	order []string
+}
+
+// first reports whether id is new, and remembers it. Events without IDs are
+// always new.
+func (e *seenEvents) first(id string) bool {
+	if id == "" {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ids[id] {
+		return false
+	}
+	if e.ids == nil {
+		e.ids = map[string]bool{}
+	}
+	e.ids[id] = true
+	e.order = append(e.order, id)
+	if len(e.order) > maxSeenEvents {
+		delete(e.ids, e.order[0])
+		e.order = e.order[1:]
+	}
+	return true
+}
+
+// verifyRequest checks the signature Slack puts on every Events API request.
+func (s *Slack) verifyRequest(h http.Header, body []byte, now time.Time) error {
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp %q", ts)
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > maxRequestAge || age < -maxRequestAge {
+		return fmt.Errorf("stale timestamp %s", ts)
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.config.Slack.SigningSecret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(h.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// render converts marked up text to Slack's mrkdwn syntax, turning mentions of
+// users we know into real mentions.
+func (s *Slack) render(text string) string {
+	return msg.Render(text, func(sp msg.Span) string {
+		if sp.Style == msg.StyleMention && sp.Target == "" {
+			sp.Target, _ = s.userID(sp.Text)
+		}
+		return renderSpan(sp)
+	})
+}
+
+// escaper escapes the characters Slack reads as markup, so that text like
+// <!channel> is shown rather than acted on.
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// renderSpan escapes the text of plain spans, emoji and mentions, and link
+// targets, which Render leaves as they are.
+func renderSpan(s msg.Span) string {
+	switch s.Style {
+	case msg.StylePlain, msg.StyleEmoji, msg.StyleMention:
+		s.Text = escaper.Replace(s.Text)
+	}
+	s.Target = escaper.Replace(s.Target)
+	switch s.Style {
+	case msg.StyleBold:
+		return "*" + s.Text + "*"
+	case msg.StyleItalic:
+		return "_" + s.Text + "_"
+	case msg.StyleCode:
+		return "`" + s.Text + "`"
+	case msg.StyleCodeBlock:
+		return "```" + s.Text + "```"
+	case msg.StyleEmoji:
+		return ":" + s.Text + ":"
+	case msg.StyleLink:
+		if s.Text == "" || s.Text == s.Target {
+			return "<" + s.Target + ">"
+		}
+		return fmt.Sprintf("<%s|%s>", s.Target, s.Text)
+	case msg.StyleMention:
+		if s.Target != "" {
+			return "<@" + s.Target + ">"
+		}
+		return "@" + s.Text
+	}
+	return s.Text
+}
@@ -0,0 +1,26 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot/msg"
+)
+
+func TestRenderSpans(t *testing.T) {
+	render := func(text string) string { return msg.Render(text, renderSpan) }
+	assert.Equal(t, "*bold* _it_ `code` ```a\nb```", render(msg.Bold("bold")+" "+msg.Italic("it")+" "+msg.Code("code")+" "+msg.CodeBlock("a\nb")))
+	assert.Equal(t, ":tea: <http://x|docs> <http://y>", render(msg.Emoji("tea")+" "+msg.Link("docs", "http://x")+" "+msg.Link("", "http://y")))
+	assert.Equal(t, "<@U1> @alice", render(msg.Mention("bob", "U1")+" "+msg.Mention("alice", "")))
+	assert.Equal(t, "_hugs :tea: ok_", render(msg.Italic("hugs "+msg.Emoji("tea")+" ok")))
+}
+
+func TestRenderEscapes(t *testing.T) {
+	render := func(text string) string { return msg.Render(text, renderSpan) }
+	assert.Equal(t, "&lt;!channel&gt; &amp; co", render("<!channel> & co"))
+	assert.Equal(t, "*&lt;!here&gt;*", render(msg.Bold("<!here>")))
+	assert.Equal(t, "<http://x?a=1&amp;b=2|&lt;!channel&gt;>", render(msg.Link("<!channel>", "http://x?a=1&b=2")))
+	assert.Equal(t, "@&lt;!everyone&gt;", render(msg.Mention("<!everyone>", "")))
+}
@@ -0,0 +1,158 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"encoding/json"
+	"log"
+	"net/url"
+
+	"github.com/velour/catbase/bot/msg"
+)
+
+// SendRich posts a message laid out with Block Kit and uploads any attached
+// files to the same channel or thread.
+func (s *Slack) SendRich(channel string, message msg.Rich) string {
+	log.Printf("Sending rich message to %s: %s", channel, message.Fallback())
+
+	var identifier string
+	if len(message.Blocks) > 0 || message.Text != "" {
+		text := message.Text
+		if text == "" {
+			text = msg.Strip(message.Fallback())
+		}
+		params := url.Values{
+			"as_user": {"true"},
+			"channel": {channel},
+			"text":    {s.render(text)},
+		}
+		if len(message.Blocks) > 0 {
+			blocks, err := json.Marshal(s.slackBlocks(message))
+			if err != nil {
+				log.Printf("Error encoding Slack blocks: %s", err)
+				return ""
+			}
+			params.Set("blocks", string(blocks))
+		}
+		if message.ThreadID != "" {
+			params.Set("thread_ts", message.ThreadID)
+		}
+		var mr struct {
+			Timestamp string `json:"ts"`
+		}
+		if err := s.api("chat.postMessage", params, &mr); err != nil {
+			log.Printf("Error sending Slack message: %s", err)
+			return ""
+		}
+		identifier = mr.Timestamp
+	}
+
+	for _, a := range message.Attachments {
+		if err := s.uploadFile(channel, message.ThreadID, a); err != nil {
+			log.Printf("Error uploading %s to Slack: %s", a.Name, err)
+		}
+	}
+	return identifier
+}
+
+// uploadFile shares an attachment in a channel. Attachments that only have a
+// URL are posted as a link.
+func (s *Slack) uploadFile(channel, thread string, a msg.Attachment) error {
+	if len(a.Data) == 0 {
+		params := url.Values{
+			"as_user": {"true"},
+			"channel": {channel},
+			"text":    {s.render(msg.Link(a.Title, a.URL))},
+		}
+		if thread != "" {
+			params.Set("thread_ts", thread)
+		}
+		return s.api("chat.postMessage", params, nil)
+	}
+
+	params := url.Values{
+		"channels": {channel},
+		"filename": {a.Name},
+	}
+	if a.Title != "" {
+		params.Set("title", a.Title)
+	}
+	if thread != "" {
+		params.Set("thread_ts", thread)
+	}
+	if a.Kind == msg.AttachSnippet {
+		// Snippets are sent as content so Slack shows them inline.
+		filetype := a.Filetype
+		if filetype == "" {
+			filetype = "text"
+		}
+		params.Set("filetype", filetype)
+		params.Set("content", string(a.Data))
+		return s.api("files.upload", params, nil)
+	}
+	if a.Filetype != "" {
+		params.Set("filetype", a.Filetype)
+	}
+	return s.client.upload(s.config.Slack.Token, "files.upload", params, a.Name, a.Data, nil)
+}
+
+type blockText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (s *Slack) mrkdwn(text string) *blockText {
+	return &blockText{Type: "mrkdwn", Text: s.render(text)}
+}
+
+type blockElement struct {
+	Type string     `json:"type"`
+	Text *blockText `json:"text"`
+	URL  string     `json:"url"`
+}
+
+type slackBlock struct {
+	Type     string         `json:"type"`
+	Text     *blockText     `json:"text,omitempty"`
+	Fields   []*blockText   `json:"fields,omitempty"`
+	ImageURL string         `json:"image_url,omitempty"`
+	AltText  string         `json:"alt_text,omitempty"`
+	Elements []blockElement `json:"elements,omitempty"`
+}
+
+// slackBlocks converts a message's layout to Block Kit.
+func (s *Slack) slackBlocks(message msg.Rich) []slackBlock {
+	var blocks []slackBlock
+	for _, b := range message.Blocks {
+		switch b.Kind {
+		case msg.BlockDivider:
+			blocks = append(blocks, slackBlock{Type: "divider"})
+		case msg.BlockImage:
+			alt := msg.Strip(b.Text)
+			if alt == "" {
+				alt = b.URL
+			}
+			blocks = append(blocks, slackBlock{Type: "image", ImageURL: b.URL, AltText: alt})
+		case msg.BlockButtons:
+			block := slackBlock{Type: "actions"}
+			for _, btn := range b.Buttons {
+				block.Elements = append(block.Elements, blockElement{
+					Type: "button",
+					Text: &blockText{Type: "plain_text", Text: msg.Strip(btn.Text)},
+					URL:  btn.URL,
+				})
+			}
+			blocks = append(blocks, block)
+		default:
+			block := slackBlock{Type: "section"}
+			if b.Text != "" {
+				block.Text = s.mrkdwn(b.Text)
+			}
+			for _, f := range b.Fields {
+				block.Fields = append(block.Fields, s.mrkdwn(msg.Bold(f.Title)+"\n"+f.Value))
+			}
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
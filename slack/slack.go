@@ -5,128 +5,64 @@ package slack
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"html"
-	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
-	// "sync/atomic"
+	"sync"
 	"time"
 
 	"github.com/velour/catbase/bot"
 	"github.com/velour/catbase/bot/msg"
 	"github.com/velour/catbase/bot/user"
 	"github.com/velour/catbase/config"
-	"golang.org/x/net/websocket"
+)
+
+const (
+	// socketMode receives events over a Socket Mode websocket. It is the
+	// default because it needs no public HTTP endpoint.
+	socketMode = "socket"
+
+	// eventsMode receives events from the Events API, posted to the bot's
+	// HTTP server.
+	eventsMode = "events"
+
+	// userListInterval limits how often we fetch the whole user list while
+	// looking for somebody by name.
+	userListInterval = 10 * time.Minute
 )
 
 type Slack struct {
 	config *config.Config
 
-	url string
-	id  string
-	ws  *websocket.Conn
+	// url is the base of the Web API, ending in a slash
+	url    string
+	client *client
+	// id is the bot's own user ID
+	id string
 
 	lastRecieved time.Time
 
-	users map[string]string
+	usersMu sync.Mutex
+	users   map[string]string
+	// usersListed is when we last fetched every user to find one by name
+	usersListed time.Time
 
 	emoji map[string]string
 
-	eventReceived        func(msg.Message)
-	messageReceived      func(msg.Message)
-	replyMessageReceived func(msg.Message, string)
-}
-
-var idCounter uint64
-
-type slackUserInfoResp struct {
-	Ok   bool `json:"ok"`
-	User struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
-	} `json:"user"`
-}
-
-type slackChannelListItem struct {
-	ID             string   `json:"id"`
-	Name           string   `json:"name"`
-	IsChannel      bool     `json:"is_channel"`
-	Created        int      `json:"created"`
-	Creator        string   `json:"creator"`
-	IsArchived     bool     `json:"is_archived"`
-	IsGeneral      bool     `json:"is_general"`
-	NameNormalized string   `json:"name_normalized"`
-	IsShared       bool     `json:"is_shared"`
-	IsOrgShared    bool     `json:"is_org_shared"`
-	IsMember       bool     `json:"is_member"`
-	Members        []string `json:"members"`
-	Topic          struct {
-		Value   string `json:"value"`
-		Creator string `json:"creator"`
-		LastSet int    `json:"last_set"`
-	} `json:"topic"`
-	Purpose struct {
-		Value   string `json:"value"`
-		Creator string `json:"creator"`
-		LastSet int    `json:"last_set"`
-	} `json:"purpose"`
-	PreviousNames []interface{} `json:"previous_names"`
-	NumMembers    int           `json:"num_members"`
-}
-
-type slackChannelListResp struct {
-	Ok       bool                   `json:"ok"`
-	Channels []slackChannelListItem `json:"channels"`
-}
+	// events carries event payloads from the Events API handler to Serve
+	events chan json.RawMessage
+	// seen is the events delivered lately, so that retries are dropped
+	seen seenEvents
 
-type slackChannelInfoResp struct {
-	Ok      bool `json:"ok"`
-	Channel struct {
-		ID             string `json:"id"`
-		Name           string `json:"name"`
-		IsChannel      bool   `json:"is_channel"`
-		Created        int    `json:"created"`
-		Creator        string `json:"creator"`
-		IsArchived     bool   `json:"is_archived"`
-		IsGeneral      bool   `json:"is_general"`
-		NameNormalized string `json:"name_normalized"`
-		IsReadOnly     bool   `json:"is_read_only"`
-		IsShared       bool   `json:"is_shared"`
-		IsOrgShared    bool   `json:"is_org_shared"`
-		IsMember       bool   `json:"is_member"`
-		LastRead       string `json:"last_read"`
-		Latest         struct {
-			Type string `json:"type"`
-			User string `json:"user"`
-			Text string `json:"text"`
-			Ts   string `json:"ts"`
-		} `json:"latest"`
-		UnreadCount        int      `json:"unread_count"`
-		UnreadCountDisplay int      `json:"unread_count_display"`
-		Members            []string `json:"members"`
-		Topic              struct {
-			Value   string `json:"value"`
-			Creator string `json:"creator"`
-			LastSet int64  `json:"last_set"`
-		} `json:"topic"`
-		Purpose struct {
-			Value   string `json:"value"`
-			Creator string `json:"creator"`
-			LastSet int    `json:"last_set"`
-		} `json:"purpose"`
-		PreviousNames []string `json:"previous_names"`
-	} `json:"channel"`
+	eventReceived   func(msg.Message)
+	messageReceived func(msg.Message)
 }
 
 type slackMessage struct {
-	ID       uint64 `json:"id"`
 	Type     string `json:"type"`
 	SubType  string `json:"subtype"`
 	Hidden   bool   `json:"hidden"`
@@ -137,55 +73,53 @@ type slackMessage struct {
 	BotID    string `json:"bot_id"`
 	Ts       string `json:"ts"`
 	ThreadTs string `json:"thread_ts"`
-	Error    struct {
-		Code uint64 `json:"code"`
-		Msg  string `json:"msg"`
-	} `json:"error"`
-}
+	EventTs  string `json:"event_ts"`
 
-type slackReaction struct {
-	Reaction  string  `json:"name"`
-	Channel   string  `json:"channel"`
-	Timestamp float64 `json:"timestamp"`
-}
+	// Set on message_changed and message_deleted
+	Message         *slackMessage `json:"message"`
+	PreviousMessage *slackMessage `json:"previous_message"`
+	DeletedTs       string        `json:"deleted_ts"`
 
-type rtmStart struct {
-	Ok    bool   `json:"ok"`
-	Error string `json:"error"`
-	URL   string `json:"url"`
-	Self  struct {
-		ID string `json:"id"`
-	} `json:"self"`
+	// Set on reaction_added and reaction_removed
+	Reaction string `json:"reaction"`
+	Item     struct {
+		Type    string `json:"type"`
+		Channel string `json:"channel"`
+		Ts      string `json:"ts"`
+	} `json:"item"`
 }
 
 func New(c *config.Config) *Slack {
-	return &Slack{
+	apiURL := c.Slack.APIURL
+	if apiURL == "" {
+		apiURL = defaultAPIURL
+	}
+	if !strings.HasSuffix(apiURL, "/") {
+		apiURL += "/"
+	}
+
+	s := &Slack{
 		config:       c,
+		url:          apiURL,
+		client:       newClient(apiURL),
 		lastRecieved: time.Now(),
 		users:        make(map[string]string),
 		emoji:        make(map[string]string),
+		events:       make(chan json.RawMessage, 100),
 	}
-}
 
-func checkReturnStatus(response *http.Response) bool {
-	type Response struct {
-		OK bool `json:"ok"`
+	if s.mode() == eventsMode {
+		http.HandleFunc(c.SlackEventsPath(), s.serveEvents)
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-	if err != nil {
-		log.Printf("Error reading Slack API body: %s", err)
-		return false
-	}
+	return s
+}
 
-	var resp Response
-	err = json.Unmarshal(body, &resp)
-	if err != nil {
-		log.Printf("Error parsing message response: %s", err)
-		return false
+func (s *Slack) mode() string {
+	if s.config.Slack.Mode == "" {
+		return socketMode
 	}
-	return resp.OK
+	return s.config.Slack.Mode
 }
 
 func (s *Slack) RegisterEventReceived(f func(msg.Message)) {
@@ -196,51 +130,25 @@ func (s *Slack) RegisterMessageReceived(f func(msg.Message)) {
 	s.messageReceived = f
 }
 
-func (s *Slack) RegisterReplyMessageReceived(f func(msg.Message, string)) {
-	s.replyMessageReceived = f
-}
-
 func (s *Slack) SendMessageType(channel, message string, meMessage bool) (string, error) {
-	postUrl := "https://slack.com/api/chat.postMessage"
+	method := "chat.postMessage"
 	if meMessage {
-		postUrl = "https://slack.com/api/chat.meMessage"
-	}
-
-	resp, err := http.PostForm(postUrl,
-		url.Values{"token": {s.config.Slack.Token},
-			"as_user": {"true"},
-			"channel": {channel},
-			"text":    {message},
-		})
-
-	if err != nil {
-		log.Printf("Error sending Slack message: %s", err)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		log.Fatalf("Error reading Slack API body: %s", err)
+		method = "chat.meMessage"
 	}
 
-	log.Println(string(body))
-
-	type MessageResponse struct {
-		OK        bool   `json:"ok"`
+	var mr struct {
 		Timestamp string `json:"ts"`
 	}
-
-	var mr MessageResponse
-	err = json.Unmarshal(body, &mr)
+	err := s.api(method, url.Values{
+		"as_user": {"true"},
+		"channel": {channel},
+		"text":    {s.render(message)},
+	}, &mr)
 	if err != nil {
-		log.Fatalf("Error parsing message response: %s", err)
-	}
-
-	if !mr.OK {
-		return "", errors.New("failure response received")
+		log.Printf("Error sending Slack message: %s", err)
+		return "", err
 	}
-
-	return mr.Timestamp, err
+	return mr.Timestamp, nil
 }
 
 func (s *Slack) SendMessage(channel, message string) string {
@@ -251,82 +159,62 @@ func (s *Slack) SendMessage(channel, message string) string {
 
 func (s *Slack) SendAction(channel, message string) string {
 	log.Printf("Sending action to %s: %s", channel, message)
-	identifier, _ := s.SendMessageType(channel, "_"+message+"_", true)
+	identifier, _ := s.SendMessageType(channel, msg.Italic(message), true)
 	return identifier
 }
 
 func (s *Slack) ReplyToMessageIdentifier(channel, message, identifier string) (string, bool) {
-	resp, err := http.PostForm("https://slack.com/api/chat.postMessage",
-		url.Values{"token": {s.config.Slack.Token},
-			"as_user":   {"true"},
-			"channel":   {channel},
-			"text":      {message},
-			"thread_ts": {identifier},
-		})
-
-	if err != nil {
-		log.Printf("Error sending Slack reply: %s", err)
-		return "", false
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		log.Printf("Error reading Slack API body: %s", err)
-		return "", false
-	}
-
-	log.Println(string(body))
-
-	type MessageResponse struct {
-		OK        bool   `json:"ok"`
+	var mr struct {
 		Timestamp string `json:"ts"`
 	}
-
-	var mr MessageResponse
-	err = json.Unmarshal(body, &mr)
+	err := s.api("chat.postMessage", url.Values{
+		"as_user":   {"true"},
+		"channel":   {channel},
+		"text":      {s.render(message)},
+		"thread_ts": {identifier},
+	}, &mr)
 	if err != nil {
-		log.Printf("Error parsing message response: %s", err)
-		return "", false
-	}
-
-	if !mr.OK {
+		log.Printf("Error sending Slack reply: %s", err)
 		return "", false
 	}
-
-	return mr.Timestamp, err == nil
+	return mr.Timestamp, true
 }
 
+// ReplyToMessage answers in replyTo's thread, starting one if it isn't in one.
 func (s *Slack) ReplyToMessage(channel, message string, replyTo msg.Message) (string, bool) {
-	return s.ReplyToMessageIdentifier(channel, message, replyTo.AdditionalData["RAW_SLACK_TIMESTAMP"])
+	thread := replyTo.ThreadID
+	if thread == "" {
+		thread = replyTo.ID
+	}
+	return s.ReplyToMessageIdentifier(channel, message, thread)
 }
 
 func (s *Slack) React(channel, reaction string, message msg.Message) bool {
 	log.Printf("Reacting in %s: %s", channel, reaction)
-	resp, err := http.PostForm("https://slack.com/api/reactions.add",
-		url.Values{"token": {s.config.Slack.Token},
-			"name":      {reaction},
-			"channel":   {channel},
-			"timestamp": {message.AdditionalData["RAW_SLACK_TIMESTAMP"]}})
+	err := s.api("reactions.add", url.Values{
+		"name":      {reaction},
+		"channel":   {channel},
+		"timestamp": {message.ID},
+	}, nil)
 	if err != nil {
-		log.Println("reaction failed: %s", err)
+		log.Printf("reaction failed: %s", err)
 		return false
 	}
-	return checkReturnStatus(resp)
+	return true
 }
 
 func (s *Slack) Edit(channel, newMessage, identifier string) bool {
 	log.Printf("Editing in (%s) %s: %s", identifier, channel, newMessage)
-	resp, err := http.PostForm("https://slack.com/api/chat.update",
-		url.Values{"token": {s.config.Slack.Token},
-			"channel": {channel},
-			"text":    {newMessage},
-			"ts":      {identifier}})
+	err := s.api("chat.update", url.Values{
+		"channel": {channel},
+		"text":    {s.render(newMessage)},
+		"ts":      {identifier},
+	}, nil)
 	if err != nil {
-		log.Println("edit failed: %s", err)
+		log.Printf("edit failed: %s", err)
 		return false
 	}
-	return checkReturnStatus(resp)
+	return true
 }
 
 func (s *Slack) GetEmojiList() map[string]string {
@@ -334,146 +222,175 @@ func (s *Slack) GetEmojiList() map[string]string {
 }
 
 func (s *Slack) populateEmojiList() {
-	resp, err := http.PostForm("https://slack.com/api/emoji.list",
-		url.Values{"token": {s.config.Slack.Token}})
-	if err != nil {
+	var list struct {
+		Emoji map[string]string `json:"emoji"`
+	}
+	if err := s.api("emoji.list", nil, &list); err != nil {
 		log.Printf("Error retrieving emoji list from Slack: %s", err)
 		return
 	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		log.Fatalf("Error reading Slack API body: %s", err)
-	}
-
-	type EmojiListResponse struct {
-		OK    bool              `json:ok`
-		Emoji map[string]string `json:emoji`
-	}
-
-	var list EmojiListResponse
-	err = json.Unmarshal(body, &list)
-	if err != nil {
-		log.Fatalf("Error parsing emoji list: %s", err)
-	}
 	s.emoji = list.Emoji
 }
 
-func (s *Slack) receiveMessage() (slackMessage, error) {
-	var msg []byte
-	m := slackMessage{}
-	err := websocket.Message.Receive(s.ws, &msg)
-	if err != nil {
-		log.Println("Error decoding WS message")
-		return m, err
-	}
-	err2 := json.Unmarshal(msg, &m)
-	return m, err2
-}
-
 // I think it's horseshit that I have to do this
 func slackTStoTime(t string) time.Time {
 	ts := strings.Split(t, ".")
 	sec, _ := strconv.ParseInt(ts[0], 10, 64)
-	nsec, _ := strconv.ParseInt(ts[1], 10, 64)
+	var nsec int64
+	if len(ts) > 1 {
+		nsec, _ = strconv.ParseInt(ts[1], 10, 64)
+	}
 	return time.Unix(sec, nsec)
 }
 
+// Serve connects to Slack and dispatches events until the connection can't be
+// recovered.
 func (s *Slack) Serve() error {
-	s.connect()
+	if s.eventReceived == nil || s.messageReceived == nil {
+		return fmt.Errorf("Missing an event handler")
+	}
+
+	var auth struct {
+		UserID string `json:"user_id"`
+	}
+	if err := s.api("auth.test", nil, &auth); err != nil {
+		if isFatal(err) {
+			return bot.Permanent(fmt.Errorf("Slack auth failed: %s", err))
+		}
+		return fmt.Errorf("Slack auth failed: %s", err)
+	}
+	s.id = auth.UserID
+
 	s.populateEmojiList()
-	for {
-		msg, err := s.receiveMessage()
-		if err != nil && err == io.EOF {
-			log.Fatalf("Slack API EOF")
-		} else if err != nil {
-			return fmt.Errorf("Slack API error: %s", err)
+	s.markAllChannelsRead()
+
+	switch s.mode() {
+	case socketMode:
+		return s.serveSocket()
+	case eventsMode:
+		for ev := range s.events {
+			s.handleEvent(ev)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown Slack mode: %s", s.mode())
+}
+
+// handleEvent dispatches the inner event of an Events API callback, which is
+// delivered identically by Socket Mode and the Events API.
+func (s *Slack) handleEvent(ev json.RawMessage) {
+	var m slackMessage
+	if err := json.Unmarshal(ev, &m); err != nil {
+		log.Printf("Error decoding Slack event: %s", err)
+		return
+	}
+
+	switch m.Type {
+	case "message":
+		switch m.SubType {
+		case "message_changed":
+			s.messageChanged(m)
+			return
+		case "message_deleted":
+			s.messageDeleted(m)
+			return
 		}
-		switch msg.Type {
-		case "message":
-			botOK := true
-			if msg.BotID != "" {
-				u, _ := s.getUser(msg.User)
-				if u == "" && msg.Username != "" {
-					u = msg.Username
-				}
-				log.Printf("User: %s, BotList: %+v", u, s.config.BotList)
-				botOK = s.config.BotList[strings.Title(u)]
+		botOK := true
+		if m.BotID != "" {
+			u, _ := s.getUser(m.User)
+			if u == "" && m.Username != "" {
+				u = m.Username
 			}
-			if botOK && !msg.Hidden && msg.ThreadTs == "" {
-				m := s.buildMessage(msg)
-				if m.Time.Before(s.lastRecieved) {
-					log.Printf("Ignoring message: %+v\nlastRecieved: %v msg: %v", msg.ID, s.lastRecieved, m.Time)
-				} else {
-					s.lastRecieved = m.Time
-					s.messageReceived(m)
-				}
-			} else if msg.ThreadTs != "" {
-				//we're throwing away some information here by not parsing the correct reply object type, but that's okay
-				s.replyMessageReceived(s.buildLightReplyMessage(msg), msg.ThreadTs)
+			log.Printf("User: %s, BotList: %+v", u, s.config.BotList)
+			botOK = s.config.BotList[strings.Title(u)]
+		}
+		if botOK && !m.Hidden {
+			msg := s.buildMessage(m)
+			if msg.Time.Before(s.lastRecieved) {
+				log.Printf("Ignoring message: %+v\nlastRecieved: %v msg: %v", m.Ts, s.lastRecieved, msg.Time)
 			} else {
-				log.Printf("THAT MESSAGE WAS HIDDEN: %+v", msg.ID)
+				s.lastRecieved = msg.Time
+				s.messageReceived(msg)
 			}
-		case "error":
-			log.Printf("Slack error, code: %d, message: %s", msg.Error.Code, msg.Error.Msg)
-		case "": // what even is this?
-		case "hello":
-		case "presence_change":
-		case "user_typing":
-		case "reconnect_url":
-		case "desktop_notification":
-			// squeltch this stuff
-			continue
-		default:
-			log.Printf("Unhandled Slack message type: '%s'", msg.Type)
+		} else {
+			log.Printf("THAT MESSAGE WAS HIDDEN: %+v", m.Ts)
 		}
+	case "reaction_added", "reaction_removed":
+		s.reaction(m)
+	default:
+		log.Printf("Unhandled Slack event type: '%s'", m.Type)
 	}
 }
 
-var urlDetector = regexp.MustCompile(`<(.+)://([^|^>]+).*>`)
-
-// Convert a slackMessage to a msg.Message
-func (s *Slack) buildMessage(m slackMessage) msg.Message {
-	text := html.UnescapeString(m.Text)
-
-	text = fixText(s.getUser, text)
-
-	isCmd, text := bot.IsCmd(s.config, text)
-
-	isAction := m.SubType == "me_message"
-
+// reaction passes a reaction_added or reaction_removed event on to the bot.
+func (s *Slack) reaction(m slackMessage) {
+	if m.Item.Type != "message" {
+		return
+	}
+	kind := msg.ReactionAdded
+	if m.Type == "reaction_removed" {
+		kind = msg.ReactionRemoved
+	}
 	u, _ := s.getUser(m.User)
-	if m.Username != "" {
-		u = m.Username
+	s.eventReceived(msg.Message{
+		User:     &user.User{ID: m.User, Name: u},
+		Channel:  m.Item.Channel,
+		Time:     slackTStoTime(m.EventTs),
+		Kind:     kind,
+		Target:   m.Item.Ts,
+		Reaction: m.Reaction,
+	})
+}
+
+// messageChanged passes an edit on to the bot as the message's new contents.
+func (s *Slack) messageChanged(m slackMessage) {
+	if m.Message == nil {
+		return
 	}
+	edited := *m.Message
+	edited.Channel = m.Channel
+	ev := s.buildMessage(edited)
+	ev.Kind = msg.MessageEdited
+	ev.Target = edited.Ts
+	// Slack sends these when it unfurls links, too
+	if m.PreviousMessage != nil {
+		if m.PreviousMessage.Text == edited.Text {
+			return
+		}
+		previous := *m.PreviousMessage
+		previous.Channel = m.Channel
+		prev := s.buildMessage(previous)
+		ev.Previous = &prev
+	}
+	s.eventReceived(ev)
+}
 
-	tstamp := slackTStoTime(m.Ts)
-
-	return msg.Message{
-		User: &user.User{
-			ID:   m.User,
-			Name: u,
-		},
-		Body:    text,
-		Raw:     m.Text,
+// messageDeleted passes a deletion on to the bot.
+func (s *Slack) messageDeleted(m slackMessage) {
+	ev := msg.Message{
+		User:    &user.User{},
 		Channel: m.Channel,
-		Command: isCmd,
-		Action:  isAction,
-		Host:    string(m.ID),
-		Time:    tstamp,
-		AdditionalData: map[string]string{
-			"RAW_SLACK_TIMESTAMP": m.Ts,
-		},
+		Time:    slackTStoTime(m.Ts),
+		Kind:    msg.MessageDeleted,
+		Target:  m.DeletedTs,
 	}
+	if m.PreviousMessage != nil {
+		previous := *m.PreviousMessage
+		previous.Channel = m.Channel
+		prev := s.buildMessage(previous)
+		ev.User = prev.User
+		ev.Previous = &prev
+	}
+	s.eventReceived(ev)
 }
 
-func (s *Slack) buildLightReplyMessage(m slackMessage) msg.Message {
+// Convert a slackMessage to a msg.Message
+func (s *Slack) buildMessage(m slackMessage) msg.Message {
 	text := html.UnescapeString(m.Text)
 
 	text = fixText(s.getUser, text)
 
-	isCmd, text := bot.IsCmd(s.config, text)
+	isCmd, text := bot.IsCmd(s.config.ForChannel(m.Channel), text)
 
 	isAction := m.SubType == "me_message"
 
@@ -484,18 +401,25 @@ func (s *Slack) buildLightReplyMessage(m slackMessage) msg.Message {
 
 	tstamp := slackTStoTime(m.Ts)
 
+	// The parent of a thread carries its own ts as thread_ts
+	thread := m.ThreadTs
+	if thread == m.Ts {
+		thread = ""
+	}
+
 	return msg.Message{
 		User: &user.User{
 			ID:   m.User,
 			Name: u,
 		},
-		Body:    text,
-		Raw:     m.Text,
-		Channel: m.Channel,
-		Command: isCmd,
-		Action:  isAction,
-		Host:    string(m.ID),
-		Time:    tstamp,
+		Body:     text,
+		Raw:      m.Text,
+		Channel:  m.Channel,
+		Command:  isCmd,
+		Action:   isAction,
+		Time:     tstamp,
+		ID:       m.Ts,
+		ThreadID: thread,
 		AdditionalData: map[string]string{
 			"RAW_SLACK_TIMESTAMP": m.Ts,
 		},
@@ -504,179 +428,116 @@ func (s *Slack) buildLightReplyMessage(m slackMessage) msg.Message {
 
 // markAllChannelsRead gets a list of all channels and marks each as read
 func (s *Slack) markAllChannelsRead() {
-	chs := s.getAllChannels()
+	chs, err := s.getAllChannels()
+	if err != nil {
+		log.Printf("Error listing channels: %s", err)
+		return
+	}
 	log.Printf("Got list of channels to mark read: %+v", chs)
 	for _, ch := range chs {
-		s.markChannelAsRead(ch.ID)
+		if err := s.markChannelAsRead(ch.ID); err != nil {
+			log.Printf("Error marking %s read: %s", ch.ID, err)
+			continue
+		}
+		log.Printf("Marked %s as read", ch.ID)
 	}
 	log.Printf("Finished marking channels read")
 }
 
-// getAllChannels returns info for all channels joined
-func (s *Slack) getAllChannels() []slackChannelListItem {
-	u := s.url + "channels.list"
-	resp, err := http.PostForm(u,
-		url.Values{"token": {s.config.Slack.Token}})
-	if err != nil {
-		log.Printf("Error posting user info request: %s",
-			err)
-		return nil
-	}
-	if resp.StatusCode != 200 {
-		log.Printf("Error posting user info request: %d",
-			resp.StatusCode)
-		return nil
-	}
-	defer resp.Body.Close()
-	var chanInfo slackChannelListResp
-	err = json.NewDecoder(resp.Body).Decode(&chanInfo)
-	if err != nil || !chanInfo.Ok {
-		log.Println("Error decoding response: ", err)
-		return nil
+// Get username for Slack user ID
+func (s *Slack) getUser(id string) (string, bool) {
+	s.usersMu.Lock()
+	name, ok := s.users[id]
+	s.usersMu.Unlock()
+	if ok {
+		return name, true
 	}
-	return chanInfo.Channels
-}
 
-// markAsRead marks a channel read
-func (s *Slack) markChannelAsRead(slackChanId string) error {
-	u := s.url + "channels.info"
-	resp, err := http.PostForm(u,
-		url.Values{"token": {s.config.Slack.Token}, "channel": {slackChanId}})
-	if err != nil {
-		log.Printf("Error posting user info request: %s",
-			err)
-		return err
-	}
-	if resp.StatusCode != 200 {
-		log.Printf("Error posting user info request: %d",
-			resp.StatusCode)
-		return err
-	}
-	defer resp.Body.Close()
-	var chanInfo slackChannelInfoResp
-	err = json.NewDecoder(resp.Body).Decode(&chanInfo)
-	log.Printf("%+v, %+v", err, chanInfo)
-	if err != nil || !chanInfo.Ok {
-		log.Println("Error decoding response: ", err)
-		return err
-	}
-
-	u = s.url + "channels.mark"
-	resp, err = http.PostForm(u,
-		url.Values{"token": {s.config.Slack.Token}, "channel": {slackChanId}, "ts": {chanInfo.Channel.Latest.Ts}})
-	if err != nil {
-		log.Printf("Error posting user info request: %s",
-			err)
-		return err
-	}
-	if resp.StatusCode != 200 {
-		log.Printf("Error posting user info request: %d",
-			resp.StatusCode)
-		return err
-	}
-	defer resp.Body.Close()
-	var markInfo map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&markInfo)
-	log.Printf("%+v, %+v", err, markInfo)
-	if err != nil {
-		log.Println("Error decoding response: ", err)
-		return err
+	log.Printf("User %s not already found, requesting info", id)
+	var userInfo slackUserInfoResp
+	if err := s.api("users.info", url.Values{"user": {id}}, &userInfo); err != nil {
+		log.Printf("Error getting user info: %s", err)
+		return "UNKNOWN", false
 	}
 
-	log.Printf("Marked %s as read", slackChanId)
-	return nil
+	s.usersMu.Lock()
+	s.users[id] = userInfo.User.Name
+	s.usersMu.Unlock()
+	return userInfo.User.Name, true
 }
 
-func (s *Slack) connect() {
-	token := s.config.Slack.Token
-	url := fmt.Sprintf("https://slack.com/api/rtm.connect?token=%s", token)
-	resp, err := http.Get(url)
-	if err != nil {
-		return
+// userID finds the ID of the user with the given name, fetching the user list
+// if we haven't seen them yet.
+func (s *Slack) userID(name string) (string, bool) {
+	s.usersMu.Lock()
+	id, ok := s.findUser(name)
+	stale := !ok && time.Since(s.usersListed) >= userListInterval
+	if stale {
+		// claim the fetch so other lookups don't start one too
+		s.usersListed = time.Now()
 	}
-	if resp.StatusCode != 200 {
-		log.Fatalf("Slack API failed. Code: %d", resp.StatusCode)
+	s.usersMu.Unlock()
+	if !stale {
+		return id, ok
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
-	if err != nil {
-		log.Fatalf("Error reading Slack API body: %s", err)
-	}
-	var rtm rtmStart
-	err = json.Unmarshal(body, &rtm)
+
+	// the user list can take a while, so don't keep everyone else waiting
+	users, err := s.getAllUsers()
 	if err != nil {
-		return
+		log.Printf("Error listing users: %s", err)
+		return "", false
 	}
-
-	if !rtm.Ok {
-		log.Fatalf("Slack error: %s", rtm.Error)
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	for id, n := range users {
+		s.users[id] = n
 	}
+	return s.findUser(name)
+}
 
-	s.url = "https://slack.com/api/"
-	s.id = rtm.Self.ID
-
-	s.markAllChannelsRead()
-
-	s.ws, err = websocket.Dial(rtm.URL, "", s.url)
-	if err != nil {
-		log.Fatal(err)
+// findUser searches the user cache by name. The caller must hold usersMu.
+func (s *Slack) findUser(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "@")
+	for id, n := range s.users {
+		if strings.EqualFold(n, name) {
+			return id, true
+		}
 	}
+	return "", false
 }
 
-// Get username for Slack user ID
-func (s *Slack) getUser(id string) (string, bool) {
-	if name, ok := s.users[id]; ok {
-		return name, true
+// OpenDM opens a direct message conversation with a user.
+func (s *Slack) OpenDM(u user.User) (string, error) {
+	id := u.ID
+	if id == "" {
+		var ok bool
+		if id, ok = s.userID(u.Name); !ok {
+			return "", fmt.Errorf("no Slack user named %s", u.Name)
+		}
 	}
 
-	log.Printf("User %s not already found, requesting info", id)
-	u := s.url + "users.info"
-	resp, err := http.PostForm(u,
-		url.Values{"token": {s.config.Slack.Token}, "user": {id}})
-	if err != nil || resp.StatusCode != 200 {
-		log.Printf("Error posting user info request: %d %s",
-			resp.StatusCode, err)
-		return "UNKNOWN", false
+	var resp struct {
+		Channel struct {
+			ID string `json:"id"`
+		} `json:"channel"`
 	}
-	defer resp.Body.Close()
-	var userInfo slackUserInfoResp
-	err = json.NewDecoder(resp.Body).Decode(&userInfo)
-	if err != nil {
-		log.Println("Error decoding response: ", err)
-		return "UNKNOWN", false
+	if err := s.api("conversations.open", url.Values{"users": {id}}, &resp); err != nil {
+		return "", err
 	}
-	s.users[id] = userInfo.User.Name
-	return s.users[id], true
+	return resp.Channel.ID, nil
 }
 
 // Who gets usernames out of a channel
 func (s *Slack) Who(id string) []string {
 	log.Println("Who is queried for ", id)
-	u := s.url + "channels.info"
-	resp, err := http.PostForm(u,
-		url.Values{"token": {s.config.Slack.Token}, "channel": {id}})
+	members, err := s.getMembers(id)
 	if err != nil {
-		log.Printf("Error posting user info request: %s",
-			err)
+		log.Printf("Error getting channel members: %s", err)
 		return []string{}
 	}
-	if resp.StatusCode != 200 {
-		log.Printf("Error posting user info request: %d",
-			resp.StatusCode)
-		return []string{}
-	}
-	defer resp.Body.Close()
-	var chanInfo slackChannelInfoResp
-	err = json.NewDecoder(resp.Body).Decode(&chanInfo)
-	if err != nil || !chanInfo.Ok {
-		log.Println("Error decoding response: ", err)
-		return []string{}
-	}
-
-	log.Printf("%#v", chanInfo.Channel)
 
 	handles := []string{}
-	for _, member := range chanInfo.Channel.Members {
+	for _, member := range members {
 		u, _ := s.getUser(member)
 		handles = append(handles, u)
 	}
@@ -0,0 +1,452 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/velour/catbase/bot"
+	"github.com/velour/catbase/bot/msg"
+	"github.com/velour/catbase/bot/user"
+	"github.com/velour/catbase/config"
+	"golang.org/x/net/websocket"
+)
+
+// fakeSlack is just enough of the Web API and Socket Mode to run the
+// connector against.
+type fakeSlack struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls map[string]int
+	acks  []string
+
+	// sockets is called for each Socket Mode connection in turn
+	sockets []func(ws *websocket.Conn)
+	// authError, if set, is what every call fails with
+	authError string
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := &fakeSlack{calls: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", f.serveAPI)
+	mux.Handle("/socket", websocket.Handler(f.serveSocket))
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeSlack) serveAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	r.ParseForm()
+	f.mu.Lock()
+	f.calls[method]++
+	f.mu.Unlock()
+
+	resp := map[string]interface{}{"ok": true}
+	if f.authError != "" {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": f.authError})
+		return
+	}
+	switch method {
+	case "auth.test":
+		resp["user_id"] = "UBOT"
+	case "apps.connections.open":
+		resp["url"] = "ws" + strings.TrimPrefix(f.URL, "http") + "/socket"
+	case "users.info":
+		resp["user"] = map[string]string{"id": r.Form.Get("user"), "name": "nick" + r.Form.Get("user")}
+	case "conversations.members":
+		// Two pages to exercise the cursor handling
+		if r.Form.Get("cursor") == "" {
+			resp["members"] = []string{"U1", "U2"}
+			resp["response_metadata"] = map[string]string{"next_cursor": "page2"}
+		} else {
+			resp["members"] = []string{"U3"}
+			resp["response_metadata"] = map[string]string{"next_cursor": ""}
+		}
+	case "chat.postMessage":
+		resp["ts"] = "1234.5678"
+	case "users.list":
+		resp["members"] = []map[string]string{{"id": "U9", "name": "alice"}}
+	case "conversations.open":
+		resp["channel"] = map[string]string{"id": "D" + r.Form.Get("users")}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeSlack) serveSocket(ws *websocket.Conn) {
+	f.mu.Lock()
+	if len(f.sockets) == 0 {
+		f.mu.Unlock()
+		// Nothing more to say, hang around until the test ends.
+		time.Sleep(time.Minute)
+		return
+	}
+	next := f.sockets[0]
+	f.sockets = f.sockets[1:]
+	f.mu.Unlock()
+	next(ws)
+}
+
+func (f *fakeSlack) readAck(ws *websocket.Conn) {
+	var ack map[string]string
+	if err := websocket.JSON.Receive(ws, &ack); err == nil {
+		f.mu.Lock()
+		f.acks = append(f.acks, ack["envelope_id"])
+		f.mu.Unlock()
+	}
+}
+
+func messageEnvelope(id, text string) map[string]interface{} {
+	ts := fmt.Sprintf("%d.000100", time.Now().Add(time.Minute).Unix())
+	return map[string]interface{}{
+		"envelope_id": id,
+		"type":        "events_api",
+		"payload": map[string]interface{}{
+			"type": "event_callback",
+			"event": map[string]string{
+				"type":    "message",
+				"channel": "C1",
+				"user":    "U1",
+				"text":    text,
+				"ts":      ts,
+			},
+		},
+	}
+}
+
+func newTestSlack(f *fakeSlack) *Slack {
+	c := &config.Config{}
+	c.Nick = "catbase"
+	c.Slack.Token = "xoxb-test"
+	c.Slack.AppToken = "xapp-test"
+	c.Slack.SigningSecret = "secret"
+	c.Slack.APIURL = f.URL + "/api"
+	return New(c)
+}
+
+func TestSocketModeReconnects(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+
+	f.sockets = []func(*websocket.Conn){
+		func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, map[string]string{"type": "hello"})
+			websocket.JSON.Send(ws, messageEnvelope("e1", "first"))
+			f.readAck(ws)
+			websocket.JSON.Send(ws, map[string]string{"type": "disconnect", "reason": "refresh_requested"})
+		},
+		func(ws *websocket.Conn) {
+			websocket.JSON.Send(ws, map[string]string{"type": "hello"})
+			websocket.JSON.Send(ws, messageEnvelope("e2", "second"))
+			f.readAck(ws)
+			time.Sleep(time.Minute)
+		},
+	}
+
+	s := newTestSlack(f)
+	received := make(chan msg.Message, 2)
+	s.RegisterMessageReceived(func(m msg.Message) { received <- m })
+	s.RegisterEventReceived(func(msg.Message) {})
+	go s.Serve()
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case m := <-received:
+			assert.Equal(t, expected, m.Body)
+			assert.Equal(t, "C1", m.Channel)
+			assert.Equal(t, "nickU1", m.User.Name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	// The ack for the last envelope may still be in flight.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		n := len(f.acks)
+		f.mu.Unlock()
+		if n == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Equal(t, 2, f.calls["apps.connections.open"])
+	assert.Equal(t, []string{"e1", "e2"}, f.acks)
+	assert.Equal(t, "UBOT", s.id)
+}
+
+func TestWhoPaginates(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	assert.Equal(t, []string{"nickU1", "nickU2", "nickU3"}, s.Who("C1"))
+	assert.Equal(t, 2, f.calls["conversations.members"])
+}
+
+func signedRequest(body, secret string, ts time.Time) *http.Request {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + stamp + ":" + body))
+	r := httptest.NewRequest("POST", "/slack/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", stamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestEventsURLVerification(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	w := httptest.NewRecorder()
+	body := `{"type":"url_verification","challenge":"abc123"}`
+	s.serveEvents(w, signedRequest(body, "secret", time.Now()))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "abc123", w.Body.String())
+}
+
+func TestEventsRejectsBadSignature(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	body := `{"type":"url_verification","challenge":"abc123"}`
+
+	w := httptest.NewRecorder()
+	s.serveEvents(w, signedRequest(body, "wrong", time.Now()))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	s.serveEvents(w, signedRequest(body, "secret", time.Now().Add(-time.Hour)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestEventsQueuesCallbacks(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	w := httptest.NewRecorder()
+	body := `{"type":"event_callback","event":{"type":"message","text":"hi"}}`
+	s.serveEvents(w, signedRequest(body, "secret", time.Now()))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	select {
+	case ev := <-s.events:
+		assert.Contains(t, string(ev), `"text":"hi"`)
+	default:
+		t.Fatal("event was not queued")
+	}
+}
+
+func TestEventsRetriesAreDropped(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	body := `{"type":"event_callback","event_id":"Ev1","event":{"type":"message","text":"hi"}}`
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := signedRequest(body, "secret", time.Now())
+		if i > 0 {
+			r.Header.Set("X-Slack-Retry-Num", "1")
+		}
+		s.serveEvents(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Len(t, s.events, 1)
+}
+
+func TestSeenEventsForget(t *testing.T) {
+	var e seenEvents
+	assert.True(t, e.first("Ev0"))
+	assert.False(t, e.first("Ev0"))
+	assert.True(t, e.first(""))
+	assert.True(t, e.first(""))
+	for i := 1; i <= maxSeenEvents; i++ {
+		e.first(fmt.Sprintf("Ev%d", i))
+	}
+	assert.True(t, e.first("Ev0"))
+	assert.Len(t, e.order, maxSeenEvents)
+}
+
+func TestThreadMessagesAreDispatched(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	var received []msg.Message
+	s.RegisterMessageReceived(func(m msg.Message) { received = append(received, m) })
+
+	ts := fmt.Sprintf("%d.000100", time.Now().Add(time.Minute).Unix())
+	reply := fmt.Sprintf("%d.000200", time.Now().Add(time.Minute).Unix())
+	s.handleEvent(json.RawMessage(`{"type":"message","channel":"C1","user":"U1","text":"parent","ts":"` + ts + `","thread_ts":"` + ts + `"}`))
+	s.handleEvent(json.RawMessage(`{"type":"message","channel":"C1","user":"U1","text":"catbase: hi","ts":"` + reply + `","thread_ts":"` + ts + `"}`))
+
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, ts, received[0].ID)
+		assert.False(t, received[0].InThread())
+		assert.Equal(t, reply, received[1].ID)
+		assert.Equal(t, ts, received[1].ThreadID)
+		assert.True(t, received[1].Command)
+	}
+}
+
+func TestReplyToMessageUsesThread(t *testing.T) {
+	var threads []string
+	f := newFakeSlack(t)
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		threads = append(threads, r.Form.Get("thread_ts"))
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "ts": "9.9"})
+	})
+	s := newTestSlack(f)
+
+	s.ReplyToMessage("C1", "top", msg.Message{ID: "1.1"})
+	s.ReplyToMessage("C1", "nested", msg.Message{ID: "1.2", ThreadID: "1.1"})
+	assert.Equal(t, []string{"1.1", "1.1"}, threads)
+}
+
+func TestEventsAreTyped(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	var events []msg.Message
+	s.RegisterEventReceived(func(m msg.Message) { events = append(events, m) })
+	s.RegisterMessageReceived(func(msg.Message) { t.Error("events must not be dispatched as messages") })
+
+	s.handleEvent(json.RawMessage(`{"type":"reaction_added","user":"U2","reaction":"+1","item":{"type":"message","channel":"C1","ts":"1.1"},"event_ts":"2.2"}`))
+	s.handleEvent(json.RawMessage(`{"type":"message","subtype":"message_changed","hidden":true,"channel":"C1","ts":"3.3",
+		"message":{"type":"message","user":"U1","text":"new","ts":"1.1"},
+		"previous_message":{"type":"message","user":"U1","text":"old","ts":"1.1"}}`))
+	s.handleEvent(json.RawMessage(`{"type":"message","subtype":"message_changed","hidden":true,"channel":"C1","ts":"3.4",
+		"message":{"type":"message","user":"U1","text":"same","ts":"1.1"},
+		"previous_message":{"type":"message","user":"U1","text":"same","ts":"1.1"}}`))
+	s.handleEvent(json.RawMessage(`{"type":"message","subtype":"message_deleted","hidden":true,"channel":"C1","ts":"4.4","deleted_ts":"1.1",
+		"previous_message":{"type":"message","user":"U1","text":"new","ts":"1.1"}}`))
+
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, msg.ReactionAdded, events[0].Kind)
+		assert.Equal(t, "+1", events[0].Reaction)
+		assert.Equal(t, "1.1", events[0].Target)
+		assert.Equal(t, "C1", events[0].Channel)
+		assert.Equal(t, "nickU2", events[0].User.Name)
+
+		assert.Equal(t, msg.MessageEdited, events[1].Kind)
+		assert.Equal(t, "new", events[1].Body)
+		assert.Equal(t, "1.1", events[1].Target)
+		assert.Equal(t, "old", events[1].Previous.Body)
+
+		assert.Equal(t, msg.MessageDeleted, events[2].Kind)
+		assert.Equal(t, "1.1", events[2].Target)
+		assert.Equal(t, "nickU1", events[2].User.Name)
+		assert.Equal(t, "new", events[2].Previous.Body)
+	}
+}
+
+func TestSendRich(t *testing.T) {
+	calls := map[string]*http.Request{}
+	f := newFakeSlack(t)
+	defer f.Close()
+	f.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		method := strings.TrimPrefix(r.URL.Path, "/api/")
+		if _, ok := calls[method]; ok {
+			method += "2"
+		}
+		calls[method] = r
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "ts": "9.9"})
+	})
+	s := newTestSlack(f)
+
+	id := s.SendRich("C1", msg.Rich{
+		Text:     "hello",
+		Blocks:   []msg.Block{{Kind: msg.BlockSection, Text: msg.Bold("hi")}},
+		ThreadID: "1.1",
+		Attachments: []msg.Attachment{
+			{Kind: msg.AttachSnippet, Name: "art.txt", Data: []byte("art")},
+			{Kind: msg.AttachImage, Name: "cat.png", Data: []byte("png")},
+		},
+	})
+	assert.Equal(t, "9.9", id)
+
+	post := calls["chat.postMessage"]
+	if assert.NotNil(t, post) {
+		assert.Equal(t, "hello", post.Form.Get("text"))
+		assert.Equal(t, "1.1", post.Form.Get("thread_ts"))
+		assert.JSONEq(t, `[{"type":"section","text":{"type":"mrkdwn","text":"*hi*"}}]`, post.Form.Get("blocks"))
+	}
+
+	snippet := calls["files.upload"]
+	if assert.NotNil(t, snippet) {
+		assert.Equal(t, "art", snippet.Form.Get("content"))
+		assert.Equal(t, "C1", snippet.Form.Get("channels"))
+	}
+
+	file := calls["files.upload2"]
+	if assert.NotNil(t, file) && assert.NotNil(t, file.MultipartForm) {
+		assert.Equal(t, "cat.png", file.MultipartForm.File["file"][0].Filename)
+		assert.Equal(t, "1.1", file.FormValue("thread_ts"))
+	}
+}
+
+func TestMentionsResolveNicks(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	assert.Equal(t, "hi <@U9> and <@U2> and @nobody",
+		s.render("hi "+msg.Mention("alice", "")+" and "+msg.Mention("bob", "U2")+" and "+msg.Mention("nobody", "")))
+	// The user list is only fetched once however many misses there are.
+	assert.Equal(t, 1, f.calls["users.list"])
+}
+
+func TestOpenDM(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	s := newTestSlack(f)
+
+	ch, err := s.OpenDM(user.User{ID: "U1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "DU1", ch)
+
+	ch, err = s.OpenDM(user.User{Name: "alice"})
+	assert.Nil(t, err)
+	assert.Equal(t, "DU9", ch)
+
+	_, err = s.OpenDM(user.User{Name: "nobody"})
+	assert.NotNil(t, err)
+}
+
+func TestServeStopsOnBadToken(t *testing.T) {
+	f := newFakeSlack(t)
+	defer f.Close()
+	f.authError = "invalid_auth"
+	s := newTestSlack(f)
+	s.RegisterMessageReceived(func(msg.Message) {})
+	s.RegisterEventReceived(func(msg.Message) {})
+
+	err := s.Serve()
+	assert.Error(t, err)
+	assert.True(t, bot.IsPermanent(err))
+}
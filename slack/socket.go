@@ -0,0 +1,143 @@
+// © 2018 the CatBase Authors under the WTFPL license. See AUTHORS for the list of authors.
+
+package slack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/velour/catbase/bot"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// initialBackoff is how long to wait before the first reconnection
+	// attempt. Each consecutive failure doubles it up to maxBackoff.
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+	// minConnected is how long a connection has to last after hello for
+	// the backoff to start over.
+	minConnected = 30 * time.Second
+)
+
+// socketEnvelope is a frame received over a Socket Mode connection.
+type socketEnvelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// eventCallback is the outer payload of an Events API delivery.
+type eventCallback struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// serveSocket keeps a Socket Mode connection up, reconnecting whenever Slack
+// asks us to or the connection drops. It only returns if Slack refuses to give
+// us a connection at all.
+func (s *Slack) serveSocket() error {
+	backoff := initialBackoff
+	for {
+		ws, err := s.openSocket()
+		if err != nil && isFatal(err) {
+			return bot.Permanent(err)
+		}
+		if err == nil {
+			var hello time.Time
+			hello, err = s.readSocket(ws)
+			ws.Close()
+			// only a connection that said hello and then stayed up
+			// earns a quick reconnect
+			if !hello.IsZero() && time.Since(hello) >= minConnected {
+				backoff = initialBackoff
+				if err != nil {
+					log.Printf("Slack socket closed: %s", err)
+				}
+				continue
+			}
+			if err == nil {
+				err = errors.New("disconnected right after connecting")
+			}
+		}
+		log.Printf("Slack socket connection failed, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// isFatal reports whether a connection error means retrying is pointless.
+func isFatal(err error) bool {
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case "invalid_auth", "not_authed", "account_inactive", "not_allowed_token_type":
+		return true
+	}
+	return false
+}
+
+// openSocket asks Slack for a Socket Mode URL and dials it.
+func (s *Slack) openSocket() (*websocket.Conn, error) {
+	var open struct {
+		URL string `json:"url"`
+	}
+	err := s.client.call(s.config.Slack.AppToken, "apps.connections.open", nil, &open)
+	if err != nil {
+		return nil, err
+	}
+	return websocket.Dial(open.URL, "", s.url)
+}
+
+// readSocket dispatches frames until Slack disconnects us or the connection
+// fails. A nil error means Slack asked us to reconnect. It returns when Slack
+// said hello, or the zero time if it never did.
+func (s *Slack) readSocket(ws *websocket.Conn) (time.Time, error) {
+	var hello time.Time
+	for {
+		var env socketEnvelope
+		if err := websocket.JSON.Receive(ws, &env); err != nil {
+			return hello, err
+		}
+
+		// Slack redelivers anything we don't acknowledge promptly.
+		if env.EnvelopeID != "" {
+			ack := map[string]string{"envelope_id": env.EnvelopeID}
+			if err := websocket.JSON.Send(ws, ack); err != nil {
+				return hello, fmt.Errorf("acknowledging %s: %s", env.EnvelopeID, err)
+			}
+		}
+
+		switch env.Type {
+		case "hello":
+			log.Println("Connected to Slack")
+			hello = time.Now()
+		case "disconnect":
+			log.Printf("Slack asked us to reconnect: %s", env.Reason)
+			return hello, nil
+		case "events_api":
+			var cb eventCallback
+			if err := json.Unmarshal(env.Payload, &cb); err != nil {
+				log.Printf("Error decoding Slack event callback: %s", err)
+				continue
+			}
+			if !s.seen.first(cb.EventID) {
+				log.Printf("Ignoring Slack event %s, it's a retry", cb.EventID)
+				continue
+			}
+			s.handleEvent(cb.Event)
+		default:
+			log.Printf("Unhandled Slack socket message type: '%s'", env.Type)
+		}
+	}
+}